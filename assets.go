@@ -13,38 +13,44 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"unicode/utf16"
+	"unicode/utf8"
 
 	"vimagination.zapto.org/keystore"
 )
 
-type hasher struct {
-	hash.Hash
-}
-
-func (h *hasher) ReadFrom(r io.Reader) (int64, error) {
-	return io.Copy(h.Hash, r)
-}
-
 type assetsDir struct {
 	folders
-	handler http.Handler
-	hashes  map[[sha256.Size]byte][]uint64
-	sync.Once
+	handler  http.Handler
+	hashes   hashIndex
+	variants *variants
+	meta     assetsMeta
+	uploads  uploads
+	fetcher  fetcher
+	types    sync.Map
+	strip    bool
+	restrict bool
 }
 
 func (a *assetsDir) Init(b *Battlemap, links links) error {
 	var (
 		location keystore.String
 		locname  string
+		name     string
+		quota    string
 		lm       linkManager
 	)
 
 	switch a.fileType {
 	case fileTypeImage:
 		locname = "ImageAssetsDir"
+		name = "images"
+		quota = "Images"
 		lm = links.images
 	case fileTypeAudio:
 		locname = "AudioAssetsDir"
+		name = "audio"
+		quota = "Audio"
 		lm = links.audio
 	default:
 		return ErrInvalidFileType
@@ -63,40 +69,172 @@ func (a *assetsDir) Init(b *Battlemap, links links) error {
 
 	a.handler = http.FileServer(http.Dir(l))
 
-	return a.folders.Init(b, assetStore, lm)
-}
+	if err := a.folders.Init(b, assetStore, lm); err != nil {
+		return err
+	}
 
-func (a *assetsDir) makeHashMap() {
-	a.hashes = make(map[[sha256.Size]byte][]uint64)
-	h := hasher{Hash: sha256.New()}
+	if err := a.initTrash(name, l); err != nil {
+		return err
+	}
 
-	for _, key := range a.Keys() {
-		id, err := strconv.ParseUint(key, 10, 64)
-		if err != nil {
-			continue
+	if err := a.initQuota(quota); err != nil {
+		return err
+	}
+
+	var restrict keystore.Uint8
+
+	if err := b.config.Get("RestrictAssets", &restrict); err != nil {
+		return fmt.Errorf("error getting asset restriction config: %w", err)
+	}
+
+	a.restrict = restrict != 0
+
+	if err := a.initMeta(); err != nil {
+		return err
+	}
+
+	if err := a.initHashes(); err != nil {
+		return err
+	}
+
+	a.trashed = func(ids []uint64) {
+		for _, id := range ids {
+			a.hashes.remove(id)
 		}
 
-		var hash [sha256.Size]byte
+		a.saveHashes()
+	}
 
-		if err := a.Get(key, &h); err != nil {
-			continue
+	if err := a.uploads.Init(&b.config, name); err != nil {
+		return err
+	}
+
+	if err := a.fetcher.Init(&b.config); err != nil {
+		return err
+	}
+
+	if a.fileType == fileTypeImage {
+		var strip keystore.Uint8
+
+		if err := b.config.Get("StripImageMetadata", &strip); err != nil {
+			return fmt.Errorf("error getting image metadata config: %w", err)
+		}
+
+		a.strip = strip != 0
+	}
+
+	if a.fileType == fileTypeImage {
+		a.variants = new(variants)
+
+		if err := a.variants.Init(&b.config); err != nil {
+			return err
 		}
 
-		h.Sum(hash[:0])
+		a.variants.prune(a.FileStore)
+	}
+
+	return nil
+}
 
-		a.hashes[hash] = append(a.hashes[hash], id)
+// cleanup moves unreferenced files into the trash, removing any image variants
+// generated from them.
+func (a *assetsDir) cleanup(l linkManager) {
+	a.folders.cleanup(l)
+	a.meta.mu.Lock()
 
-		h.Reset()
+	for id := range a.meta.index {
+		if !a.Exists(strconv.FormatUint(id, 10)) {
+			delete(a.meta.index, id)
+		}
+	}
+
+	a.Set(assetMetadata, a.meta.index)
+	a.meta.mu.Unlock()
+	a.pruneHashes()
+
+	if a.variants != nil {
+		a.variants.prune(a.FileStore)
 	}
 }
 
+func (a *assetsDir) RPCData(cd ConnData, method string, data json.RawMessage) (interface{}, error) {
+	if cd.IsAdmin() {
+		switch method {
+		case "list":
+			return a.list(), nil
+		case "info":
+			return a.info(data)
+		case "lookup":
+			return a.lookup(data)
+		case "fetch":
+			return a.fetch(cd, data)
+		case "restore":
+			var id uint64
+
+			if err := json.Unmarshal(data, &id); err != nil {
+				return nil, err
+			}
+
+			res, err := a.restore(cd, data)
+			if err == nil {
+				a.hashFile(id)
+				a.saveHashes()
+			}
+
+			return res, err
+		case "usage":
+			var id uint64
+
+			if err := json.Unmarshal(data, &id); err != nil {
+				return nil, err
+			}
+
+			t := linkImage
+
+			if a.fileType == fileTypeAudio {
+				t = linkAudio
+			}
+
+			return a.usage(t, id), nil
+		}
+	}
+
+	return a.folders.RPCData(cd, method, data)
+}
+
 func (a *assetsDir) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == uploadPrefix || strings.HasPrefix(r.URL.Path, uploadPrefix+"/") {
+		if !a.auth.IsAdmin(r) {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		} else {
+			a.serveUpload(w, r)
+		}
+
+		return
+	}
+
+	if r.URL.Path == zipPrefix {
+		if !a.auth.IsAdmin(r) {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		} else {
+			a.serveZip(w, r)
+		}
+
+		return
+	}
+
 	switch r.Method {
 	case http.MethodGet, http.MethodHead:
-		if r.URL.Path == folderMetadata {
+		if r.URL.Path == folderMetadata || r.URL.Path == assetMetadata || r.URL.Path == assetHashes || r.URL.Path == folderTags || !a.canAccess(r) {
 			http.NotFound(w, r)
 		} else {
-			a.handler.ServeHTTP(w, r)
+			a.setContentType(w, r.URL.Path)
+
+			if size := r.URL.Query().Get("size"); size != "" && a.variants != nil {
+				a.serveVariant(w, r, size)
+			} else {
+				a.handler.ServeHTTP(w, r)
+			}
 		}
 	case http.MethodPost:
 		if !a.auth.IsAdmin(r) {
@@ -111,9 +249,26 @@ func (a *assetsDir) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func (a *assetsDir) Post(w http.ResponseWriter, r *http.Request) error {
-	a.Once.Do(a.makeHashMap)
+// canAccess determines whether the requested asset can be accessed, which, when
+// assets are restricted, non-admin users can only do for visible assets.
+func (a *assetsDir) canAccess(r *http.Request) bool {
+	if !a.restrict || a.auth.IsAdmin(r) {
+		return true
+	}
 
+	id, err := strconv.ParseUint(r.URL.Path, 10, 64)
+	if err != nil {
+		return false
+	}
+
+	if a.fileType == fileTypeAudio {
+		return a.assetVisible(linkAudio, id)
+	}
+
+	return a.assetVisible(linkImage, id)
+}
+
+func (a *assetsDir) Post(w http.ResponseWriter, r *http.Request) error {
 	defer r.Body.Close()
 
 	m, err := r.MultipartReader()
@@ -122,26 +277,16 @@ func (a *assetsDir) Post(w http.ResponseWriter, r *http.Request) error {
 	}
 
 	var (
-		added  []idName
-		gft    getFileType
-		hash   [sha256.Size]byte
-		folder map[string]uint64
+		added    []idName
+		rejected []rejection
 	)
 
-	h := sha256.New()
-
 	if err := r.ParseForm(); err != nil {
 		return fmt.Errorf("error processing form data: %w", err)
 	}
 
-	folderPath := path.Clean("/" + r.Form.Get("path"))
-	if f := a.getFolder(folderPath); f != nil {
-		folder = f.Items
-		folderPath += "/"
-	} else {
-		folderPath = "/"
-		folder = a.root.Items
-	}
+	folderPath, folder := a.uploadFolder(r.Form.Get("path"))
+	u := uploader{hash: sha256.New()}
 
 	for {
 		p, err := m.NextPart()
@@ -153,106 +298,81 @@ func (a *assetsDir) Post(w http.ResponseWriter, r *http.Request) error {
 			return err
 		}
 
-		gft.Type = fileTypeUnknown
+		filename := p.FileName()
 
-		bufLen, err := gft.ReadFrom(p)
+		id, err := a.addFile(&u, p)
 		if err != nil {
-			return err
-		}
-
-		if gft.Type != a.fileType {
-			continue
-		}
+			if reason, ok := err.(rejectionError); ok {
+				if filename == "" {
+					filename = p.FormName()
+				}
 
-		a.mu.Lock()
-		a.lastID++
-		id := a.lastID
-		a.mu.Unlock()
+				rejected = append(rejected, rejection{Name: filename, Reason: reason.Error()})
 
-		idStr := strconv.FormatUint(id, 10)
-		b := bufReaderWriterTo{gft.Buffer[:bufLen], p, h, 0}
+				continue
+			}
 
-		if err = a.Set(idStr, &b); err != nil {
 			return err
 		}
 
-		h.Sum(hash[:0])
-		h.Reset()
-
-		if ids, ok := a.hashes[hash]; ok {
-			match := false
-
-			for _, fid := range ids {
-				fidStr := strconv.FormatUint(fid, 10)
-
-				fs, err := a.Stat(fidStr)
-				if err != nil {
-					continue
-				}
-
-				if fs.Size() == b.size {
-					a.Get(idStr, readerFromFunc(func(ar io.Reader) {
-						a.Get(fidStr, readerFromFunc(func(br io.Reader) {
-							abuf, bbuf := make([]byte, 32768), make([]byte, 32768)
+		if filename == "" || strings.ContainsAny(filename, invalidFilenameChars) {
+			filename = strconv.FormatUint(id, 10)
+		}
 
-							for {
-								n, erra := io.ReadFull(ar, abuf)
-								m, errb := io.ReadFull(br, bbuf)
+		newName := addItemTo(folder, filename, id)
+		added = append(added, idName{id, newName})
+	}
 
-								if !bytes.Equal(abuf[:n], bbuf[:m]) {
-									return
-								}
+	a.writeUploaded(w, r, folderPath, added, rejected)
 
-								if erra == io.EOF || erra == io.ErrUnexpectedEOF {
-									match = erra == errb
+	return nil
+}
 
-									return
-								} else if erra != nil || errb != nil {
-									return
-								}
-							}
-						}))
-					}))
+// uploadFolder returns the cleaned path, with a trailing slash, and the items
+// of the folder that uploads to the given path should be added to, which is the
+// root folder if the path does not exist.
+func (a *assetsDir) uploadFolder(folderPath string) (string, map[string]uint64) {
+	folderPath = path.Clean("/" + folderPath)
 
-					if match {
-						id = fid
+	if f := a.getFolder(folderPath); f != nil {
+		if folderPath != "/" {
+			folderPath += "/"
+		}
 
-						a.mu.Lock()
-						a.lastID--
-						a.mu.Unlock()
+		return folderPath, f.Items
+	}
 
-						a.Remove(idStr)
+	return "/", a.root.Items
+}
 
-						break
-					}
-				}
-			}
+// writeUploaded saves the folder and hash data after an upload, broadcasting
+// and writing the list of added files, along with any rejections.
+func (a *assetsDir) writeUploaded(w http.ResponseWriter, r *http.Request, folderPath string, added []idName, rejected []rejection) {
+	if len(rejected) > 0 {
+		rj := appendRejections(nil, rejected)
 
-			if !match {
-				a.hashes[hash] = append(ids, id)
-			}
-		} else {
-			a.hashes[hash] = []uint64{id}
-		}
+		if len(added) == 0 {
+			w.Header().Set(contentType, "application/json")
+			w.Header().Set("Content-Length", strconv.FormatUint(uint64(len(rj)), 10))
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			w.Write(rj)
 
-		filename := p.FileName()
-		if filename == "" || strings.ContainsAny(filename, invalidFilenameChars) {
-			filename = idStr
+			return
 		}
 
-		newName := addItemTo(folder, filename, id)
-		added = append(added, idName{id, newName})
+		w.Header().Set("X-Rejected", string(asciiJSON(rj)))
 	}
 
 	if len(added) == 0 {
 		w.WriteHeader(http.StatusNoContent)
 
-		return nil
+		return
 	}
 
 	a.mu.Lock()
 	a.saveFolders()
 	a.mu.Unlock()
+	a.saveHashes()
 
 	buf := fmt.Appendf([]byte{}, "[{\"id\":%d,\"name\":%q}", added[0].ID, folderPath+added[0].Name)
 
@@ -271,8 +391,209 @@ func (a *assetsDir) Post(w http.ResponseWriter, r *http.Request) error {
 	w.Header().Set(contentType, "application/json")
 	w.Header().Set("Content-Length", strconv.FormatUint(uint64(len(buf)), 10))
 	w.Write(buf)
+}
 
-	return nil
+// setContentType sets the Content-Type header for an asset, as the types of
+// some accepted formats are not recognised by http.FileServer.
+func (a *assetsDir) setContentType(w http.ResponseWriter, key string) {
+	if _, err := strconv.ParseUint(key, 10, 64); err != nil {
+		return
+	}
+
+	var mime string
+
+	if t, ok := a.types.Load(key); ok {
+		mime = t.(string)
+	} else if err := a.Get(key, readerFromFunc(func(r io.Reader) {
+		var buf [sniffLength]byte
+
+		n, _ := io.ReadFull(r, buf[:])
+		mime = sniffType(buf[:n])
+	})); err != nil {
+		return
+	} else {
+		a.types.Store(key, mime)
+	}
+
+	w.Header().Set(contentType, mime)
+
+	if mime == "image/svg+xml" {
+		w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'")
+	}
+}
+
+type uploader struct {
+	gft  getFileType
+	hash hash.Hash
+}
+
+// addFile stores a single uploaded file, returning the ID of the stored file,
+// which, if the file is a duplicate, will be the ID of the existing copy.
+//
+// Files that are not of a type accepted by the store, or that would exceed the
+// size limits of the store, are rejected with a rejectionError.
+func (a *assetsDir) addFile(u *uploader, r io.Reader) (uint64, error) {
+	var hash, uploadHash contentHash
+
+	u.gft.Type = fileTypeUnknown
+	p := a.limitReader(r)
+
+	bufLen, err := u.gft.ReadFrom(p)
+	if p.err != nil {
+		return 0, p.err
+	} else if err != nil {
+		return 0, err
+	} else if bufLen == 0 {
+		return 0, rejectionError("empty file")
+	} else if u.gft.Type == fileTypeUnknown {
+		return 0, rejectionError("unsupported file type: " + u.gft.Mime)
+	} else if u.gft.Type != a.fileType {
+		return 0, rejectionError("file type not accepted here: " + u.gft.Mime)
+	}
+
+	a.mu.Lock()
+	a.lastID++
+	id := a.lastID
+	a.mu.Unlock()
+
+	var (
+		buf            = u.gft.Buffer[:bufLen]
+		rest io.Reader = p
+	)
+
+	if a.strip && canStripMetadata(u.gft.Mime) {
+		data, err := io.ReadAll(io.LimitReader(io.MultiReader(bytes.NewReader(buf), p), maxStripSize+1))
+		if p.err != nil {
+			return 0, p.err
+		} else if err != nil {
+			return 0, err
+		}
+
+		if len(data) > maxStripSize {
+			buf = data
+		} else {
+			uploadHash = sha256.Sum256(data)
+			buf = stripMetadata(u.gft.Mime, data)
+			rest = new(bytes.Reader)
+		}
+	}
+
+	idStr := strconv.FormatUint(id, 10)
+	b := bufReaderWriterTo{buf, rest, u.hash, 0}
+
+	if err = a.Set(idStr, &b); err != nil {
+		u.hash.Reset()
+		a.Remove(idStr)
+
+		if p.err != nil {
+			return 0, p.err
+		}
+
+		return 0, err
+	}
+
+	a.updateUsage(id)
+	u.hash.Sum(hash[:0])
+	u.hash.Reset()
+
+	match := false
+
+	for _, fid := range a.hashes.get(hash) {
+		fidStr := strconv.FormatUint(fid, 10)
+
+		fs, err := a.Stat(fidStr)
+		if err != nil {
+			continue
+		}
+
+		if fs.Size() == b.size {
+			a.Get(idStr, readerFromFunc(func(ar io.Reader) {
+				a.Get(fidStr, readerFromFunc(func(br io.Reader) {
+					abuf, bbuf := make([]byte, 32768), make([]byte, 32768)
+
+					for {
+						n, erra := io.ReadFull(ar, abuf)
+						m, errb := io.ReadFull(br, bbuf)
+
+						if !bytes.Equal(abuf[:n], bbuf[:m]) {
+							return
+						}
+
+						if erra == io.EOF || erra == io.ErrUnexpectedEOF {
+							match = erra == errb
+
+							return
+						} else if erra != nil || errb != nil {
+							return
+						}
+					}
+				}))
+			}))
+
+			if match {
+				a.Remove(idStr)
+				a.updateUsage(id)
+
+				id = fid
+
+				break
+			}
+		}
+	}
+
+	if uploadHash == (contentHash{}) || uploadHash == hash {
+		a.hashes.add(id, hash)
+	} else {
+		a.hashes.add(id, hash, uploadHash)
+	}
+
+	a.getMeta(id)
+
+	return id, nil
+}
+
+// rejectionError is the reason a file was not accepted by a store.
+type rejectionError string
+
+func (r rejectionError) Error() string {
+	return string(r)
+}
+
+type rejection struct {
+	Name, Reason string
+}
+
+func appendRejections(buf []byte, rejected []rejection) []byte {
+	buf = append(buf, '[')
+
+	for n, r := range rejected {
+		if n > 0 {
+			buf = append(buf, ',')
+		}
+
+		buf = appendString(append(buf, "{\"name\":"...), r.Name)
+		buf = append(appendString(append(buf, ",\"reason\":"...), r.Reason), '}')
+	}
+
+	return append(buf, ']')
+}
+
+// asciiJSON escapes all non-ASCII characters in the JSON, so that it can be
+// used as a header value.
+func asciiJSON(buf []byte) []byte {
+	var out []byte
+
+	for _, r := range string(buf) {
+		if r < utf8.RuneSelf {
+			out = append(out, byte(r))
+		} else {
+			for _, c := range utf16.Encode([]rune{r}) {
+				out = append(out, '\\', 'u', hex[c>>12], hex[c>>8&0xf], hex[c>>4&0xf], hex[c&0xf])
+			}
+		}
+	}
+
+	return out
 }
 
 type bufReaderWriterTo struct {
@@ -0,0 +1,243 @@
+package battlemap
+
+import (
+	"crypto/sha256"
+	hexenc "encoding/hex"
+	"encoding/json"
+	"hash"
+	"io"
+	"slices"
+	"strconv"
+	"sync"
+
+	"vimagination.zapto.org/byteio"
+)
+
+type contentHash [sha256.Size]byte
+
+// hashIndex maps the SHA-256 hashes of stored assets to their IDs. An asset may
+// have more than one hash, as the hash of an upload is recorded along with the
+// hash of the stored file when the two differ.
+type hashIndex struct {
+	mu     sync.RWMutex
+	hashes map[contentHash][]uint64
+	ids    map[uint64][]contentHash
+}
+
+func (h *hashIndex) init() {
+	h.hashes = make(map[contentHash][]uint64)
+	h.ids = make(map[uint64][]contentHash)
+}
+
+func (h *hashIndex) ReadFrom(r io.Reader) (int64, error) {
+	lr := byteio.StickyLittleEndianReader{Reader: r}
+
+	for l := lr.ReadUintX(); l > 0 && lr.Err == nil; l-- {
+		id := lr.ReadUintX()
+
+		for m := lr.ReadUintX(); m > 0 && lr.Err == nil; m-- {
+			var hash contentHash
+
+			io.ReadFull(&lr, hash[:])
+
+			h.addLocked(id, hash)
+		}
+	}
+
+	return lr.Count, lr.Err
+}
+
+func (h *hashIndex) WriteTo(w io.Writer) (int64, error) {
+	lw := byteio.StickyLittleEndianWriter{Writer: w}
+
+	lw.WriteUintX(uint64(len(h.ids)))
+
+	for id, hashes := range h.ids {
+		lw.WriteUintX(id)
+		lw.WriteUintX(uint64(len(hashes)))
+
+		for _, hash := range hashes {
+			lw.Write(hash[:])
+		}
+	}
+
+	return lw.Count, lw.Err
+}
+
+func (h *hashIndex) add(id uint64, hashes ...contentHash) {
+	h.mu.Lock()
+
+	for _, hash := range hashes {
+		h.addLocked(id, hash)
+	}
+
+	h.mu.Unlock()
+}
+
+func (h *hashIndex) addLocked(id uint64, hash contentHash) {
+	if slices.Contains(h.ids[id], hash) {
+		return
+	}
+
+	h.hashes[hash] = append(h.hashes[hash], id)
+	h.ids[id] = append(h.ids[id], hash)
+}
+
+func (h *hashIndex) remove(id uint64) {
+	h.mu.Lock()
+	h.removeLocked(id)
+	h.mu.Unlock()
+}
+
+func (h *hashIndex) removeLocked(id uint64) {
+	for _, hash := range h.ids[id] {
+		ids := slices.DeleteFunc(h.hashes[hash], func(fid uint64) bool { return fid == id })
+
+		if len(ids) == 0 {
+			delete(h.hashes, hash)
+		} else {
+			h.hashes[hash] = ids
+		}
+	}
+
+	delete(h.ids, id)
+}
+
+// get returns a copy of the list of IDs with the given hash.
+func (h *hashIndex) get(hash contentHash) []uint64 {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return slices.Clone(h.hashes[hash])
+}
+
+func (h *hashIndex) has(id uint64) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	_, ok := h.ids[id]
+
+	return ok
+}
+
+type hasher struct {
+	hash.Hash
+}
+
+func (h *hasher) ReadFrom(r io.Reader) (int64, error) {
+	return io.Copy(h.Hash, r)
+}
+
+// initHashes loads the hash index, removing any entries for files no longer in
+// the store, and then, in the background, hashes any files not in the index.
+func (a *assetsDir) initHashes() error {
+	a.hashes.init()
+
+	if a.Exists(assetHashes) {
+		if err := a.Get(assetHashes, &a.hashes); err != nil {
+			a.hashes.init()
+		}
+	}
+
+	existing := make(map[uint64]struct{})
+
+	var missing []uint64
+
+	for _, key := range a.Keys() {
+		if id, err := strconv.ParseUint(key, 10, 64); err == nil {
+			existing[id] = struct{}{}
+
+			if !a.hashes.has(id) {
+				missing = append(missing, id)
+			}
+		}
+	}
+
+	changed := false
+
+	for id := range a.hashes.ids {
+		if _, ok := existing[id]; !ok {
+			a.hashes.removeLocked(id)
+
+			changed = true
+		}
+	}
+
+	if changed {
+		a.saveHashes()
+	}
+
+	if len(missing) > 0 {
+		go func() {
+			for _, id := range missing {
+				a.hashFile(id)
+			}
+
+			a.saveHashes()
+		}()
+	}
+
+	return nil
+}
+
+// hashFile adds the hash of the stored file with the given ID to the index.
+func (a *assetsDir) hashFile(id uint64) {
+	var hash contentHash
+
+	h := hasher{Hash: sha256.New()}
+
+	if err := a.Get(strconv.FormatUint(id, 10), &h); err != nil {
+		return
+	}
+
+	h.Sum(hash[:0])
+	a.hashes.add(id, hash)
+}
+
+// pruneHashes removes the index entries of files no longer in the store.
+func (a *assetsDir) pruneHashes() {
+	a.hashes.mu.Lock()
+
+	for id := range a.hashes.ids {
+		if !a.Exists(strconv.FormatUint(id, 10)) {
+			a.hashes.removeLocked(id)
+		}
+	}
+
+	a.hashes.mu.Unlock()
+	a.saveHashes()
+}
+
+func (a *assetsDir) saveHashes() error {
+	a.hashes.mu.RLock()
+	defer a.hashes.mu.RUnlock()
+
+	return a.Set(assetHashes, &a.hashes)
+}
+
+// lookup returns the ID of a stored file with the given hex encoded SHA-256
+// hash, or null if there is none.
+func (a *assetsDir) lookup(data json.RawMessage) (json.RawMessage, error) {
+	var (
+		hexHash string
+		hash    contentHash
+	)
+
+	if err := json.Unmarshal(data, &hexHash); err != nil {
+		return nil, err
+	} else if len(hexHash) != hexenc.EncodedLen(len(hash)) {
+		return nil, ErrInvalidHash
+	} else if _, err := hexenc.Decode(hash[:], []byte(hexHash)); err != nil {
+		return nil, err
+	}
+
+	for _, id := range a.hashes.get(hash) {
+		if a.Exists(strconv.FormatUint(id, 10)) {
+			return strconv.AppendUint(nil, id, 10), nil
+		}
+	}
+
+	return json.RawMessage("null"), nil
+}
+
+const assetHashes = "hashes"
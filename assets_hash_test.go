@@ -0,0 +1,66 @@
+package battlemap
+
+import (
+	"crypto/sha256"
+	"slices"
+	"testing"
+
+	"vimagination.zapto.org/memio"
+)
+
+func TestHashIndex(t *testing.T) {
+	var h hashIndex
+
+	h.init()
+
+	a, b, c := sha256.Sum256([]byte("a")), sha256.Sum256([]byte("b")), sha256.Sum256([]byte("c"))
+
+	for n, test := range [...]struct {
+		Add    uint64
+		Remove uint64
+		Hashes []contentHash
+		Reload bool
+		Lookup map[contentHash][]uint64
+	}{
+		{Add: 1, Hashes: []contentHash{a}, Lookup: map[contentHash][]uint64{a: {1}, b: nil}},
+		{Add: 2, Hashes: []contentHash{a, b}, Lookup: map[contentHash][]uint64{a: {1, 2}, b: {2}}},
+		{Add: 2, Hashes: []contentHash{b}, Lookup: map[contentHash][]uint64{a: {1, 2}, b: {2}}},
+		{Add: 3, Hashes: []contentHash{c}, Reload: true, Lookup: map[contentHash][]uint64{a: {1, 2}, b: {2}, c: {3}}},
+		{Remove: 1, Lookup: map[contentHash][]uint64{a: {2}, b: {2}, c: {3}}},
+		{Remove: 2, Reload: true, Lookup: map[contentHash][]uint64{a: nil, b: nil, c: {3}}},
+		{Remove: 4, Lookup: map[contentHash][]uint64{a: nil, b: nil, c: {3}}},
+	} {
+		if test.Add > 0 {
+			h.add(test.Add, test.Hashes...)
+		} else {
+			h.remove(test.Remove)
+		}
+
+		if test.Reload {
+			var buf memio.Buffer
+
+			h.WriteTo(&buf)
+			h.init()
+
+			if _, err := h.ReadFrom(&buf); err != nil {
+				t.Errorf("test %d: unexpected error reloading index: %s", n+1, err)
+
+				continue
+			}
+		}
+
+		for hash, expected := range test.Lookup {
+			ids := h.get(hash)
+
+			slices.Sort(ids)
+
+			if !slices.Equal(ids, expected) {
+				t.Errorf("test %d: expecting IDs %v, got %v", n+1, expected, ids)
+			}
+		}
+
+		if test.Remove > 0 && h.has(test.Remove) {
+			t.Errorf("test %d: expecting ID %d to have been removed", n+1, test.Remove)
+		}
+	}
+}
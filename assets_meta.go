@@ -0,0 +1,606 @@
+package battlemap
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"image"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	"vimagination.zapto.org/byteio"
+)
+
+// assetMeta contains the dimensions of an image, or the duration of an audio
+// file.
+type assetMeta struct {
+	Width, Height uint32
+	Duration      time.Duration
+}
+
+func (m assetMeta) appendTo(buf []byte) []byte {
+	buf = append(buf, '{')
+
+	if m.Width > 0 || m.Height > 0 {
+		buf = strconv.AppendUint(append(buf, "\"width\":"...), uint64(m.Width), 10)
+		buf = strconv.AppendUint(append(buf, ",\"height\":"...), uint64(m.Height), 10)
+
+		if m.Duration > 0 {
+			buf = append(buf, ',')
+		}
+	}
+
+	if m.Duration > 0 {
+		buf = strconv.AppendFloat(append(buf, "\"duration\":"...), m.Duration.Seconds(), 'f', 3, 64)
+	}
+
+	return append(buf, '}')
+}
+
+type metaIndex map[uint64]assetMeta
+
+func (m metaIndex) ReadFrom(r io.Reader) (int64, error) {
+	lr := byteio.StickyLittleEndianReader{Reader: r}
+
+	for l := lr.ReadUintX(); l > 0 && lr.Err == nil; l-- {
+		id := lr.ReadUintX()
+		m[id] = assetMeta{
+			Width:    uint32(lr.ReadUintX()),
+			Height:   uint32(lr.ReadUintX()),
+			Duration: time.Duration(lr.ReadUintX()),
+		}
+	}
+
+	return lr.Count, lr.Err
+}
+
+func (m metaIndex) WriteTo(w io.Writer) (int64, error) {
+	lw := byteio.StickyLittleEndianWriter{Writer: w}
+
+	lw.WriteUintX(uint64(len(m)))
+
+	for id, meta := range m {
+		lw.WriteUintX(id)
+		lw.WriteUintX(uint64(meta.Width))
+		lw.WriteUintX(uint64(meta.Height))
+		lw.WriteUintX(uint64(meta.Duration))
+	}
+
+	return lw.Count, lw.Err
+}
+
+// metaSaveDelay is the time for which changes to the metadata index are
+// collected before the index is written.
+const metaSaveDelay = 5 * time.Second
+
+type assetsMeta struct {
+	mu    sync.RWMutex
+	index metaIndex
+	save  *time.Timer
+}
+
+// initMeta loads the metadata index, and then, in the background, extracts the
+// metadata for any assets not already in the index.
+func (a *assetsDir) initMeta() error {
+	a.meta.index = make(metaIndex)
+
+	if a.Exists(assetMetadata) {
+		if err := a.Get(assetMetadata, a.meta.index); err != nil {
+			clear(a.meta.index)
+		}
+	}
+
+	var missing []uint64
+
+	for _, key := range a.Keys() {
+		if id, err := strconv.ParseUint(key, 10, 64); err == nil {
+			if _, ok := a.meta.index[id]; !ok {
+				missing = append(missing, id)
+			}
+		}
+	}
+
+	if len(missing) > 0 {
+		go func() {
+			for _, id := range missing {
+				a.getMeta(id)
+			}
+		}()
+	}
+
+	return nil
+}
+
+// getMeta returns the metadata for the given asset, extracting it from the file
+// if it isn't already in the index.
+//
+// New entries are written to disk in batches, after metaSaveDelay.
+func (a *assetsDir) getMeta(id uint64) (assetMeta, error) {
+	a.meta.mu.RLock()
+	meta, ok := a.meta.index[id]
+	a.meta.mu.RUnlock()
+
+	if ok {
+		return meta, nil
+	}
+
+	var err error
+
+	if errr := a.Get(strconv.FormatUint(id, 10), readerFromFunc(func(r io.Reader) {
+		meta, err = extractMeta(r)
+	})); errr != nil {
+		return meta, errr
+	} else if err != nil {
+		return meta, err
+	}
+
+	a.meta.mu.Lock()
+	a.meta.index[id] = meta
+
+	if a.meta.save == nil {
+		a.meta.save = time.AfterFunc(metaSaveDelay, func() { a.flushMeta() })
+	}
+
+	a.meta.mu.Unlock()
+
+	return meta, nil
+}
+
+// flushMeta writes the metadata index if it has changed since it was last
+// written.
+func (a *assetsDir) flushMeta() error {
+	a.meta.mu.Lock()
+	defer a.meta.mu.Unlock()
+
+	if a.meta.save == nil {
+		return nil
+	}
+
+	a.meta.save.Stop()
+
+	a.meta.save = nil
+
+	return a.Set(assetMetadata, a.meta.index)
+}
+
+// list returns the folder list, with the metadata of each asset added under the
+// "meta" key.
+func (a *assetsDir) list() json.RawMessage {
+	l := a.folders.list()
+
+	for len(l) > 0 && l[len(l)-1] != '}' {
+		l = l[:len(l)-1]
+	}
+
+	if len(l) == 0 {
+		return l
+	}
+
+	buf := append(append(json.RawMessage{}, l[:len(l)-1]...), ",\"meta\":{"...)
+	first := true
+
+	a.meta.mu.RLock()
+
+	for id, meta := range a.meta.index {
+		if first {
+			first = false
+		} else {
+			buf = append(buf, ',')
+		}
+
+		buf = append(strconv.AppendUint(append(buf, '"'), id, 10), '"', ':')
+		buf = meta.appendTo(buf)
+	}
+
+	a.meta.mu.RUnlock()
+
+	return append(buf, '}', '}')
+}
+
+func (a *assetsDir) info(data json.RawMessage) (json.RawMessage, error) {
+	var id uint64
+
+	if err := json.Unmarshal(data, &id); err != nil {
+		return nil, err
+	}
+
+	if !a.Exists(strconv.FormatUint(id, 10)) {
+		return nil, ErrItemNotFound
+	}
+
+	meta, err := a.getMeta(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return meta.appendTo(nil), nil
+}
+
+// extractMeta reads the dimensions of an image, or the duration of an audio
+// file, from its headers.
+func extractMeta(r io.Reader) (assetMeta, error) {
+	var meta assetMeta
+
+	br := bufio.NewReaderSize(r, sniffLength)
+	head, _ := br.Peek(sniffLength)
+
+	switch sniffType(head) {
+	case "image/gif", "image/png", "image/jpeg":
+		c, _, err := image.DecodeConfig(br)
+		if err != nil {
+			return meta, err
+		}
+
+		meta.Width = uint32(c.Width)
+		meta.Height = uint32(c.Height)
+	case "image/webp":
+		w, h, err := webpSize(br)
+		if err != nil {
+			return meta, err
+		}
+
+		meta.Width = w
+		meta.Height = h
+	case "application/ogg":
+		d, err := oggDuration(br)
+		if err != nil {
+			return meta, err
+		}
+
+		meta.Duration = d
+	case "audio/mpeg":
+		d, err := mp3Duration(br)
+		if err != nil {
+			return meta, err
+		}
+
+		meta.Duration = d
+	case "audio/flac":
+		d, err := flacDuration(br)
+		if err != nil {
+			return meta, err
+		}
+
+		meta.Duration = d
+	case "audio/wave":
+		d, err := wavDuration(br)
+		if err != nil {
+			return meta, err
+		}
+
+		meta.Duration = d
+	}
+
+	return meta, nil
+}
+
+func webpSize(r io.Reader) (uint32, uint32, error) {
+	var b [30]byte
+
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return 0, 0, err
+	} else if string(b[:4]) != "RIFF" || string(b[8:12]) != "WEBP" {
+		return 0, 0, ErrInvalidFileType
+	}
+
+	switch string(b[12:16]) {
+	case "VP8 ":
+		if b[23] != 0x9d || b[24] != 0x01 || b[25] != 0x2a {
+			return 0, 0, ErrInvalidFileType
+		}
+
+		return uint32(binary.LittleEndian.Uint16(b[26:]) & 0x3fff), uint32(binary.LittleEndian.Uint16(b[28:]) & 0x3fff), nil
+	case "VP8L":
+		if b[20] != 0x2f {
+			return 0, 0, ErrInvalidFileType
+		}
+
+		bits := binary.LittleEndian.Uint32(b[21:])
+
+		return bits&0x3fff + 1, bits>>14&0x3fff + 1, nil
+	case "VP8X":
+		return uint32(b[24]) | uint32(b[25])<<8 | uint32(b[26])<<16 + 1, uint32(b[27]) | uint32(b[28])<<8 | uint32(b[29])<<16 + 1, nil
+	}
+
+	return 0, 0, ErrInvalidFileType
+}
+
+// oggDuration determines the length of an Ogg Vorbis or Opus stream from the
+// granule position of its last page.
+func oggDuration(r io.Reader) (time.Duration, error) {
+	var (
+		header        [27]byte
+		segments      [255]byte
+		body          []byte
+		serial        uint32
+		rate, preSkip uint64
+		granule       uint64
+		first         = true
+	)
+
+	for {
+		if _, err := io.ReadFull(r, header[:]); err == io.EOF {
+			break
+		} else if err != nil {
+			return 0, err
+		} else if string(header[:4]) != "OggS" {
+			return 0, ErrInvalidFileType
+		}
+
+		segs := segments[:header[26]]
+
+		if _, err := io.ReadFull(r, segs); err != nil {
+			return 0, err
+		}
+
+		var size int64
+
+		for _, s := range segs {
+			size += int64(s)
+		}
+
+		if first {
+			first = false
+			serial = binary.LittleEndian.Uint32(header[14:])
+			body = make([]byte, size)
+
+			if _, err := io.ReadFull(r, body); err != nil {
+				return 0, err
+			}
+
+			if len(body) >= 16 && body[0] == 1 && string(body[1:7]) == "vorbis" {
+				rate = uint64(binary.LittleEndian.Uint32(body[12:]))
+			} else if len(body) >= 12 && string(body[:8]) == "OpusHead" {
+				rate = 48000
+				preSkip = uint64(binary.LittleEndian.Uint16(body[10:]))
+			} else {
+				return 0, ErrInvalidFileType
+			}
+
+			continue
+		} else if _, err := io.CopyN(io.Discard, r, size); err != nil {
+			return 0, err
+		}
+
+		if g := binary.LittleEndian.Uint64(header[6:]); g != ^uint64(0) && binary.LittleEndian.Uint32(header[14:]) == serial {
+			granule = g
+		}
+	}
+
+	if rate == 0 || granule < preSkip {
+		return 0, ErrInvalidFileType
+	}
+
+	return time.Duration((granule - preSkip) * uint64(time.Second) / rate), nil
+}
+
+// flacDuration determines the length of a FLAC file from the total samples
+// and sample rate in its STREAMINFO block.
+func flacDuration(r io.Reader) (time.Duration, error) {
+	var b [26]byte
+
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return 0, err
+	} else if string(b[:4]) != "fLaC" || b[4]&0x7f != 0 {
+		return 0, ErrInvalidFileType
+	}
+
+	v := binary.BigEndian.Uint64(b[18:])
+	rate := v >> 44
+	samples := v & (1<<36 - 1)
+
+	if rate == 0 {
+		return 0, ErrInvalidFileType
+	}
+
+	return time.Duration(samples * uint64(time.Second) / rate), nil
+}
+
+// wavDuration determines the length of a WAV file from the size of its data
+// chunk and the byte rate in its fmt chunk.
+func wavDuration(r *bufio.Reader) (time.Duration, error) {
+	var (
+		b        [12]byte
+		byteRate uint64
+	)
+
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return 0, err
+	} else if string(b[:4]) != "RIFF" || string(b[8:12]) != "WAVE" {
+		return 0, ErrInvalidFileType
+	}
+
+	for {
+		if _, err := io.ReadFull(r, b[:8]); err != nil {
+			return 0, err
+		}
+
+		size := int(binary.LittleEndian.Uint32(b[4:]))
+
+		switch string(b[:4]) {
+		case "fmt ":
+			f, err := r.Peek(12)
+			if err != nil {
+				return 0, err
+			}
+
+			byteRate = uint64(binary.LittleEndian.Uint32(f[8:]))
+		case "data":
+			if byteRate == 0 {
+				return 0, ErrInvalidFileType
+			}
+
+			return time.Duration(uint64(size) * uint64(time.Second) / byteRate), nil
+		}
+
+		if _, err := r.Discard(size + size&1); err != nil {
+			return 0, err
+		}
+	}
+}
+
+var (
+	mp3Bitrates = [2][3][15]uint16{
+		{
+			{0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448},
+			{0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384},
+			{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320},
+		},
+		{
+			{0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256},
+			{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+			{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+		},
+	}
+	mp3SampleRates = [4][3]uint32{
+		{11025, 12000, 8000},
+		{},
+		{22050, 24000, 16000},
+		{44100, 48000, 32000},
+	}
+)
+
+type mp3Frame struct {
+	SampleRate, Samples, Length uint32
+	mpeg1, mono                 bool
+}
+
+func parseMP3Frame(h uint32) (mp3Frame, bool) {
+	version := h >> 19 & 3
+	layer := h >> 17 & 3
+	bitrate := h >> 12 & 15
+	rate := h >> 10 & 3
+
+	if h>>21 != 0x7ff || version == 1 || layer == 0 || bitrate == 0 || bitrate == 15 || rate == 3 {
+		return mp3Frame{}, false
+	}
+
+	f := mp3Frame{
+		SampleRate: mp3SampleRates[version][rate],
+		mpeg1:      version == 3,
+		mono:       h>>6&3 == 3,
+	}
+
+	v := 1
+
+	if f.mpeg1 {
+		v = 0
+	}
+
+	br := uint32(mp3Bitrates[v][3-layer][bitrate]) * 1000
+	padding := h >> 9 & 1
+
+	switch layer {
+	case 3:
+		f.Samples = 384
+		f.Length = (12*br/f.SampleRate + padding) * 4
+	case 2:
+		f.Samples = 1152
+		f.Length = 144*br/f.SampleRate + padding
+	default:
+		f.Samples = 1152
+
+		if !f.mpeg1 {
+			f.Samples = 576
+		}
+
+		f.Length = f.Samples/8*br/f.SampleRate + padding
+	}
+
+	return f, f.Length >= 4
+}
+
+// maxMP3Sync is the number of bytes that will be skipped while searching for
+// the first frame of an MP3 file.
+const maxMP3Sync = 1 << 16
+
+// mp3Duration determines the length of an MP3 file, either from a Xing, Info,
+// or VBRI header, or by counting the samples in every frame.
+func mp3Duration(r *bufio.Reader) (time.Duration, error) {
+	if h, err := r.Peek(10); err == nil && string(h[:3]) == "ID3" {
+		size := int(h[6]&0x7f)<<21 | int(h[7]&0x7f)<<14 | int(h[8]&0x7f)<<7 | int(h[9]&0x7f) + 10
+
+		if h[5]&0x10 != 0 {
+			size += 10
+		}
+
+		if _, err := r.Discard(size); err != nil {
+			return 0, err
+		}
+	}
+
+	var (
+		samples, skipped uint64
+		rate             uint32
+	)
+
+	for {
+		b, err := r.Peek(4)
+		if err != nil {
+			break
+		}
+
+		f, ok := parseMP3Frame(binary.BigEndian.Uint32(b))
+		if !ok {
+			if rate != 0 || skipped == maxMP3Sync {
+				break
+			}
+
+			r.Discard(1)
+
+			skipped++
+
+			continue
+		}
+
+		if rate == 0 {
+			rate = f.SampleRate
+
+			if frames, ok := vbrFrames(r, f); ok {
+				return time.Duration(uint64(frames) * uint64(f.Samples) * uint64(time.Second) / uint64(rate)), nil
+			}
+		} else if f.SampleRate != rate {
+			break
+		}
+
+		if _, err := r.Discard(int(f.Length)); err != nil {
+			break
+		}
+
+		samples += uint64(f.Samples)
+	}
+
+	if rate == 0 {
+		return 0, ErrInvalidFileType
+	}
+
+	return time.Duration(samples * uint64(time.Second) / uint64(rate)), nil
+}
+
+// vbrFrames reads the frame count from a Xing, Info, or VBRI header in the
+// first frame of an MP3 file.
+func vbrFrames(r *bufio.Reader, f mp3Frame) (uint32, bool) {
+	b, _ := r.Peek(int(f.Length))
+
+	side := 17
+
+	if f.mpeg1 && !f.mono {
+		side = 32
+	} else if !f.mpeg1 && f.mono {
+		side = 9
+	}
+
+	if x := 4 + side; len(b) >= x+12 && (string(b[x:x+4]) == "Xing" || string(b[x:x+4]) == "Info") {
+		if binary.BigEndian.Uint32(b[x+4:])&1 == 1 {
+			return binary.BigEndian.Uint32(b[x+8:]), true
+		}
+	} else if len(b) >= 36+18 && string(b[36:40]) == "VBRI" {
+		return binary.BigEndian.Uint32(b[36+14:]), true
+	}
+
+	return 0, false
+}
+
+const assetMetadata = "meta"
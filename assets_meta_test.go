@@ -0,0 +1,32 @@
+package battlemap
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestMP3Duration(t *testing.T) {
+	frame := make([]byte, 417)
+
+	copy(frame, []byte{0xff, 0xfb, 0x90, 0x64})
+
+	data := append([]byte("ID3\x04\x00\x00\x00\x00\x00\x02\x00\x00"), bytes.Repeat(frame, 100)...)
+
+	if d, err := mp3Duration(bufio.NewReader(bytes.NewReader(data))); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if expected := time.Duration(100 * 1152 * uint64(time.Second) / 44100); d != expected {
+		t.Errorf("expecting duration %s, got %s", expected, d)
+	}
+}
+
+func TestWebPSize(t *testing.T) {
+	header := []byte("RIFF\x00\x00\x00\x00WEBPVP8X\x0a\x00\x00\x00\x00\x00\x00\x00\x3f\x01\x00\xc7\x00\x00")
+
+	if w, h, err := webpSize(bytes.NewReader(header)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if w != 320 || h != 200 {
+		t.Errorf("expecting 320x200, got %dx%d", w, h)
+	}
+}
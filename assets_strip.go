@@ -0,0 +1,235 @@
+package battlemap
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// maxStripSize is the largest image that will be held in memory to have its
+// metadata stripped; larger images are stored as uploaded.
+const maxStripSize = 32 << 20
+
+// stripMetadata removes EXIF, XMP, IPTC, comment, and text metadata from JPEG,
+// PNG, and WebP images. Data of other types, or that cannot be parsed, is
+// returned unchanged.
+func stripMetadata(mime string, data []byte) []byte {
+	var (
+		stripped []byte
+		ok       bool
+	)
+
+	switch mime {
+	case "image/jpeg":
+		stripped, ok = stripJPEG(data)
+	case "image/png":
+		stripped, ok = stripPNG(data)
+	case "image/webp":
+		stripped, ok = stripWebP(data)
+	}
+
+	if !ok {
+		return data
+	}
+
+	return stripped
+}
+
+func canStripMetadata(mime string) bool {
+	return mime == "image/jpeg" || mime == "image/png" || mime == "image/webp"
+}
+
+// stripJPEG removes all APPn segments, other than JFIF (APP0), ICC profiles
+// (APP2), and Adobe colour transform (APP14) segments, along with all comment
+// segments. If the EXIF data specifies an orientation, it is replaced by a
+// minimal EXIF segment containing only the orientation.
+func stripJPEG(data []byte) ([]byte, bool) {
+	if len(data) < 4 || data[0] != 0xff || data[1] != 0xd8 {
+		return nil, false
+	}
+
+	out := make([]byte, 2, len(data))
+	oriented := false
+
+	copy(out, data)
+
+	for p := 2; p < len(data); {
+		if data[p] != 0xff || p+1 >= len(data) {
+			return nil, false
+		}
+
+		marker := data[p+1]
+
+		switch {
+		case marker == 0xff:
+			p++
+
+			continue
+		case marker == 0x01 || marker >= 0xd0 && marker <= 0xd7:
+			out = append(out, data[p:p+2]...)
+			p += 2
+
+			continue
+		case marker == 0xd9:
+			return append(out, data[p:p+2]...), true
+		}
+
+		if p+4 > len(data) {
+			return nil, false
+		}
+
+		end := p + 2 + int(binary.BigEndian.Uint16(data[p+2:]))
+		if end > len(data) {
+			return nil, false
+		}
+
+		switch {
+		case marker == 0xda:
+			return append(out, data[p:]...), true
+		case marker == 0xe1:
+			if o := exifOrientation(data[p+4 : end]); o > 1 && !oriented {
+				out = appendOrientationEXIF(out, o)
+				oriented = true
+			}
+		case marker == 0xfe, marker >= 0xe0 && marker <= 0xef && marker != 0xe0 && marker != 0xe2 && marker != 0xee:
+		default:
+			out = append(out, data[p:end]...)
+		}
+
+		p = end
+	}
+
+	return nil, false
+}
+
+// exifOrientation returns the value of the orientation tag from the IFD0 of
+// the given APP1 segment data, or zero if there is none.
+func exifOrientation(app1 []byte) uint16 {
+	tiff, ok := bytes.CutPrefix(app1, []byte("Exif\x00\x00"))
+	if !ok || len(tiff) < 8 {
+		return 0
+	}
+
+	var order binary.ByteOrder
+
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd+2 > len(tiff) || ifd < 8 {
+		return 0
+	}
+
+	for n, p := int(order.Uint16(tiff[ifd:])), ifd+2; n > 0 && p+12 <= len(tiff); n, p = n-1, p+12 {
+		if order.Uint16(tiff[p:]) == 0x0112 && order.Uint16(tiff[p+2:]) == 3 {
+			return order.Uint16(tiff[p+8:])
+		}
+	}
+
+	return 0
+}
+
+func appendOrientationEXIF(out []byte, orientation uint16) []byte {
+	out = append(out, 0xff, 0xe1, 0, 34)
+	out = append(out, "Exif\x00\x00MM\x00\x2a\x00\x00\x00\x08\x00\x01\x01\x12\x00\x03\x00\x00\x00\x01"...)
+	out = binary.BigEndian.AppendUint16(out, orientation)
+
+	return append(out, 0, 0, 0, 0, 0, 0)
+}
+
+var pngStripChunks = [...]string{"tEXt", "zTXt", "iTXt", "eXIf", "tIME"}
+
+// stripPNG removes text, EXIF, and timestamp chunks from a PNG image.
+func stripPNG(data []byte) ([]byte, bool) {
+	if len(data) < 8 || string(data[:8]) != "\x89PNG\r\n\x1a\n" {
+		return nil, false
+	}
+
+	out := make([]byte, 8, len(data))
+
+	copy(out, data)
+
+Chunks:
+	for p := 8; p < len(data); {
+		if p+12 > len(data) {
+			return nil, false
+		}
+
+		end := p + 12 + int(binary.BigEndian.Uint32(data[p:]))
+		if end > len(data) || end < p {
+			return nil, false
+		}
+
+		start, typ := p, string(data[p+4:p+8])
+		p = end
+
+		for _, strip := range pngStripChunks {
+			if typ == strip {
+				continue Chunks
+			}
+		}
+
+		out = append(out, data[start:p]...)
+
+		if typ == "IEND" {
+			return out, true
+		}
+	}
+
+	return nil, false
+}
+
+// stripWebP removes the EXIF and XMP chunks from a WebP image, clearing the
+// corresponding flags in the VP8X chunk.
+func stripWebP(data []byte) ([]byte, bool) {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, false
+	}
+
+	out := make([]byte, 12, len(data))
+
+	copy(out, data)
+
+	size := min(int(binary.LittleEndian.Uint32(data[4:]))+8, len(data))
+
+	for p := 12; p < size; {
+		if p+8 > size {
+			return nil, false
+		}
+
+		l := int(binary.LittleEndian.Uint32(data[p+4:]))
+		end := p + 8 + l + l&1
+
+		if end > size || end < p {
+			if p+8+l != size {
+				return nil, false
+			}
+
+			end = size
+		}
+
+		switch string(data[p : p+4]) {
+		case "EXIF", "XMP ":
+		case "VP8X":
+			start := len(out)
+			out = append(out, data[p:end]...)
+
+			if l > 0 {
+				out[start+8] &^= 0x08 | 0x04
+			}
+		default:
+			out = append(out, data[p:end]...)
+		}
+
+		p = end
+	}
+
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(out)-8))
+
+	return out, true
+}
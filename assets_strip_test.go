@@ -0,0 +1,43 @@
+package battlemap
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func TestStripJPEG(t *testing.T) {
+	var buf bytes.Buffer
+
+	jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, 8, 8)), nil)
+
+	exif := []byte("\xff\xe1\x00\x22Exif\x00\x00II\x2a\x00\x08\x00\x00\x00\x01\x00\x12\x01\x03\x00\x01\x00\x00\x00\x06\x00\x00\x00\x00\x00\x00\x00\xff\xfe\x00\x06GPS!")
+	data := append(append(append([]byte{}, buf.Bytes()[:2]...), exif...), buf.Bytes()[2:]...)
+	stripped := stripMetadata("image/jpeg", data)
+
+	if bytes.Contains(stripped, []byte("GPS!")) {
+		t.Error("expecting comment to be removed")
+	} else if o := exifOrientation(stripped[6:]); o != 6 {
+		t.Errorf("expecting orientation 6, got %d", o)
+	} else if _, err := jpeg.Decode(bytes.NewReader(stripped)); err != nil {
+		t.Errorf("unexpected error decoding stripped image: %s", err)
+	}
+}
+
+func TestStripPNG(t *testing.T) {
+	var buf bytes.Buffer
+
+	png.Encode(&buf, image.NewGray(image.Rect(0, 0, 8, 8)))
+
+	text := []byte("\x00\x00\x00\x09tEXtGPS\x00 1, 2\x00\x00\x00\x00")
+	data := append(append(append([]byte{}, buf.Bytes()[:33]...), text...), buf.Bytes()[33:]...)
+	stripped := stripMetadata("image/png", data)
+
+	if bytes.Contains(stripped, []byte("tEXt")) {
+		t.Error("expecting text chunk to be removed")
+	} else if !bytes.Equal(stripped, buf.Bytes()) {
+		t.Error("expecting stripped image to match original")
+	}
+}
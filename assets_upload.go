@@ -0,0 +1,402 @@
+package battlemap
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	hexenc "encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"vimagination.zapto.org/byteio"
+	"vimagination.zapto.org/keystore"
+)
+
+const (
+	uploadOffset = "Upload-Offset"
+	uploadLength = "Upload-Length"
+	uploadPrefix = "upload"
+	sessionExt   = ".session"
+)
+
+// uploadSession describes a resumable upload, the data of which is stored in a
+// file named for the session token.
+type uploadSession struct {
+	Path, Name string
+	Size       int64
+	Created    time.Time
+
+	mu sync.Mutex
+}
+
+func (u *uploadSession) ReadFrom(r io.Reader) (int64, error) {
+	lr := byteio.StickyLittleEndianReader{Reader: r}
+
+	u.Path = lr.ReadStringX()
+	u.Name = lr.ReadStringX()
+	u.Size = lr.ReadInt64()
+	u.Created = time.Unix(lr.ReadInt64(), 0)
+
+	return lr.Count, lr.Err
+}
+
+func (u *uploadSession) WriteTo(w io.Writer) (int64, error) {
+	lw := byteio.StickyLittleEndianWriter{Writer: w}
+
+	lw.WriteStringX(u.Path)
+	lw.WriteStringX(u.Name)
+	lw.WriteInt64(u.Size)
+	lw.WriteInt64(u.Created.Unix())
+
+	return lw.Count, lw.Err
+}
+
+// uploads manages resumable uploads for an assetsDir.
+//
+// A session is created by POSTing to upload, with the 'path', 'name' and
+// 'size' query parameters, and chunks are then PUT to upload/<token>, with the
+// Upload-Offset header set to the position of the chunk. A HEAD request to the
+// session returns the number of bytes received in the Upload-Offset header,
+// and a POST to the session, once all of the data has been received, adds the
+// file to the store. A session can be cancelled with a DELETE request.
+type uploads struct {
+	*keystore.FileStore
+	dir    string
+	expiry time.Duration
+
+	mu       sync.Mutex
+	sessions map[string]*uploadSession
+}
+
+func (u *uploads) Init(c *config, name string) error {
+	var (
+		location keystore.String
+		expiry   keystore.Uint64
+	)
+
+	if err := c.Get("UploadsDir", &location); err != nil {
+		return fmt.Errorf("error getting uploads directory: %w", err)
+	}
+
+	if err := c.Get("UploadExpiry", &expiry); err != nil {
+		return fmt.Errorf("error getting upload expiry: %w", err)
+	}
+
+	u.dir = filepath.Join(c.BaseDir, string(location), name)
+	u.expiry = time.Duration(expiry) * time.Second
+	u.sessions = make(map[string]*uploadSession)
+
+	var err error
+
+	if u.FileStore, err = keystore.NewFileStore(u.dir, u.dir, keystore.NoMangle); err != nil {
+		return fmt.Errorf("error creating uploads store: %w", err)
+	}
+
+	for _, key := range u.Keys() {
+		token, ok := strings.CutSuffix(key, sessionExt)
+		if !ok {
+			if !u.Exists(key + sessionExt) {
+				u.Remove(key)
+			}
+
+			continue
+		}
+
+		s := new(uploadSession)
+
+		if err := u.Get(key, s); err != nil {
+			u.Remove(key)
+			u.Remove(token)
+
+			continue
+		}
+
+		u.sessions[token] = s
+	}
+
+	u.mu.Lock()
+	u.expireLocked()
+	u.mu.Unlock()
+
+	return nil
+}
+
+// expireLocked removes the sessions that have not received any data within the
+// expiry period.
+//
+// The caller must hold u.mu.
+func (u *uploads) expireLocked() {
+	if u.expiry == 0 {
+		return
+	}
+
+	before := time.Now().Add(-u.expiry)
+
+	for token, s := range u.sessions {
+		if u.lastActive(token, s).Before(before) {
+			u.removeLocked(token)
+		}
+	}
+}
+
+// lastActive returns the time that the session last received data, which is
+// the modification time of its data file, or the time it was created if that
+// is later.
+func (u *uploads) lastActive(token string, s *uploadSession) time.Time {
+	if fi, err := u.Stat(token); err == nil && fi.ModTime().After(s.Created) {
+		return fi.ModTime()
+	}
+
+	return s.Created
+}
+
+func (u *uploads) removeLocked(token string) {
+	u.Remove(token)
+	u.Remove(token + sessionExt)
+
+	delete(u.sessions, token)
+}
+
+func (u *uploads) get(token string) *uploadSession {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	return u.sessions[token]
+}
+
+func (u *uploads) create(folderPath, name string, size int64) (string, error) {
+	var b [16]byte
+
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+
+	token := hexenc.EncodeToString(b[:])
+	s := &uploadSession{
+		Path:    folderPath,
+		Name:    name,
+		Size:    size,
+		Created: time.Now(),
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.expireLocked()
+
+	f, err := os.OpenFile(filepath.Join(u.dir, token), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return "", err
+	}
+
+	f.Close()
+
+	if err := u.Set(token+sessionExt, s); err != nil {
+		u.Remove(token)
+
+		return "", err
+	}
+
+	u.sessions[token] = s
+
+	return token, nil
+}
+
+// received returns the number of bytes received for the given session.
+func (u *uploads) received(token string) (int64, error) {
+	fi, err := u.Stat(token)
+	if err != nil {
+		return 0, err
+	}
+
+	return fi.Size(), nil
+}
+
+func (a *assetsDir) serveUpload(w http.ResponseWriter, r *http.Request) {
+	token, hasToken := strings.CutPrefix(strings.TrimPrefix(r.URL.Path, uploadPrefix), "/")
+
+	if !hasToken {
+		if r.Method == http.MethodPost {
+			a.createUpload(w, r)
+		} else {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		}
+
+		return
+	}
+
+	s := a.uploads.get(token)
+	if s == nil {
+		http.NotFound(w, r)
+
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if a.uploads.get(token) != s {
+		http.NotFound(w, r)
+
+		return
+	}
+
+	received, err := a.uploads.received(token)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	w.Header().Set(uploadLength, strconv.FormatInt(s.Size, 10))
+
+	switch r.Method {
+	case http.MethodHead:
+		w.Header().Set(uploadOffset, strconv.FormatInt(received, 10))
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodPut:
+		a.putUpload(w, r, token, s, received)
+	case http.MethodPost:
+		a.finishUpload(w, r, token, s, received)
+	case http.MethodDelete:
+		a.uploads.mu.Lock()
+		a.uploads.removeLocked(token)
+		a.uploads.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+func (a *assetsDir) createUpload(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	size, err := strconv.ParseInt(q.Get("size"), 10, 64)
+	if err != nil || size <= 0 {
+		http.Error(w, "invalid size", http.StatusBadRequest)
+
+		return
+	}
+
+	if err := a.checkSpace("", uint64(size)); err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+
+		return
+	}
+
+	token, err := a.uploads.create(q.Get("path"), q.Get("name"), size)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	w.Header().Set("Location", uploadPrefix+"/"+token)
+	w.Header().Set(uploadOffset, "0")
+	w.Header().Set(uploadLength, strconv.FormatInt(size, 10))
+	w.Header().Set(contentType, "application/json")
+	w.WriteHeader(http.StatusCreated)
+	w.Write(appendString(nil, token))
+}
+
+func (a *assetsDir) putUpload(w http.ResponseWriter, r *http.Request, token string, s *uploadSession, received int64) {
+	defer r.Body.Close()
+
+	offset, err := strconv.ParseInt(r.Header.Get(uploadOffset), 10, 64)
+	if err != nil || offset != received {
+		w.Header().Set(uploadOffset, strconv.FormatInt(received, 10))
+		http.Error(w, "offset does not match received data", http.StatusConflict)
+
+		return
+	}
+
+	f, err := os.OpenFile(filepath.Join(a.uploads.dir, token), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	n, err := io.Copy(f, io.LimitReader(r.Body, s.Size-received+1))
+	if received+n > s.Size {
+		f.Truncate(received)
+		f.Close()
+		w.Header().Set(uploadOffset, strconv.FormatInt(received, 10))
+		http.Error(w, "upload exceeds declared size", http.StatusRequestEntityTooLarge)
+
+		return
+	}
+
+	if errr := f.Close(); err == nil {
+		err = errr
+	}
+
+	w.Header().Set(uploadOffset, strconv.FormatInt(received+n, 10))
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *assetsDir) finishUpload(w http.ResponseWriter, r *http.Request, token string, s *uploadSession, received int64) {
+	if received != s.Size {
+		w.Header().Set(uploadOffset, strconv.FormatInt(received, 10))
+		http.Error(w, "upload incomplete", http.StatusConflict)
+
+		return
+	}
+
+	f, err := os.Open(filepath.Join(a.uploads.dir, token))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	id, err := a.addFile(&uploader{hash: sha256.New()}, f)
+
+	f.Close()
+
+	var (
+		added    []idName
+		rejected []rejection
+	)
+
+	if reason, ok := err.(rejectionError); ok {
+		rejected = append(rejected, rejection{Name: s.Name, Reason: reason.Error()})
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	a.uploads.mu.Lock()
+	a.uploads.removeLocked(token)
+	a.uploads.mu.Unlock()
+
+	a.mu.Lock()
+
+	folderPath, folder := a.uploadFolder(s.Path)
+
+	if err == nil {
+		filename := s.Name
+		if filename == "" || strings.ContainsAny(filename, invalidFilenameChars) {
+			filename = strconv.FormatUint(id, 10)
+		}
+
+		added = append(added, idName{id, addItemTo(folder, filename, id)})
+	}
+
+	a.mu.Unlock()
+	a.writeUploaded(w, r, folderPath, added, rejected)
+}
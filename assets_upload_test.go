@@ -0,0 +1,56 @@
+package battlemap
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestUploadExpiry(t *testing.T) {
+	var c config
+
+	dir := t.TempDir()
+
+	if err := c.Init(dir); err != nil {
+		t.Fatalf("unexpected error initialising config: %s", err)
+	}
+
+	var u uploads
+
+	if err := u.Init(&c, "test"); err != nil {
+		t.Fatalf("unexpected error initialising uploads: %s", err)
+	}
+
+	u.expiry = time.Hour
+	now := time.Now()
+
+	for n, test := range [...]struct {
+		Created, Modified time.Duration
+		Expired           bool
+	}{
+		{0, 0, false},
+		{-2 * time.Hour, -2 * time.Hour, true},
+		{-2 * time.Hour, -time.Minute, false},
+		{-time.Minute, -2 * time.Hour, false},
+	} {
+		token, err := u.create("/", "file", 10)
+		if err != nil {
+			t.Fatalf("test %d: unexpected error creating session: %s", n+1, err)
+		}
+
+		u.sessions[token].Created = now.Add(test.Created)
+
+		if err := os.Chtimes(filepath.Join(u.dir, token), now, now.Add(test.Modified)); err != nil {
+			t.Fatalf("test %d: unexpected error setting modification time: %s", n+1, err)
+		}
+
+		u.mu.Lock()
+		u.expireLocked()
+		u.mu.Unlock()
+
+		if expired := u.get(token) == nil; expired != test.Expired {
+			t.Errorf("test %d: expecting expired to be %v, got %v", n+1, test.Expired, expired)
+		}
+	}
+}
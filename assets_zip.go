@@ -0,0 +1,311 @@
+package battlemap
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+const (
+	zipPrefix = "zip"
+
+	// maxZipSize is the largest zip archive that can be uploaded.
+	maxZipSize = 1 << 30
+
+	// maxZipUncompressed is the largest total size of the files extracted
+	// from an uploaded archive; files past this limit are rejected.
+	maxZipUncompressed = 4 << 30
+
+	// maxZipEntries is the largest number of entries an uploaded archive
+	// can contain.
+	maxZipEntries = 10000
+)
+
+// serveZip handles the importing, with a POST, and exporting, with a GET, of
+// folders as zip archives; both use the 'path' query parameter to specify the
+// folder.
+func (a *assetsDir) serveZip(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		a.exportZip(w, r)
+	case http.MethodPost:
+		a.importZip(w, r)
+	default:
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+// importZip adds all of the files in an uploaded zip archive to the store,
+// recreating the folder structure of the archive under the given folder.
+//
+// Directories and files that are hidden, or are macOS resource forks, are
+// ignored, and files that are not accepted by the store are rejected as with
+// any other upload.
+//
+// Archives larger than maxZipSize, or with more than maxZipEntries entries,
+// are refused, and files are rejected once the total extracted size exceeds
+// maxZipUncompressed.
+func (a *assetsDir) importZip(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxZipSize)
+
+	defer r.Body.Close()
+
+	tmp, err := os.CreateTemp("", "battlemap-zip-*")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	size, err := io.Copy(tmp, r.Body)
+	if mbe := (*http.MaxBytesError)(nil); errors.As(err, &mbe) {
+		http.Error(w, ErrZipTooLarge.Error(), http.StatusRequestEntityTooLarge)
+
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	zr, err := zip.NewReader(tmp, size)
+	if err != nil {
+		http.Error(w, "invalid zip file: "+err.Error(), http.StatusBadRequest)
+
+		return
+	} else if len(zr.File) > maxZipEntries {
+		http.Error(w, ErrTooManyZipEntries.Error(), http.StatusRequestEntityTooLarge)
+
+		return
+	}
+
+	var (
+		added    []idName
+		rejected []rejection
+		created  []string
+	)
+
+	a.mu.Lock()
+	folderPath, _ := a.uploadFolder(r.URL.Query().Get("path"))
+	a.mu.Unlock()
+
+	u := uploader{hash: sha256.New()}
+	remaining := int64(maxZipUncompressed)
+
+	for _, zf := range zr.File {
+		dir, file, ok := zipEntryPath(zf.Name)
+		if !ok {
+			continue
+		} else if strings.ContainsAny(dir+file, "\x00\r\n") {
+			rejected = append(rejected, rejection{Name: zf.Name, Reason: ErrInvalidName.Error()})
+
+			continue
+		}
+
+		if zf.FileInfo().IsDir() {
+			a.mu.Lock()
+			_, created = a.makeFolders(folderPath+dir+file, created)
+			a.mu.Unlock()
+
+			continue
+		}
+
+		id, err := a.addZipFile(&u, zf, &remaining)
+		if err != nil {
+			rejected = append(rejected, rejection{Name: zf.Name, Reason: err.Error()})
+
+			continue
+		}
+
+		a.mu.Lock()
+
+		var fd *folder
+
+		fd, created = a.makeFolders(folderPath+dir, created)
+		added = append(added, idName{id, dir + addItemTo(fd.Items, file, id)})
+
+		a.mu.Unlock()
+	}
+
+	if len(created) > 0 {
+		if len(added) == 0 {
+			a.mu.Lock()
+			a.saveFolders()
+			a.mu.Unlock()
+		}
+
+		id := SocketIDFromRequest(r)
+
+		for _, p := range created {
+			a.socket.broadcastAdminChange(a.getBroadcastID(broadcastImageFolderAdd), appendString(nil, p), id)
+		}
+	}
+
+	a.writeUploaded(w, r, folderPath, added, rejected)
+}
+
+// addZipFile adds a single file from an uploaded archive, deducting the
+// extracted size from the remaining allowance for the archive.
+func (a *assetsDir) addZipFile(u *uploader, zf *zip.File, remaining *int64) (uint64, error) {
+	if *remaining < 0 {
+		return 0, rejectionError(ErrZipTooLarge.Error())
+	}
+
+	f, err := zf.Open()
+	if err != nil {
+		return 0, err
+	}
+
+	defer f.Close()
+
+	q := &quotaReader{Reader: f, remaining: *remaining, exceeded: rejectionError(ErrZipTooLarge.Error())}
+	id, err := a.addFile(u, q)
+	*remaining = q.remaining
+
+	if q.err != nil {
+		return 0, q.err
+	}
+
+	return id, err
+}
+
+// zipEntryPath splits the name of a zip entry into a cleaned, relative folder
+// path, with a trailing slash when not empty, and a file name.
+//
+// Entries that are hidden, or are within a hidden or resource fork directory,
+// are reported as not ok.
+func zipEntryPath(name string) (string, string, bool) {
+	p := path.Clean("/" + strings.ReplaceAll(name, "\\", "/"))
+	if p == "/" {
+		return "", "", false
+	}
+
+	for _, part := range strings.Split(p[1:], "/") {
+		if strings.HasPrefix(part, ".") || part == "__MACOSX" {
+			return "", "", false
+		}
+	}
+
+	dir, file := path.Split(p[1:])
+
+	return dir, file, true
+}
+
+// makeFolders returns the folder with the given path, creating it, and any of
+// its parents, as needed; the paths of all created folders are appended to the
+// given list.
+//
+// The caller must hold f.mu for writing.
+func (f *folders) makeFolders(p string, created []string) (*folder, []string) {
+	d := f.root
+	curr := ""
+
+	for _, name := range strings.Split(p, "/") {
+		if name == "" {
+			continue
+		}
+
+		curr += "/" + name
+
+		fd, ok := d.Folders[name]
+		if !ok {
+			fd = newFolder()
+			d.Folders[name] = fd
+			created = append(created, curr)
+		}
+
+		d = fd
+	}
+
+	return d, created
+}
+
+// exportZip writes the given folder, and all of its sub-folders, as a zip
+// archive, using the names of the items within the folders as file names.
+func (a *assetsDir) exportZip(w http.ResponseWriter, r *http.Request) {
+	type zipItem struct {
+		name string
+		id   uint64
+	}
+
+	var (
+		items   []zipItem
+		folders []string
+	)
+
+	folderPath := path.Clean("/" + r.URL.Query().Get("path"))
+
+	a.mu.RLock()
+
+	fd := a.getFolder(folderPath)
+	if fd != nil {
+		walkFolderPaths(fd, "", func(p string, fd *folder) {
+			if p != "" && len(fd.Items) == 0 && len(fd.Folders) == 0 {
+				folders = append(folders, p)
+			}
+		})
+		walkPaths(fd, "", func(p, name string, id uint64) {
+			items = append(items, zipItem{p + name, id})
+		})
+	}
+
+	a.mu.RUnlock()
+
+	if fd == nil {
+		http.NotFound(w, r)
+
+		return
+	}
+
+	name := path.Base(folderPath)
+	if name == "/" {
+		name = "images"
+
+		if a.fileType == fileTypeAudio {
+			name = "audio"
+		}
+	}
+
+	w.Header().Set(contentType, "application/zip")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name + ".zip"}))
+
+	zw := zip.NewWriter(w)
+
+	for _, p := range folders {
+		zw.CreateHeader(&zip.FileHeader{Name: p, Method: zip.Store})
+	}
+
+	for _, item := range items {
+		idStr := strconv.FormatUint(item.id, 10)
+
+		fi, err := a.Stat(idStr)
+		if err != nil {
+			continue
+		}
+
+		zf, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     item.name,
+			Method:   zip.Store,
+			Modified: fi.ModTime(),
+		})
+		if err != nil {
+			return
+		}
+
+		a.Get(idStr, readerFromFunc(func(r io.Reader) {
+			io.Copy(zf, r)
+		}))
+	}
+
+	zw.Close()
+}
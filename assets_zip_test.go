@@ -0,0 +1,28 @@
+package battlemap
+
+import "testing"
+
+func TestZipEntryPath(t *testing.T) {
+	for n, test := range [...]struct {
+		Name, Dir, File string
+		OK              bool
+	}{
+		{"token.png", "", "token.png", true},
+		{"goblins/archer.png", "goblins/", "archer.png", true},
+		{"goblins\\archer.png", "goblins/", "archer.png", true},
+		{"goblins/", "", "goblins", true},
+		{"../../etc/passwd", "etc/", "passwd", true},
+		{"/a/./b/../c.png", "a/", "c.png", true},
+		{".hidden.png", "", "", false},
+		{"goblins/.DS_Store", "", "", false},
+		{"__MACOSX/goblins/._archer.png", "", "", false},
+		{"/", "", "", false},
+	} {
+		dir, file, ok := zipEntryPath(test.Name)
+		if ok != test.OK {
+			t.Errorf("test %d: expecting ok %v, got %v", n+1, test.OK, ok)
+		} else if dir != test.Dir || file != test.File {
+			t.Errorf("test %d: expecting %q, %q, got %q, %q", n+1, test.Dir, test.File, dir, file)
+		}
+	}
+}
@@ -21,6 +21,15 @@ type Auth interface {
 	IsUser(*http.Request) bool
 }
 
+// PlayerAuth is an optional extension to the Auth interface, which identifies
+// the individual player making a request, allowing data to be shared with
+// specific players.
+//
+// An empty string is returned for unidentified users.
+type PlayerAuth interface {
+	Player(*http.Request) string
+}
+
 type userState uint8
 
 const (
@@ -144,3 +153,21 @@ func (b *Battlemap) authConn(w *websocket.Conn) userState {
 
 	return userStateNone
 }
+
+// identifiesPlayers determines whether the Auth module can identify individual
+// players.
+func (b *Battlemap) identifiesPlayers() bool {
+	_, ok := b.auth.(PlayerAuth)
+
+	return ok
+}
+
+// player returns the player identified by the request, if the Auth module
+// supports identifying players.
+func (b *Battlemap) player(r *http.Request) string {
+	if pa, ok := b.auth.(PlayerAuth); ok {
+		return pa.Player(r)
+	}
+
+	return ""
+}
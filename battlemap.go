@@ -6,6 +6,7 @@ import (
 	"strings"
 
 	"golang.org/x/net/websocket"
+	"vimagination.zapto.org/keystore"
 )
 
 // Battlemap contains all of the data required for a battlemap system.
@@ -22,6 +23,9 @@ type Battlemap struct {
 	chars      charactersDir
 	maps       mapsDir
 	plugins    pluginsDir
+	recordings recordingsDir
+	files      filesDir
+	visible    visibleAssets
 	mux        http.ServeMux
 }
 
@@ -78,28 +82,39 @@ func (b *Battlemap) initModules(path string, a Auth) error {
 		{"Chars", &b.chars},
 		{"Maps", &b.maps},
 		{"Plugins", &b.plugins},
+		{"Recordings", &b.recordings},
+		{"Files", &b.files},
 	} {
 		if err := m.Module.Init(b, l); err != nil {
 			return fmt.Errorf(moduleError, m.Name, err)
 		}
 	}
 
-	b.chars.cleanup(l.chars)
-	b.images.cleanup(l.images)
-	b.audio.cleanup(l.audio)
-	b.musicPacks.cleanup(l.music)
+	var autoCleanup keystore.Uint8
+
+	b.config.Get("AutoCleanup", &autoCleanup)
+
+	if autoCleanup != 0 {
+		b.chars.cleanup(l.chars)
+		b.images.cleanup(l.images)
+		b.audio.cleanup(l.audio)
+		b.musicPacks.cleanup(l.music)
+		b.files.cleanup()
+	}
 
 	return nil
 }
 
 func (b *Battlemap) initMux(index http.Handler) {
 	b.mux.Handle("/socket", websocket.Handler(b.socket.ServeConn))
+	b.mux.Handle("/replay", &b.recordings)
 
 	for path, module := range map[string]http.Handler{
 		"/login/":   b.auth,
 		"/images/":  &b.images,
 		"/audio/":   &b.audio,
 		"/plugins/": &b.plugins,
+		"/files/":   &b.files,
 	} {
 		p := strings.TrimSuffix(path, "/")
 
@@ -110,6 +125,25 @@ func (b *Battlemap) initMux(index http.Handler) {
 	b.mux.Handle("/", index)
 }
 
+// Close writes any pending changes to disk and stops any running recording.
+//
+// It should be called after the server has stopped accepting requests.
+func (b *Battlemap) Close() error {
+	err := b.maps.close()
+
+	for _, a := range [...]*assetsDir{&b.images, &b.audio} {
+		if errr := a.flushMeta(); errr != nil && err == nil {
+			err = errr
+		}
+	}
+
+	if errr := b.recordings.stop(); errr != nil && errr != ErrNotRecording && err == nil {
+		err = errr
+	}
+
+	return err
+}
+
 func (b *Battlemap) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	b.mux.ServeHTTP(w, b.auth.Auth(r))
 }
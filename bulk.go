@@ -0,0 +1,115 @@
+package battlemap
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+)
+
+type bulkOp struct {
+	Op   string `json:"op"`
+	Path string `json:"path,omitempty"`
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+}
+
+func (f *folder) clone() *folder {
+	c := &folder{
+		Folders:     make(map[string]*folder, len(f.Folders)),
+		Items:       make(map[string]uint64, len(f.Items)),
+		FolderOrder: slices.Clone(f.FolderOrder),
+		ItemOrder:   slices.Clone(f.ItemOrder),
+	}
+
+	for name, fd := range f.Folders {
+		c.Folders[name] = fd.clone()
+	}
+
+	for name, id := range f.Items {
+		c.Items[name] = id
+	}
+
+	return c
+}
+
+// bulk applies a list of folder operations, in order, as a single change.
+//
+// Each operation is one of createFolder, removeFolder, and remove, which take
+// a path, or move and moveFolder, which take from and to paths. If any of the
+// operations fail, none of them are applied.
+//
+// There is no copy operation, as copying a map or character creates a new
+// file, which, unlike the folder tree, cannot be restored should a later
+// operation fail.
+//
+// The resulting list of operations, with the final paths of any created or
+// moved items and folders, is broadcast to other admins and returned.
+func (f *folders) bulk(cd ConnData, data json.RawMessage) (json.RawMessage, error) {
+	return f.bulkChecked(cd, data, nil)
+}
+
+// bulkChecked applies a list of folder operations as bulk does, first calling
+// the given function, if any, for each operation, with the folder tree as it
+// is after the preceding operations; an error from the function fails the
+// whole list.
+func (f *folders) bulkChecked(cd ConnData, data json.RawMessage, check func(bulkOp) error) (json.RawMessage, error) {
+	var ops []bulkOp
+
+	if err := json.Unmarshal(data, &ops); err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	snapshot := f.root.clone()
+	removed := make(map[uint64]string)
+
+	for n := range ops {
+		op := &ops[n]
+
+		var err error
+
+		if check != nil {
+			err = check(*op)
+		}
+
+		switch {
+		case err != nil:
+		case op.Op == "createFolder":
+			op.Path, err = f.createFolderLocked(op.Path)
+		case op.Op == "move":
+			op.To, err = f.moveItemLocked(op.From, op.To)
+		case op.Op == "moveFolder":
+			op.To, err = f.moveFolderLocked(op.From, op.To)
+		case op.Op == "remove":
+			err = f.removeItemLocked(op.Path, removed)
+		case op.Op == "removeFolder":
+			err = f.removeFolderLocked(op.Path, removed)
+		default:
+			err = ErrUnknownMethod
+		}
+
+		if err != nil {
+			f.root = snapshot
+
+			return nil, fmt.Errorf("error in operation %d (%s): %w", n, op.Op, err)
+		}
+	}
+
+	if len(ops) == 0 {
+		return json.RawMessage("[]"), nil
+	}
+
+	f.trashRemovedLocked(removed)
+	f.saveFolders()
+
+	result, err := json.Marshal(ops)
+	if err != nil {
+		return nil, err
+	}
+
+	f.socket.broadcastAdminChange(f.getBroadcastID(broadcastImageFolderBulk), result, cd.ID)
+
+	return result, nil
+}
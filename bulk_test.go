@@ -0,0 +1,106 @@
+package battlemap
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"vimagination.zapto.org/keystore"
+)
+
+func TestBulkRollback(t *testing.T) {
+	dir := t.TempDir()
+
+	var c config
+
+	if err := c.Init(dir); err != nil {
+		t.Fatalf("unexpected error initialising config: %s", err)
+	}
+
+	store, err := keystore.NewFileStore(dir, dir, keystore.NoMangle)
+	if err != nil {
+		t.Fatalf("unexpected error creating store: %s", err)
+	}
+
+	for _, id := range [...]uint64{1, 2, 3} {
+		store.Set(strconv.FormatUint(id, 10), keystore.String("data"))
+	}
+
+	f := folders{
+		Battlemap: &Battlemap{config: c},
+		FileStore: store,
+		root:      newFolder(),
+		tags:      itemTags{1: {"goblin"}, 2: {"orc"}},
+		space:     storeSpace{sizes: make(map[uint64]uint64)},
+	}
+
+	f.root.Items["a"] = 1
+	f.root.Folders["b"] = newFolder()
+	f.root.Folders["b"].Items["c"] = 2
+	f.root.Folders["b"].Items["d"] = 3
+	f.root.Folders["b"].ItemOrder = []string{"d", "c"}
+
+	if err := f.initTrash("test", dir); err != nil {
+		t.Fatalf("unexpected error initialising trash: %s", err)
+	}
+
+	for n, test := range [...]struct {
+		Ops   []bulkOp
+		Check func(bulkOp) error
+		Err   error
+	}{
+		{
+			Ops: []bulkOp{
+				{Op: "createFolder", Path: "/e"},
+				{Op: "remove", Path: "/a"},
+				{Op: "move", From: "/b/c", To: "/e/c"},
+				{Op: "removeFolder", Path: "/b"},
+				{Op: "remove", Path: "/missing"},
+			},
+			Err: ErrItemNotFound,
+		},
+		{
+			Ops: []bulkOp{
+				{Op: "moveFolder", From: "/b", To: "/f"},
+				{Op: "unknown"},
+			},
+			Err: ErrUnknownMethod,
+		},
+		{
+			Ops: []bulkOp{
+				{Op: "remove", Path: "/a"},
+				{Op: "remove", Path: "/b/c"},
+			},
+			Check: func(op bulkOp) error {
+				if op.Path == "/b/c" {
+					return ErrCurrentlyInUse
+				}
+
+				return nil
+			},
+			Err: ErrCurrentlyInUse,
+		},
+	} {
+		root := f.root.clone()
+		tags := map[uint64][]string{1: {"goblin"}, 2: {"orc"}}
+		data, _ := json.Marshal(test.Ops)
+
+		if _, err := f.bulkChecked(ConnData{}, data, test.Check); !errors.Is(err, test.Err) {
+			t.Errorf("test %d: expecting error %v, got %v", n+1, test.Err, err)
+		} else if !reflect.DeepEqual(f.root, root) {
+			t.Errorf("test %d: expecting folder tree to be unchanged", n+1)
+		} else if len(f.trash.items) != 0 {
+			t.Errorf("test %d: expecting trash to be empty, got %v", n+1, f.trash.items)
+		} else if !reflect.DeepEqual(map[uint64][]string(f.tags), tags) {
+			t.Errorf("test %d: expecting tags to be unchanged, got %v", n+1, f.tags)
+		} else {
+			for _, id := range [...]string{"1", "2", "3"} {
+				if !f.Exists(id) {
+					t.Errorf("test %d: expecting file %s to remain in the store", n+1, id)
+				}
+			}
+		}
+	}
+}
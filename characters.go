@@ -5,7 +5,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"maps"
 	"path/filepath"
+	"slices"
 	"strconv"
 
 	"vimagination.zapto.org/byteio"
@@ -68,7 +70,10 @@ type charactersDir struct {
 
 	fileStore *keystore.FileStore
 
-	data map[string]characterData
+	data    map[string]characterData
+	schemas characterSchemas
+	owners  characterOwners
+	history characterHistory
 }
 
 func (c *charactersDir) Init(b *Battlemap, links links) error {
@@ -92,6 +97,54 @@ func (c *charactersDir) Init(b *Battlemap, links links) error {
 		return fmt.Errorf("error parsing characters keystore folders: %w", err)
 	}
 
+	c.trashed = func(ids []uint64) {
+		owned := false
+
+		for _, id := range ids {
+			delete(c.data, strconv.FormatUint(id, 10))
+
+			if _, ok := c.owners[id]; ok {
+				delete(c.owners, id)
+
+				owned = true
+			}
+		}
+
+		if owned {
+			c.fileStore.Set(characterOwnersKey, c.owners)
+		}
+	}
+
+	if err := c.history.Init(&b.config); err != nil {
+		return err
+	}
+
+	c.purged = c.history.remove
+
+	if err := c.initTrash("characters", sp); err != nil {
+		return err
+	}
+
+	if err := c.initQuota("Characters"); err != nil {
+		return err
+	}
+
+	c.schemas = make(characterSchemas)
+
+	if c.fileStore.Exists(characterSchemasKey) {
+		if err := c.fileStore.Get(characterSchemasKey, c.schemas); err != nil {
+			return fmt.Errorf("error reading character schemas: %w", err)
+		}
+	}
+
+	c.owners = make(characterOwners)
+
+	if c.fileStore.Exists(characterOwnersKey) {
+		if err := c.fileStore.Get(characterOwnersKey, c.owners); err != nil {
+			return fmt.Errorf("error reading character owners: %w", err)
+		}
+	}
+
 	c.data = make(map[string]characterData)
 
 	for id := range links.chars {
@@ -124,6 +177,28 @@ func (c *charactersDir) RPCData(cd ConnData, method string, data json.RawMessage
 		return c.get(cd, data)
 	case "copy":
 		return c.copy(cd, data)
+	case "restore":
+		if cd.IsAdmin() {
+			return c.restore(cd, data)
+		}
+
+		return nil, ErrUnknownMethod
+	case "schemas":
+		return c.getSchemas(), nil
+	case "setSchema":
+		return nil, c.setSchema(cd, data)
+	case "removeSchema":
+		return nil, c.removeSchema(cd, data)
+	case "owners":
+		return c.listOwners()
+	case "setOwners":
+		return nil, c.setOwners(cd, data)
+	case "owned":
+		return c.owned(cd)
+	case "history":
+		return c.getHistory(data)
+	case "revert":
+		return c.revert(cd, data)
 	default:
 		return c.folders.RPCData(cd, method, data)
 	}
@@ -143,7 +218,18 @@ func (c *charactersDir) create(cd ConnData, data json.RawMessage) (json.RawMessa
 		nameData.Data = make(characterData)
 	}
 
+	if err := c.checkSpace("", encodedSize(nameData.Data)); err != nil {
+		return nil, err
+	}
+
 	c.mu.Lock()
+
+	if err := c.schemas.validate(nameData.Data); err != nil {
+		c.mu.Unlock()
+
+		return nil, err
+	}
+
 	c.lastID++
 	kid := c.lastID
 	nameData.Path = addItemTo(c.root.Items, nameData.Path, kid)
@@ -155,6 +241,7 @@ func (c *charactersDir) create(cd ConnData, data json.RawMessage) (json.RawMessa
 	c.mu.Unlock()
 
 	c.fileStore.Set(strID, nameData.Data)
+	c.updateUsage(kid)
 
 	buf := append(appendString(append(append(append(json.RawMessage{}, "[{\"id\":"...), strID...), ",\"path\":"...), nameData.Path), '}', ']')
 
@@ -163,6 +250,10 @@ func (c *charactersDir) create(cd ConnData, data json.RawMessage) (json.RawMessa
 	return buf[1 : len(buf)-1], nil
 }
 
+// modify sets and removes keys of a character.
+//
+// An error recording the change in the history of the character is returned
+// after the change has been made.
 func (c *charactersDir) modify(cd ConnData, data json.RawMessage) error {
 	var m struct {
 		ID       json.RawMessage         `json:"id"`
@@ -186,6 +277,57 @@ func (c *charactersDir) modify(cd ConnData, data json.RawMessage) error {
 		return keystore.ErrUnknownKey
 	}
 
+	if !cd.IsAdmin() {
+		if err := c.checkPlayerModify(cd, string(m.ID), ms, m.Setting, m.Removing); err != nil {
+			return err
+		}
+
+		if m.Setting == nil {
+			m.Setting = map[string]keystoreData{}
+		}
+
+		if m.Removing == nil {
+			m.Removing = []string{}
+		}
+
+		data, _ = json.Marshal(m)
+	}
+
+	if err := c.schemas.validate(m.Setting); err != nil {
+		return err
+	}
+
+	if c.quota.limited() {
+		nd := maps.Clone(ms)
+
+		for key, val := range m.Setting {
+			nd[key] = val
+		}
+
+		for _, key := range m.Removing {
+			delete(nd, key)
+		}
+
+		if err := c.checkSpace(string(m.ID), encodedSize(nd)); err != nil {
+			return err
+		}
+	}
+
+	changes := make([]historyChange, 0, len(m.Setting)+len(m.Removing))
+
+	for _, key := range slices.Sorted(maps.Keys(m.Setting)) {
+		old, ok := ms[key]
+		changes = append(changes, historyChange{Key: key, Old: historyValue{Set: ok, User: old.User, Data: old.Data}})
+	}
+
+	for _, key := range m.Removing {
+		if _, ok := m.Setting[key]; ok {
+			continue
+		} else if old, ok := ms[key]; ok {
+			changes = append(changes, historyChange{Key: key, Old: historyValue{Set: true, User: old.User, Data: old.Data}})
+		}
+	}
+
 	c.socket.broadcastAdminChange(broadcastCharacterDataChange, data, cd.ID)
 
 	var userRemoves []string
@@ -246,8 +388,27 @@ func (c *charactersDir) modify(cd ConnData, data json.RawMessage) error {
 	cd.CurrentMap = 0
 
 	c.socket.broadcastMapChange(cd, broadcastCharacterDataChange, buf, userNotAdmin)
+	c.visible.invalidate()
 
-	return c.fileStore.Set(string(m.ID), ms)
+	if err := c.fileStore.Set(string(m.ID), ms); err != nil {
+		return err
+	}
+
+	for n, ch := range changes {
+		if val, ok := ms[ch.Key]; ok {
+			changes[n].New = historyValue{Set: true, User: val.User, Data: val.Data}
+		}
+	}
+
+	id, _ := strconv.ParseUint(string(m.ID), 10, 64)
+
+	c.updateUsage(id)
+
+	if err := c.history.add(id, cd, changes); err != nil {
+		return fmt.Errorf("error recording character history: %w", err)
+	}
+
+	return nil
 }
 
 func (c *charactersDir) get(cd ConnData, id json.RawMessage) (json.RawMessage, error) {
@@ -312,11 +473,18 @@ func (c *charactersDir) copy(cd ConnData, data json.RawMessage) (json.RawMessage
 		d[key] = val
 	}
 
+	if err := c.checkSpace("", encodedSize(d)); err != nil {
+		c.mu.Unlock()
+
+		return nil, err
+	}
+
 	c.lastID++
 	kid := c.lastID
 	strID := strconv.FormatUint(kid, 10)
 
 	c.fileStore.Set(strID, d)
+	c.updateUsage(kid)
 
 	newName := addItemTo(p.Items, name, kid)
 
@@ -335,3 +503,33 @@ func (c *charactersDir) copy(cd ConnData, data json.RawMessage) (json.RawMessage
 
 	return data, nil
 }
+
+func (c *charactersDir) restore(cd ConnData, data json.RawMessage) (json.RawMessage, error) {
+	var id uint64
+
+	if err := json.Unmarshal(data, &id); err != nil {
+		return nil, err
+	}
+
+	path, err := c.restoreItem(id)
+	if err != nil {
+		return nil, err
+	}
+
+	strID := strconv.FormatUint(id, 10)
+	km := make(characterData)
+
+	if err := c.fileStore.Get(strID, km); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.data[strID] = km
+	c.mu.Unlock()
+
+	buf := append(appendString(append(append(append(json.RawMessage{}, "[{\"id\":"...), strID...), ",\"path\":"...), path), '}', ']')
+
+	c.socket.broadcastAdminChange(broadcastCharacterItemAdd, buf, cd.ID)
+
+	return buf[1 : len(buf)-1], nil
+}
@@ -0,0 +1,444 @@
+package battlemap
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"vimagination.zapto.org/byteio"
+	"vimagination.zapto.org/keystore"
+	"vimagination.zapto.org/memio"
+)
+
+// historyValue is the value of a character key before or after a change; Set
+// is false when the key did not exist.
+type historyValue struct {
+	Set  bool
+	User bool
+	Data json.RawMessage
+}
+
+func (h historyValue) appendTo(buf json.RawMessage) json.RawMessage {
+	if !h.Set {
+		return append(buf, "null"...)
+	}
+
+	buf = strconv.AppendBool(append(buf, "{\"user\":"...), h.User)
+
+	return append(append(append(buf, ",\"data\":"...), h.Data...), '}')
+}
+
+type historyChange struct {
+	Key      string
+	Old, New historyValue
+}
+
+// historyEntry records a single modification of a character.
+type historyEntry struct {
+	ID      uint64
+	Time    time.Time
+	Admin   bool
+	Player  string
+	Changes []historyChange
+}
+
+func (h *historyEntry) writeTo(lw *byteio.StickyLittleEndianWriter) {
+	lw.WriteUintX(h.ID)
+	lw.WriteInt64(h.Time.UnixMilli())
+	lw.WriteBool(h.Admin)
+	lw.WriteStringX(h.Player)
+	lw.WriteUintX(uint64(len(h.Changes)))
+
+	for _, c := range h.Changes {
+		lw.WriteStringX(c.Key)
+
+		for _, v := range [...]historyValue{c.Old, c.New} {
+			lw.WriteBool(v.Set)
+			lw.WriteBool(v.User)
+			lw.WriteBytesX(v.Data)
+		}
+	}
+}
+
+func (h *historyEntry) readFrom(lr *byteio.StickyLittleEndianReader) {
+	h.ID = lr.ReadUintX()
+	h.Time = time.UnixMilli(lr.ReadInt64())
+	h.Admin = lr.ReadBool()
+	h.Player = lr.ReadStringX()
+	h.Changes = nil
+
+	for l := readLength(lr); l > 0 && lr.Err == nil; l-- {
+		c := historyChange{Key: lr.ReadStringX()}
+
+		for _, v := range [...]*historyValue{&c.Old, &c.New} {
+			v.Set = lr.ReadBool()
+			v.User = lr.ReadBool()
+			v.Data = lr.ReadBytesX()
+		}
+
+		h.Changes = append(h.Changes, c)
+	}
+}
+
+func (h *historyEntry) appendTo(buf json.RawMessage) json.RawMessage {
+	buf = strconv.AppendUint(append(buf, "{\"id\":"...), h.ID, 10)
+	buf = strconv.AppendInt(append(buf, ",\"time\":"...), h.Time.UnixMilli(), 10)
+	buf = strconv.AppendBool(append(buf, ",\"admin\":"...), h.Admin)
+	buf = append(appendString(append(buf, ",\"player\":"...), h.Player), ",\"changes\":["...)
+
+	for n, c := range h.Changes {
+		if n > 0 {
+			buf = append(buf, ',')
+		}
+
+		buf = c.Old.appendTo(append(appendString(append(buf, "{\"key\":"...), c.Key), ",\"old\":"...))
+		buf = append(c.New.appendTo(append(buf, ",\"new\":"...)), '}')
+	}
+
+	return append(buf, ']', '}')
+}
+
+type historyEntries []historyEntry
+
+// ReadFrom reads history entries until the end of the data; a partially written
+// final entry is ignored.
+func (h *historyEntries) ReadFrom(r io.Reader) (int64, error) {
+	lr := byteio.StickyLittleEndianReader{Reader: r}
+
+	for {
+		var e historyEntry
+
+		e.readFrom(&lr)
+
+		if lr.Err != nil {
+			break
+		}
+
+		*h = append(*h, e)
+	}
+
+	if errors.Is(lr.Err, io.EOF) || errors.Is(lr.Err, io.ErrUnexpectedEOF) {
+		return lr.Count, nil
+	}
+
+	return lr.Count, lr.Err
+}
+
+func (h historyEntries) WriteTo(w io.Writer) (int64, error) {
+	lw := byteio.StickyLittleEndianWriter{Writer: w}
+
+	for n := range h {
+		h[n].writeTo(&lw)
+	}
+
+	return lw.Count, lw.Err
+}
+
+// revert returns the changes needed to return a character to the state it had
+// before the entry with the given ID, undoing that entry and all that follow
+// it.
+func (h historyEntries) revert(id uint64) (map[string]keystoreData, []string, error) {
+	if !slices.ContainsFunc(h, func(e historyEntry) bool { return e.ID == id }) {
+		return nil, nil, ErrUnknownHistoryEntry
+	}
+
+	state := make(map[string]historyValue)
+
+	for n := len(h) - 1; n >= 0 && h[n].ID >= id; n-- {
+		for _, c := range h[n].Changes {
+			state[c.Key] = c.Old
+		}
+	}
+
+	setting := make(map[string]keystoreData)
+	removing := []string{}
+
+	for key, v := range state {
+		if v.Set {
+			setting[key] = keystoreData{User: v.User, Data: v.Data}
+		} else {
+			removing = append(removing, key)
+		}
+	}
+
+	slices.Sort(removing)
+
+	return setting, removing, nil
+}
+
+// characterHistory keeps an append-only log, for each character, of the
+// changes made to it.
+//
+// The log of a character is limited to the most recent entries, and entries
+// older than the retention period are removed, whenever a new entry is added;
+// expired entries are also removed from all logs at startup, and the log of a
+// character is deleted when the character is purged from the trash.
+type characterHistory struct {
+	dir       string
+	limit     uint64
+	retention time.Duration
+
+	mu sync.Mutex
+}
+
+func (h *characterHistory) Init(c *config) error {
+	var (
+		location         keystore.String
+		limit, retention keystore.Uint64
+	)
+
+	if err := c.Get("CharHistoryDir", &location); err != nil {
+		return fmt.Errorf("error getting character history directory: %w", err)
+	}
+
+	if err := c.Get("CharHistoryLimit", &limit); err != nil {
+		return fmt.Errorf("error getting character history limit: %w", err)
+	}
+
+	if err := c.Get("CharHistoryRetention", &retention); err != nil {
+		return fmt.Errorf("error getting character history retention: %w", err)
+	}
+
+	h.dir = filepath.Join(c.BaseDir, string(location))
+	h.limit = uint64(limit)
+	h.retention = time.Duration(retention) * time.Second
+
+	if err := os.MkdirAll(h.dir, 0o700); err != nil {
+		return fmt.Errorf("error creating character history directory: %w", err)
+	}
+
+	return h.pruneExpired()
+}
+
+// pruneExpired removes the entries older than the retention period from every
+// log, deleting logs that are left empty.
+func (h *characterHistory) pruneExpired() error {
+	if h.retention == 0 {
+		return nil
+	}
+
+	files, err := os.ReadDir(h.dir)
+	if err != nil {
+		return fmt.Errorf("error reading character history directory: %w", err)
+	}
+
+	before := time.Now().Add(-h.retention)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, file := range files {
+		id, err := strconv.ParseUint(file.Name(), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		entries, intact, err := h.readLocked(id)
+		if err != nil {
+			return fmt.Errorf("error reading character history: %w", err)
+		}
+
+		keep := slices.DeleteFunc(slices.Clone(entries), func(e historyEntry) bool { return e.Time.Before(before) })
+
+		if len(keep) == 0 {
+			err = os.Remove(filepath.Join(h.dir, file.Name()))
+		} else if !intact || len(keep) != len(entries) {
+			err = writeFileAtomic(h.dir, file.Name(), keep)
+		}
+
+		if err != nil {
+			return fmt.Errorf("error pruning character history: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// remove deletes the history log of a character.
+func (h *characterHistory) remove(id uint64) {
+	h.mu.Lock()
+	os.Remove(filepath.Join(h.dir, strconv.FormatUint(id, 10)))
+	h.mu.Unlock()
+}
+
+// readLocked reads all of the history entries for a character, also returning
+// whether the log contains only complete entries.
+//
+// The caller must hold h.mu.
+func (h *characterHistory) readLocked(id uint64) (historyEntries, bool, error) {
+	f, err := os.Open(filepath.Join(h.dir, strconv.FormatUint(id, 10)))
+	if os.IsNotExist(err) {
+		return nil, true, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+
+	defer f.Close()
+
+	var entries historyEntries
+
+	read, err := entries.ReadFrom(f)
+	if err != nil {
+		return nil, false, err
+	}
+
+	size, _ := entries.WriteTo(io.Discard)
+
+	return entries, size == read, nil
+}
+
+func (h *characterHistory) get(id uint64) (historyEntries, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	entries, _, err := h.readLocked(id)
+
+	return entries, err
+}
+
+// add appends a new entry to the history of a character, rewriting the log
+// only when older entries need to be removed, or when the previous write was
+// interrupted.
+func (h *characterHistory) add(id uint64, cd ConnData, changes []historyChange) error {
+	if len(changes) == 0 {
+		return nil
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	entries, intact, err := h.readLocked(id)
+	if err != nil {
+		return err
+	}
+
+	e := historyEntry{
+		ID:      1,
+		Time:    time.Now(),
+		Admin:   cd.IsAdmin(),
+		Player:  cd.Player,
+		Changes: changes,
+	}
+
+	if len(entries) > 0 {
+		e.ID = entries[len(entries)-1].ID + 1
+	}
+
+	keep := entries
+
+	if h.retention > 0 {
+		before := e.Time.Add(-h.retention)
+		keep = slices.DeleteFunc(slices.Clone(keep), func(e historyEntry) bool { return e.Time.Before(before) })
+	}
+
+	if h.limit > 0 && uint64(len(keep)) >= h.limit {
+		keep = keep[uint64(len(keep))-h.limit+1:]
+	}
+
+	name := strconv.FormatUint(id, 10)
+
+	if !intact || len(keep) != len(entries) {
+		return writeFileAtomic(h.dir, name, append(keep, e))
+	}
+
+	var buf memio.Buffer
+
+	historyEntries{e}.WriteTo(&buf)
+
+	f, err := os.OpenFile(filepath.Join(h.dir, name), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+
+	if _, err = f.Write(buf); err == nil {
+		err = f.Sync()
+	}
+
+	if errr := f.Close(); err == nil {
+		err = errr
+	}
+
+	return err
+}
+
+// getHistory returns the change log of a character, oldest first.
+func (c *charactersDir) getHistory(data json.RawMessage) (json.RawMessage, error) {
+	var id uint64
+
+	if err := json.Unmarshal(data, &id); err != nil {
+		return nil, err
+	}
+
+	c.mu.RLock()
+	_, ok := c.data[strconv.FormatUint(id, 10)]
+	c.mu.RUnlock()
+
+	if !ok {
+		return nil, keystore.ErrUnknownKey
+	}
+
+	entries, err := c.history.get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := json.RawMessage{'['}
+
+	for n := range entries {
+		if n > 0 {
+			buf = append(buf, ',')
+		}
+
+		buf = entries[n].appendTo(buf)
+	}
+
+	return append(buf, ']'), nil
+}
+
+// revert returns a character to the state it had before the given history
+// entry, returning the modification that was made.
+//
+// The revert is itself a modification, and so is recorded in the history and
+// broadcast as any other.
+func (c *charactersDir) revert(cd ConnData, data json.RawMessage) (json.RawMessage, error) {
+	var toRevert struct {
+		ID    uint64 `json:"id"`
+		Entry uint64 `json:"entry"`
+	}
+
+	if err := json.Unmarshal(data, &toRevert); err != nil {
+		return nil, err
+	}
+
+	entries, err := c.history.get(toRevert.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	setting, removing, err := entries.revert(toRevert.Entry)
+	if err != nil {
+		return nil, err
+	}
+
+	change, err := json.Marshal(struct {
+		ID       uint64                  `json:"id"`
+		Setting  map[string]keystoreData `json:"setting"`
+		Removing []string                `json:"removing"`
+	}{toRevert.ID, setting, removing})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.modify(cd, append(json.RawMessage{}, change...)); err != nil {
+		return nil, err
+	}
+
+	return change, nil
+}
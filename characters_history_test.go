@@ -0,0 +1,172 @@
+package battlemap
+
+import (
+	"encoding/json"
+	"reflect"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"vimagination.zapto.org/byteio"
+	"vimagination.zapto.org/memio"
+)
+
+var testHistory = historyEntries{
+	{
+		ID:     1,
+		Time:   time.UnixMilli(1000),
+		Admin:  true,
+		Player: "",
+		Changes: []historyChange{
+			{Key: "hp", Old: historyValue{}, New: historyValue{Set: true, User: true, Data: json.RawMessage("10")}},
+			{Key: "name", Old: historyValue{}, New: historyValue{Set: true, User: true, Data: json.RawMessage(`"Bob"`)}},
+		},
+	},
+	{
+		ID:     2,
+		Time:   time.UnixMilli(2000),
+		Player: "alice",
+		Changes: []historyChange{
+			{Key: "hp", Old: historyValue{Set: true, User: true, Data: json.RawMessage("10")}, New: historyValue{Set: true, User: true, Data: json.RawMessage("4")}},
+		},
+	},
+	{
+		ID:     3,
+		Time:   time.UnixMilli(3000),
+		Player: "alice",
+		Changes: []historyChange{
+			{Key: "hp", Old: historyValue{Set: true, User: true, Data: json.RawMessage("4")}},
+			{Key: "name", Old: historyValue{Set: true, User: true, Data: json.RawMessage(`"Bob"`)}},
+		},
+	},
+}
+
+func TestHistoryEntriesFormat(t *testing.T) {
+	var (
+		buf  memio.Buffer
+		read historyEntries
+	)
+
+	if _, err := testHistory.WriteTo(&buf); err != nil {
+		t.Fatalf("unexpected error writing history: %s", err)
+	}
+
+	full := len(buf)
+	buf = append(buf, 4, 0, 0)
+
+	if n, err := read.ReadFrom(&buf); err != nil {
+		t.Fatalf("unexpected error reading history: %s", err)
+	} else if len(read) != len(testHistory) {
+		t.Errorf("expecting to read %d entries, got %d", len(testHistory), len(read))
+	} else if size, _ := read.WriteTo(&memio.Buffer{}); size != int64(full) || n == size {
+		t.Errorf("expecting partial entry to be detected, read %d bytes, entries are %d bytes", n, size)
+	}
+
+	for n := range read {
+		if got, expected := string(read[n].appendTo(nil)), string(testHistory[n].appendTo(nil)); got != expected {
+			t.Errorf("entry %d: expecting %s, got %s", n+1, expected, got)
+		}
+	}
+
+	const expected = `{"id":2,"time":2000,"admin":false,"player":"alice","changes":[{"key":"hp","old":{"user":true,"data":10},"new":{"user":true,"data":4}}]}`
+
+	if str := string(testHistory[1].appendTo(nil)); str != expected {
+		t.Errorf("expecting JSON %s, got %s", expected, str)
+	}
+}
+
+func TestHistoryRevert(t *testing.T) {
+	for n, test := range [...]struct {
+		Entry    uint64
+		Setting  map[string]keystoreData
+		Removing []string
+		Err      error
+	}{
+		{1, map[string]keystoreData{}, []string{"hp", "name"}, nil},
+		{2, map[string]keystoreData{"hp": {User: true, Data: json.RawMessage("10")}, "name": {User: true, Data: json.RawMessage(`"Bob"`)}}, []string{}, nil},
+		{3, map[string]keystoreData{"hp": {User: true, Data: json.RawMessage("4")}, "name": {User: true, Data: json.RawMessage(`"Bob"`)}}, []string{}, nil},
+		{4, nil, nil, ErrUnknownHistoryEntry},
+	} {
+		setting, removing, err := testHistory.revert(test.Entry)
+		if err != test.Err {
+			t.Errorf("test %d: expecting error %v, got %v", n+1, test.Err, err)
+		} else if !reflect.DeepEqual(setting, test.Setting) {
+			t.Errorf("test %d: expecting setting %v, got %v", n+1, test.Setting, setting)
+		} else if !reflect.DeepEqual(removing, test.Removing) {
+			t.Errorf("test %d: expecting removing %v, got %v", n+1, test.Removing, removing)
+		}
+	}
+}
+
+func TestHistoryPrune(t *testing.T) {
+	now := time.Now()
+	h := characterHistory{dir: t.TempDir(), retention: time.Hour}
+
+	for id, times := range map[uint64][]time.Duration{
+		1: {-3 * time.Hour, -2 * time.Hour},
+		2: {-2 * time.Hour, -time.Minute},
+		3: {-time.Minute},
+	} {
+		var entries historyEntries
+
+		for n, d := range times {
+			entries = append(entries, historyEntry{ID: uint64(n + 1), Time: now.Add(d)})
+		}
+
+		if err := writeFileAtomic(h.dir, strconv.FormatUint(id, 10), entries); err != nil {
+			t.Fatalf("unexpected error writing history: %s", err)
+		}
+	}
+
+	if err := h.pruneExpired(); err != nil {
+		t.Fatalf("unexpected error pruning history: %s", err)
+	}
+
+	h.remove(3)
+
+	for n, test := range [...]struct {
+		ID  uint64
+		IDs []uint64
+	}{
+		{1, nil},
+		{2, []uint64{2}},
+		{3, nil},
+	} {
+		entries, err := h.get(test.ID)
+		if err != nil {
+			t.Errorf("test %d: unexpected error reading history: %s", n+1, err)
+
+			continue
+		}
+
+		var ids []uint64
+
+		for _, e := range entries {
+			ids = append(ids, e.ID)
+		}
+
+		if !slices.Equal(ids, test.IDs) {
+			t.Errorf("test %d: expecting entries %v, got %v", n+1, test.IDs, ids)
+		}
+	}
+}
+
+func TestHistoryEntriesCorrupt(t *testing.T) {
+	var (
+		buf  memio.Buffer
+		read historyEntries
+	)
+
+	lw := byteio.StickyLittleEndianWriter{Writer: &buf}
+
+	lw.WriteUintX(1)
+	lw.WriteInt64(1000)
+	lw.WriteBool(true)
+	lw.WriteStringX("")
+	lw.WriteUintX(1 << 40)
+
+	if _, err := read.ReadFrom(&buf); err != ErrInvalidData {
+		t.Errorf("expecting error %v, got %v", ErrInvalidData, err)
+	}
+}
@@ -0,0 +1,236 @@
+package battlemap
+
+import (
+	"cmp"
+	"encoding/json"
+	"io"
+	"slices"
+	"strconv"
+
+	"vimagination.zapto.org/byteio"
+)
+
+// characterOwner lists the players that can modify a character, and the keys,
+// in addition to those that are user-visible, that they can change.
+type characterOwner struct {
+	Players []string `json:"players"`
+	Keys    []string `json:"keys"`
+}
+
+func (c characterOwner) ownedBy(cd ConnData) bool {
+	return cd.Player != "" && slices.Contains(c.Players, cd.Player)
+}
+
+type characterOwners map[uint64]characterOwner
+
+func (c characterOwners) ReadFrom(r io.Reader) (int64, error) {
+	lr := byteio.StickyLittleEndianReader{Reader: r}
+
+	for l := lr.ReadUintX(); l > 0 && lr.Err == nil; l-- {
+		id := lr.ReadUintX()
+		o := characterOwner{
+			Players: make([]string, lr.ReadUintX()),
+		}
+
+		for n := range o.Players {
+			o.Players[n] = lr.ReadStringX()
+		}
+
+		o.Keys = make([]string, lr.ReadUintX())
+
+		for n := range o.Keys {
+			o.Keys[n] = lr.ReadStringX()
+		}
+
+		c[id] = o
+	}
+
+	return lr.Count, lr.Err
+}
+
+func (c characterOwners) WriteTo(w io.Writer) (int64, error) {
+	lw := byteio.StickyLittleEndianWriter{Writer: w}
+
+	lw.WriteUintX(uint64(len(c)))
+
+	for id, o := range c {
+		lw.WriteUintX(id)
+		lw.WriteUintX(uint64(len(o.Players)))
+
+		for _, p := range o.Players {
+			lw.WriteStringX(p)
+		}
+
+		lw.WriteUintX(uint64(len(o.Keys)))
+
+		for _, k := range o.Keys {
+			lw.WriteStringX(k)
+		}
+	}
+
+	return lw.Count, lw.Err
+}
+
+type idOwner struct {
+	ID uint64 `json:"id"`
+	characterOwner
+}
+
+type idKeys struct {
+	ID   uint64   `json:"id"`
+	Keys []string `json:"keys"`
+}
+
+// listOwners returns the owners of all characters that have them.
+func (c *charactersDir) listOwners() (json.RawMessage, error) {
+	owners := []idOwner{}
+
+	c.mu.RLock()
+
+	for id, o := range c.owners {
+		owners = append(owners, idOwner{id, o})
+	}
+
+	c.mu.RUnlock()
+
+	slices.SortFunc(owners, func(a, b idOwner) int { return cmp.Compare(a.ID, b.ID) })
+
+	return json.Marshal(owners)
+}
+
+// owned returns the IDs of the characters owned by the player, along with the
+// extra keys that they can change.
+func (c *charactersDir) owned(cd ConnData) (json.RawMessage, error) {
+	owned := []idKeys{}
+
+	c.mu.RLock()
+
+	for id, o := range c.owners {
+		if o.ownedBy(cd) {
+			owned = append(owned, idKeys{id, o.Keys})
+		}
+	}
+
+	c.mu.RUnlock()
+
+	slices.SortFunc(owned, func(a, b idKeys) int { return cmp.Compare(a.ID, b.ID) })
+
+	return json.Marshal(owned)
+}
+
+// setOwners sets the players that own a character, and the keys that they can
+// change; a character with no players has no owners.
+//
+// Owners can only be set when the Auth module implements PlayerAuth, as
+// otherwise no player could be identified as an owner.
+func (c *charactersDir) setOwners(cd ConnData, data json.RawMessage) error {
+	var toSet idOwner
+
+	if err := json.Unmarshal(data, &toSet); err != nil {
+		return err
+	}
+
+	for _, list := range [...]*[]string{&toSet.Players, &toSet.Keys} {
+		*list = slices.DeleteFunc(*list, func(s string) bool { return s == "" })
+
+		slices.Sort(*list)
+
+		*list = slices.Compact(*list)
+
+		if *list == nil {
+			*list = []string{}
+		}
+	}
+
+	if len(toSet.Players) > 0 && !c.identifiesPlayers() {
+		return ErrPlayersUnsupported
+	}
+
+	c.mu.Lock()
+
+	if _, ok := c.data[strconv.FormatUint(toSet.ID, 10)]; !ok {
+		c.mu.Unlock()
+
+		return ErrItemNotFound
+	}
+
+	old := c.owners[toSet.ID]
+
+	if len(toSet.Players) == 0 {
+		delete(c.owners, toSet.ID)
+	} else {
+		c.owners[toSet.ID] = toSet.characterOwner
+	}
+
+	err := c.fileStore.Set(characterOwnersKey, c.owners)
+
+	c.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	buf, _ := json.Marshal(toSet)
+
+	c.socket.broadcastAdminChange(broadcastCharacterOwners, buf, cd.ID)
+
+	owned, _ := json.Marshal(idKeys{toSet.ID, toSet.Keys})
+	unowned := strconv.AppendUint(nil, toSet.ID, 10)
+	curr := toSet.characterOwner
+
+	c.socket.broadcastPlayerChange(func(pcd ConnData) (int, json.RawMessage) {
+		if was, is := old.ownedBy(pcd), curr.ownedBy(pcd); is {
+			return broadcastCharacterOwned, owned
+		} else if was {
+			return broadcastCharacterUnowned, unowned
+		}
+
+		return 0, nil
+	})
+
+	return nil
+}
+
+// checkPlayerModify ensures that the player owns the character, and that each
+// key being set or removed is either user-visible or is one that the player
+// has been allowed to change. Keys that link to other stores can only be set
+// when the player has been allowed to change them, so that players cannot
+// gain access to assets or characters that they could not otherwise see.
+//
+// Players cannot change the visibility of a key, so the user flag of each key
+// being set is replaced with that of the existing key, with new keys being
+// user-visible.
+//
+// The caller must hold c.mu.
+func (c *charactersDir) checkPlayerModify(cd ConnData, id string, ms characterData, setting map[string]keystoreData, removing []string) error {
+	cid, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return ErrCharacterNotOwned
+	}
+
+	o, ok := c.owners[cid]
+	if !ok || !o.ownedBy(cd) {
+		return ErrCharacterNotOwned
+	}
+
+	for key, val := range setting {
+		existing, exists := ms[key]
+
+		if (!existing.User || getLinkType(key) != linkNone) && !slices.Contains(o.Keys, key) {
+			return ErrKeyNotEditable
+		}
+
+		val.User = existing.User || !exists
+		setting[key] = val
+	}
+
+	for _, key := range removing {
+		if existing, exists := ms[key]; exists && !existing.User && !slices.Contains(o.Keys, key) {
+			return ErrKeyNotEditable
+		}
+	}
+
+	return nil
+}
+
+const characterOwnersKey = "owners"
@@ -0,0 +1,76 @@
+package battlemap
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestCheckPlayerModify(t *testing.T) {
+	c := charactersDir{
+		owners: characterOwners{
+			1: {Players: []string{"alice"}, Keys: []string{"notes", "store-image-portrait"}},
+		},
+	}
+	ms := characterData{
+		"hp":                {User: true, Data: json.RawMessage("10")},
+		"store-image-token": {User: true, Data: json.RawMessage("1")},
+		"secret":            {User: false, Data: json.RawMessage("1")},
+		"notes":             {User: false, Data: json.RawMessage(`""`)},
+	}
+
+	for n, test := range [...]struct {
+		Player   string
+		ID       string
+		Setting  map[string]keystoreData
+		Removing []string
+		Err      error
+		User     map[string]bool
+	}{
+		{"alice", "1", map[string]keystoreData{"hp": {Data: json.RawMessage("5")}}, nil, nil, map[string]bool{"hp": true}},
+		{"alice", "1", map[string]keystoreData{"notes": {User: true, Data: json.RawMessage(`"a"`)}}, nil, nil, map[string]bool{"notes": false}},
+		{"alice", "1", nil, []string{"hp", "notes", "missing"}, nil, nil},
+		{"alice", "1", map[string]keystoreData{"secret": {Data: json.RawMessage("2")}}, nil, ErrKeyNotEditable, nil},
+		{"alice", "1", map[string]keystoreData{"new": {Data: json.RawMessage("2")}}, nil, ErrKeyNotEditable, nil},
+		{"alice", "1", nil, []string{"secret"}, ErrKeyNotEditable, nil},
+		{"alice", "1", map[string]keystoreData{"store-image-token": {Data: json.RawMessage("2")}}, nil, ErrKeyNotEditable, nil},
+		{"alice", "1", map[string]keystoreData{"store-image-new": {Data: json.RawMessage("2")}}, nil, ErrKeyNotEditable, nil},
+		{"alice", "1", map[string]keystoreData{"store-character-ally": {Data: json.RawMessage("2")}}, nil, ErrKeyNotEditable, nil},
+		{"alice", "1", map[string]keystoreData{"store-image-portrait": {Data: json.RawMessage("2")}}, nil, nil, map[string]bool{"store-image-portrait": true}},
+		{"alice", "1", nil, []string{"store-image-token"}, nil, nil},
+		{"bob", "1", map[string]keystoreData{"hp": {Data: json.RawMessage("5")}}, nil, ErrCharacterNotOwned, nil},
+		{"", "1", map[string]keystoreData{"hp": {Data: json.RawMessage("5")}}, nil, ErrCharacterNotOwned, nil},
+		{"alice", "2", map[string]keystoreData{"hp": {Data: json.RawMessage("5")}}, nil, ErrCharacterNotOwned, nil},
+	} {
+		if err := c.checkPlayerModify(ConnData{Player: test.Player}, test.ID, ms, test.Setting, test.Removing); err != test.Err {
+			t.Errorf("test %d: expecting error %v, got %v", n+1, test.Err, err)
+		} else {
+			for key, user := range test.User {
+				if test.Setting[key].User != user {
+					t.Errorf("test %d: expecting user flag of key %q to be %v", n+1, key, user)
+				}
+			}
+		}
+	}
+}
+
+func TestSetOwnersAuth(t *testing.T) {
+	for n, test := range [...]struct {
+		Auth Auth
+		Data string
+		Err  error
+	}{
+		{testAuth{}, `{"id":1,"players":[],"keys":[]}`, ErrItemNotFound},
+		{testAuth{}, `{"id":1,"players":["alice"],"keys":[]}`, ErrPlayersUnsupported},
+		{testPlayerAuth{}, `{"id":1,"players":["alice"],"keys":[]}`, ErrItemNotFound},
+	} {
+		c := charactersDir{
+			folders: folders{Battlemap: &Battlemap{auth: test.Auth}},
+			data:    make(map[string]characterData),
+			owners:  make(characterOwners),
+		}
+
+		if err := c.setOwners(ConnData{}, json.RawMessage(test.Data)); err != test.Err {
+			t.Errorf("test %d: expecting error %v, got %v", n+1, test.Err, err)
+		}
+	}
+}
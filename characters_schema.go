@@ -0,0 +1,453 @@
+package battlemap
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"unicode/utf8"
+
+	"vimagination.zapto.org/byteio"
+)
+
+// schemaTypes is the 'type' keyword of a schema, which can be either a single
+// type name or a list of them.
+type schemaTypes []string
+
+func (s *schemaTypes) UnmarshalJSON(data []byte) error {
+	var t string
+
+	if err := json.Unmarshal(data, &t); err == nil {
+		*s = schemaTypes{t}
+
+		return nil
+	}
+
+	return json.Unmarshal(data, (*[]string)(s))
+}
+
+// schema is a subset of JSON Schema, used to validate the data stored under a
+// character key.
+//
+// Annotation keywords are accepted and ignored, but any other keyword that is
+// not supported causes the schema to be rejected, so that a schema is never
+// silently less strict than intended.
+type schema struct {
+	Schema               string             `json:"$schema"`
+	Title                string             `json:"title"`
+	Description          string             `json:"description"`
+	Default              json.RawMessage    `json:"default"`
+	Type                 schemaTypes        `json:"type"`
+	Enum                 []json.RawMessage  `json:"enum"`
+	Minimum              *float64           `json:"minimum"`
+	Maximum              *float64           `json:"maximum"`
+	MinLength            *uint64            `json:"minLength"`
+	MaxLength            *uint64            `json:"maxLength"`
+	Pattern              *string            `json:"pattern"`
+	Items                *schema            `json:"items"`
+	MinItems             *uint64            `json:"minItems"`
+	MaxItems             *uint64            `json:"maxItems"`
+	Properties           map[string]*schema `json:"properties"`
+	Required             []string           `json:"required"`
+	AdditionalProperties *bool              `json:"additionalProperties"`
+
+	raw     json.RawMessage
+	enum    []any
+	pattern *regexp.Regexp
+}
+
+// parseSchema parses and checks a schema.
+func parseSchema(data json.RawMessage) (*schema, error) {
+	s := new(schema)
+	dec := json.NewDecoder(bytes.NewReader(data))
+
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(s); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidSchema, err)
+	} else if dec.More() {
+		return nil, ErrInvalidSchema
+	}
+
+	if err := s.compile(""); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+
+	if err := json.Compact(&buf, data); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidSchema, err)
+	}
+
+	s.raw = buf.Bytes()
+
+	return s, nil
+}
+
+func (s *schema) compile(path string) error {
+	if s == nil {
+		return fmt.Errorf("%w: %s: null schema", ErrInvalidSchema, "schema"+path)
+	}
+
+	for _, t := range s.Type {
+		switch t {
+		case "null", "boolean", "number", "integer", "string", "array", "object":
+		default:
+			return fmt.Errorf("%w: %s: unknown type %q", ErrInvalidSchema, "schema"+path, t)
+		}
+	}
+
+	for _, e := range s.Enum {
+		var v any
+
+		if err := json.Unmarshal(e, &v); err != nil {
+			return fmt.Errorf("%w: %s: invalid enum value: %s", ErrInvalidSchema, "schema"+path, err)
+		}
+
+		s.enum = append(s.enum, v)
+	}
+
+	if s.Minimum != nil && s.Maximum != nil && *s.Minimum > *s.Maximum {
+		return fmt.Errorf("%w: %s: minimum is greater than maximum", ErrInvalidSchema, "schema"+path)
+	} else if s.MinLength != nil && s.MaxLength != nil && *s.MinLength > *s.MaxLength {
+		return fmt.Errorf("%w: %s: minLength is greater than maxLength", ErrInvalidSchema, "schema"+path)
+	} else if s.MinItems != nil && s.MaxItems != nil && *s.MinItems > *s.MaxItems {
+		return fmt.Errorf("%w: %s: minItems is greater than maxItems", ErrInvalidSchema, "schema"+path)
+	}
+
+	if s.Pattern != nil {
+		p, err := regexp.Compile(*s.Pattern)
+		if err != nil {
+			return fmt.Errorf("%w: %s: invalid pattern: %s", ErrInvalidSchema, "schema"+path, err)
+		}
+
+		s.pattern = p
+	}
+
+	if s.Items != nil {
+		if err := s.Items.compile(path + "[]"); err != nil {
+			return err
+		}
+	}
+
+	for name, p := range s.Properties {
+		if err := p.compile(path + "." + name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validate checks the given JSON data against the schema, returning an error
+// describing the first problem found.
+func (s *schema) validate(data json.RawMessage) error {
+	var v any
+
+	if err := json.Unmarshal(data, &v); err != nil {
+		return fmt.Errorf("%w: invalid JSON: %s", ErrInvalidCharacterData, err)
+	}
+
+	if reason := s.check(v, ""); reason != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidCharacterData, reason)
+	}
+
+	return nil
+}
+
+type schemaError struct {
+	path, reason string
+}
+
+func (s *schemaError) Error() string {
+	return "value" + s.path + ": " + s.reason
+}
+
+func (s *schema) check(v any, path string) *schemaError {
+	if len(s.Type) > 0 && !slices.ContainsFunc(s.Type, func(t string) bool { return isSchemaType(v, t) }) {
+		return &schemaError{path, "expecting " + joinTypes(s.Type) + ", got " + typeOf(v)}
+	}
+
+	if len(s.enum) > 0 && !slices.ContainsFunc(s.enum, func(e any) bool { return reflect.DeepEqual(e, v) }) {
+		return &schemaError{path, "value not in enum"}
+	}
+
+	switch v := v.(type) {
+	case float64:
+		if s.Minimum != nil && v < *s.Minimum {
+			return &schemaError{path, "must be at least " + strconv.FormatFloat(*s.Minimum, 'f', -1, 64)}
+		} else if s.Maximum != nil && v > *s.Maximum {
+			return &schemaError{path, "must be at most " + strconv.FormatFloat(*s.Maximum, 'f', -1, 64)}
+		}
+	case string:
+		l := uint64(utf8.RuneCountInString(v))
+
+		if s.MinLength != nil && l < *s.MinLength {
+			return &schemaError{path, "must be at least " + strconv.FormatUint(*s.MinLength, 10) + " characters"}
+		} else if s.MaxLength != nil && l > *s.MaxLength {
+			return &schemaError{path, "must be at most " + strconv.FormatUint(*s.MaxLength, 10) + " characters"}
+		} else if s.pattern != nil && !s.pattern.MatchString(v) {
+			return &schemaError{path, "does not match pattern " + strconv.Quote(*s.Pattern)}
+		}
+	case []any:
+		l := uint64(len(v))
+
+		if s.MinItems != nil && l < *s.MinItems {
+			return &schemaError{path, "must have at least " + strconv.FormatUint(*s.MinItems, 10) + " items"}
+		} else if s.MaxItems != nil && l > *s.MaxItems {
+			return &schemaError{path, "must have at most " + strconv.FormatUint(*s.MaxItems, 10) + " items"}
+		}
+
+		if s.Items != nil {
+			for n, item := range v {
+				if err := s.Items.check(item, path+"["+strconv.Itoa(n)+"]"); err != nil {
+					return err
+				}
+			}
+		}
+	case map[string]any:
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				return &schemaError{path, "missing required property " + strconv.Quote(name)}
+			}
+		}
+
+		keys := make([]string, 0, len(v))
+
+		for key := range v {
+			keys = append(keys, key)
+		}
+
+		slices.Sort(keys)
+
+		for _, key := range keys {
+			if p, ok := s.Properties[key]; ok {
+				if err := p.check(v[key], path+"."+key); err != nil {
+					return err
+				}
+			} else if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+				return &schemaError{path, "unexpected property " + strconv.Quote(key)}
+			}
+		}
+	}
+
+	return nil
+}
+
+func isSchemaType(v any, t string) bool {
+	switch v := v.(type) {
+	case nil:
+		return t == "null"
+	case bool:
+		return t == "boolean"
+	case float64:
+		return t == "number" || t == "integer" && v == math.Trunc(v)
+	case string:
+		return t == "string"
+	case []any:
+		return t == "array"
+	case map[string]any:
+		return t == "object"
+	}
+
+	return false
+}
+
+func typeOf(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	}
+
+	return "object"
+}
+
+func joinTypes(types []string) string {
+	var str string
+
+	for n, t := range types {
+		if n > 0 {
+			if n == len(types)-1 {
+				str += " or "
+			} else {
+				str += ", "
+			}
+		}
+
+		str += t
+	}
+
+	return str
+}
+
+// characterSchemas maps character keys to the schema that their data must
+// match.
+type characterSchemas map[string]*schema
+
+func (c characterSchemas) ReadFrom(r io.Reader) (int64, error) {
+	lr := byteio.StickyLittleEndianReader{Reader: r}
+
+	for l := lr.ReadUintX(); l > 0 && lr.Err == nil; l-- {
+		key := lr.ReadStringX()
+		data := lr.ReadBytesX()
+
+		if lr.Err != nil {
+			break
+		}
+
+		s, err := parseSchema(data)
+		if err != nil {
+			return lr.Count, fmt.Errorf("error parsing schema for key %q: %w", key, err)
+		}
+
+		c[key] = s
+	}
+
+	return lr.Count, lr.Err
+}
+
+func (c characterSchemas) WriteTo(w io.Writer) (int64, error) {
+	lw := byteio.StickyLittleEndianWriter{Writer: w}
+
+	lw.WriteUintX(uint64(len(c)))
+
+	for key, s := range c {
+		lw.WriteStringX(key)
+		lw.WriteBytesX(s.raw)
+	}
+
+	return lw.Count, lw.Err
+}
+
+// validate checks the data being set for any key that has a schema.
+func (c characterSchemas) validate(data map[string]keystoreData) error {
+	keys := make([]string, 0, len(data))
+
+	for key := range data {
+		if _, ok := c[key]; ok {
+			keys = append(keys, key)
+		}
+	}
+
+	slices.Sort(keys)
+
+	for _, key := range keys {
+		if err := c[key].validate(data[key].Data); err != nil {
+			return fmt.Errorf("key %q: %w", key, err)
+		}
+	}
+
+	return nil
+}
+
+func (c characterSchemas) appendTo(buf json.RawMessage) json.RawMessage {
+	keys := make([]string, 0, len(c))
+
+	for key := range c {
+		keys = append(keys, key)
+	}
+
+	slices.Sort(keys)
+
+	buf = append(buf, '{')
+
+	for n, key := range keys {
+		if n > 0 {
+			buf = append(buf, ',')
+		}
+
+		buf = append(append(appendString(buf, key), ':'), c[key].raw...)
+	}
+
+	return append(buf, '}')
+}
+
+// getSchemas returns all of the registered schemas.
+func (c *charactersDir) getSchemas() json.RawMessage {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.schemas.appendTo(nil)
+}
+
+// setSchema registers a schema for a character key, replacing any existing
+// schema for that key.
+//
+// Existing character data is not checked against the new schema; it will be
+// checked when it is next changed.
+func (c *charactersDir) setSchema(cd ConnData, data json.RawMessage) error {
+	var keySchema struct {
+		Key    string          `json:"key"`
+		Schema json.RawMessage `json:"schema"`
+	}
+
+	if err := json.Unmarshal(data, &keySchema); err != nil {
+		return err
+	}
+
+	s, err := parseSchema(keySchema.Schema)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.schemas[keySchema.Key] = s
+	err = c.fileStore.Set(characterSchemasKey, c.schemas)
+	c.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	buf := append(appendString(append(json.RawMessage{}, "{\"key\":"...), keySchema.Key), ",\"schema\":"...)
+
+	c.socket.broadcastAdminChange(broadcastCharacterSchemaSet, append(append(buf, s.raw...), '}'), cd.ID)
+
+	return nil
+}
+
+// removeSchema removes the schema for a character key.
+func (c *charactersDir) removeSchema(cd ConnData, data json.RawMessage) error {
+	var key string
+
+	if err := json.Unmarshal(data, &key); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+
+	if _, ok := c.schemas[key]; !ok {
+		c.mu.Unlock()
+
+		return nil
+	}
+
+	delete(c.schemas, key)
+
+	err := c.fileStore.Set(characterSchemasKey, c.schemas)
+
+	c.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	c.socket.broadcastAdminChange(broadcastCharacterSchemaRemove, appendString(nil, key), cd.ID)
+
+	return nil
+}
+
+const characterSchemasKey = "schemas"
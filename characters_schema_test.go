@@ -0,0 +1,50 @@
+package battlemap
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSchemaValidate(t *testing.T) {
+	const stats = `{"type":"object","required":["str"],"additionalProperties":false,"properties":{"str":{"type":"integer","minimum":1,"maximum":30},"name":{"type":"string","minLength":1,"pattern":"^[A-Z]"},"class":{"enum":["fighter","wizard"]},"items":{"type":"array","maxItems":2,"items":{"type":["string","null"]}}}}`
+
+	for n, test := range [...]struct {
+		Schema, Data string
+		Err          string
+	}{
+		{stats, `{"str":10}`, ""},
+		{stats, `{"str":10,"name":"Bob","class":"wizard","items":["sword",null]}`, ""},
+		{stats, `{}`, `invalid character data: value: missing required property "str"`},
+		{stats, `{"str":10.5}`, "invalid character data: value.str: expecting integer, got number"},
+		{stats, `{"str":"10"}`, "invalid character data: value.str: expecting integer, got string"},
+		{stats, `{"str":31}`, "invalid character data: value.str: must be at most 30"},
+		{stats, `{"str":1,"dex":1}`, `invalid character data: value: unexpected property "dex"`},
+		{stats, `{"str":1,"name":"bob"}`, `invalid character data: value.name: does not match pattern "^[A-Z]"`},
+		{stats, `{"str":1,"class":"rogue"}`, "invalid character data: value.class: value not in enum"},
+		{stats, `{"str":1,"items":["a",1]}`, "invalid character data: value.items[1]: expecting string or null, got number"},
+		{stats, `{"str":1,"items":["a","b","c"]}`, "invalid character data: value.items: must have at most 2 items"},
+		{stats, `[]`, "invalid character data: value: expecting object, got array"},
+		{`{}`, `"anything"`, ""},
+		{`{"type":"strin"}`, ``, `invalid schema: schema: unknown type "strin"`},
+		{`{"properties":{"a":{"oneOf":[]}}}`, ``, `invalid schema: json: unknown field "oneOf"`},
+		{`{"properties":{"a":{"minimum":2,"maximum":1}}}`, ``, "invalid schema: schema.a: minimum is greater than maximum"},
+		{`{"pattern":"("}`, ``, "invalid schema: schema: invalid pattern: error parsing regexp: missing closing ): `(`"},
+	} {
+		s, err := parseSchema([]byte(test.Schema))
+		if err == nil {
+			err = s.validate([]byte(test.Data))
+		}
+
+		if test.Err == "" {
+			if err != nil {
+				t.Errorf("test %d: unexpected error: %s", n+1, err)
+			}
+		} else if err == nil {
+			t.Errorf("test %d: expecting error %q, got nil", n+1, test.Err)
+		} else if err.Error() != test.Err {
+			t.Errorf("test %d: expecting error %q, got %q", n+1, test.Err, err)
+		} else if !errors.Is(err, ErrInvalidSchema) && !errors.Is(err, ErrInvalidCharacterData) {
+			t.Errorf("test %d: expecting schema or character data error, got %v", n+1, err)
+		}
+	}
+}
@@ -0,0 +1,417 @@
+package battlemap
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"vimagination.zapto.org/keystore"
+)
+
+// CheckMode determines what Check does with the problems it finds.
+type CheckMode uint8
+
+// Check modes.
+const (
+	// CheckReport only reports problems.
+	CheckReport CheckMode = iota
+	// CheckDryRun reports problems, along with the repairs that would be made.
+	CheckDryRun
+	// CheckRepair reports problems and repairs those that can be repaired.
+	CheckRepair
+)
+
+// Problem describes an issue found by Check.
+type Problem struct {
+	Store       string
+	Key         string
+	Description string
+
+	// Repair describes the action taken, or that would be taken, to repair
+	// the problem. It is empty for problems that cannot be automatically
+	// repaired, or when Check was run in CheckReport mode.
+	Repair string
+
+	// Repaired is set if the repair was made, and RepairErr is set if the
+	// repair was attempted and failed.
+	Repaired  bool
+	RepairErr error
+}
+
+func (p Problem) String() string {
+	return p.Store + "/" + p.Key + ": " + p.Description
+}
+
+type checkStore struct {
+	name     string
+	store    *keystore.FileStore
+	dir      string
+	folders  *folders
+	listed   linkManager
+	existing linkManager
+	bin      *trash
+}
+
+// trash returns the trash area for the store, initialising it on first use.
+func (cs *checkStore) trash(c *config) (*trash, error) {
+	if cs.bin == nil {
+		t := new(trash)
+
+		if err := t.Init(c, cs.name, cs.dir); err != nil {
+			return nil, err
+		}
+
+		cs.bin = t
+	}
+
+	return cs.bin, nil
+}
+
+type checker struct {
+	config   config
+	mode     CheckMode
+	problems []Problem
+	stores   map[linkType]*checkStore
+	maps     *checkStore
+	music    *checkStore
+	refs     map[reference][]string
+}
+
+// Check walks every store in the battlemap data directory at the given path,
+// reporting dangling references, orphaned files, duplicate token IDs, and
+// unparsable maps, characters, music packs, and plugin settings.
+//
+// Unlike starting a Battlemap, which silently discards missing folder items
+// and unreferenced assets, Check only modifies the data directory when run in
+// CheckRepair mode.
+func Check(path string, mode CheckMode) ([]Problem, error) {
+	c := checker{
+		mode:   mode,
+		stores: make(map[linkType]*checkStore),
+		refs:   make(map[reference][]string),
+	}
+
+	if err := c.config.Init(path); err != nil {
+		return nil, fmt.Errorf("error loading Config: %w", err)
+	}
+
+	for _, s := range [...]struct {
+		Name, Config string
+		Type         linkType
+		Folders      bool
+	}{
+		{"images", "ImageAssetsDir", linkImage, true},
+		{"audio", "AudioAssetsDir", linkAudio, true},
+		{"characters", "CharsDir", linkChar, true},
+		{"maps", "MapsDir", linkNone, true},
+		{"music", "MusicPacksDir", linkMusic, false},
+	} {
+		cs, err := c.openStore(s.Name, s.Config, s.Folders)
+		if err != nil {
+			return nil, err
+		}
+
+		switch s.Type {
+		case linkNone:
+			c.maps = cs
+		case linkMusic:
+			c.music = cs
+		default:
+			c.stores[s.Type] = cs
+		}
+	}
+
+	c.stores[linkMusic] = c.music
+
+	c.checkMaps()
+	c.checkCharacters()
+	c.checkMusicPacks()
+
+	if err := c.checkPlugins(); err != nil {
+		return nil, err
+	}
+
+	c.checkDangling()
+	c.checkOrphans()
+
+	return c.problems, nil
+}
+
+func (c *checker) report(store, key, description, repair string, fn func() error) {
+	p := Problem{
+		Store:       store,
+		Key:         key,
+		Description: description,
+	}
+
+	if fn != nil {
+		switch c.mode {
+		case CheckDryRun:
+			p.Repair = repair
+		case CheckRepair:
+			p.Repair = repair
+			p.RepairErr = fn()
+			p.Repaired = p.RepairErr == nil
+		}
+	}
+
+	c.problems = append(c.problems, p)
+}
+
+func (c *checker) openStore(name, configKey string, withFolders bool) (*checkStore, error) {
+	var location keystore.String
+
+	if err := c.config.Get(configKey, &location); err != nil {
+		return nil, fmt.Errorf("error getting %s directory: %w", name, err)
+	}
+
+	dir := filepath.Join(c.config.BaseDir, string(location))
+
+	store, err := keystore.NewFileStore(dir, dir, keystore.NoMangle)
+	if err != nil {
+		return nil, fmt.Errorf("error opening %s store: %w", name, err)
+	}
+
+	cs := &checkStore{
+		name:     name,
+		store:    store,
+		dir:      dir,
+		listed:   make(linkManager),
+		existing: make(linkManager),
+	}
+
+	for _, key := range store.Keys() {
+		if id, err := strconv.ParseUint(key, 10, 64); err == nil {
+			cs.existing.setLink(id)
+		}
+	}
+
+	if withFolders {
+		cs.folders = &folders{
+			FileStore: store,
+			root:      newFolder(),
+		}
+
+		if store.Exists(folderMetadata) {
+			if err := store.Get(folderMetadata, cs.folders); err != nil {
+				c.report(name, folderMetadata, "unparsable folder metadata: "+err.Error(), "", nil)
+
+				cs.folders.root = newFolder()
+			}
+		}
+
+		c.checkFolder(cs, cs.folders.root, "/")
+	}
+
+	return cs, nil
+}
+
+func (c *checker) checkFolder(cs *checkStore, f *folder, path string) {
+	for name, id := range f.Items {
+		if _, ok := cs.existing[id]; ok {
+			cs.listed.setLink(id)
+
+			continue
+		}
+
+		c.report(cs.name, path+name, fmt.Sprintf("folder item references missing file %d", id), "remove item from folder", func() error {
+			delete(f.Items, name)
+
+			return cs.store.Set(folderMetadata, cs.folders)
+		})
+	}
+
+	for name, g := range f.Folders {
+		c.checkFolder(cs, g, path+name+"/")
+	}
+}
+
+func (c *checker) addReferences(source string, refs []reference) {
+	for _, ref := range refs {
+		c.refs[ref] = append(c.refs[ref], source)
+	}
+}
+
+func (c *checker) checkMaps() {
+	for id := range c.maps.existing {
+		key := strconv.FormatUint(id, 10)
+		mp := new(levelMap)
+
+		err := c.maps.store.Get(key, mp)
+		if err == ErrDuplicateTokenID {
+			mp = &levelMap{renumber: true}
+
+			if err = c.maps.store.Get(key, mp); err == nil {
+				c.report(c.maps.name, key, "map contains duplicate token IDs", "renumber duplicate tokens", func() error {
+					return writeFileAtomic(c.maps.dir, key, mp)
+				})
+			}
+		}
+
+		if err != nil {
+			c.report(c.maps.name, key, "unparsable map: "+err.Error(), "", nil)
+
+			continue
+		}
+
+		if _, ok := c.maps.listed[id]; ok {
+			c.addReferences("map "+key, mp.references())
+		} else {
+			c.report(c.maps.name, key, "map not in any folder", "", nil)
+		}
+	}
+}
+
+func (c *checker) checkCharacters() {
+	chars := c.stores[linkChar]
+	data := make(map[uint64]characterData)
+
+	for id := range chars.existing {
+		key := strconv.FormatUint(id, 10)
+		cd := make(characterData)
+
+		if err := chars.store.Get(key, cd); err != nil {
+			c.report(chars.name, key, "unparsable character: "+err.Error(), "", nil)
+			chars.listed.setLink(id)
+
+			continue
+		}
+
+		data[id] = cd
+	}
+
+	for id, cd := range data {
+		if _, listed := chars.listed[id]; !listed && c.refs[reference{linkChar, id}] == nil {
+			continue
+		}
+
+		lm := newLinks()
+
+		for key, val := range cd {
+			if f := lm.getLinkKey(key); f != nil {
+				f.setJSONLinks(val.Data)
+			}
+		}
+
+		c.addReferences("character "+strconv.FormatUint(id, 10), lm.references())
+	}
+}
+
+func (c *checker) checkMusicPacks() {
+	for id := range c.music.existing {
+		key := strconv.FormatUint(id, 10)
+		pack := new(musicPack)
+
+		if err := c.music.store.Get(key, pack); err != nil {
+			c.report(c.music.name, key, "unparsable music pack: "+err.Error(), "", nil)
+			c.music.listed.setLink(id)
+
+			continue
+		}
+
+		if pack.Name == "" && c.refs[reference{linkMusic, id}] == nil {
+			continue
+		}
+
+		c.music.listed.setLink(id)
+
+		for _, track := range pack.Tracks {
+			c.addReferences("music pack "+key, []reference{{linkAudio, track.ID}})
+		}
+	}
+}
+
+func (c *checker) checkPlugins() error {
+	var location keystore.String
+
+	if err := c.config.Get("PluginsDir", &location); err != nil {
+		return fmt.Errorf("error getting plugins directory: %w", err)
+	}
+
+	dir := filepath.Join(c.config.BaseDir, string(location))
+
+	store, err := keystore.NewFileStore(dir, dir, keystore.NoMangle)
+	if err != nil {
+		return fmt.Errorf("error opening plugins store: %w", err)
+	}
+
+	for _, key := range store.Keys() {
+		if !strings.HasSuffix(key, ".js"+pluginConfigExt) {
+			continue
+		}
+
+		var p plugin
+
+		if err := store.Get(key, &p); err != nil {
+			c.report("plugins", key, "unparsable plugin settings: "+err.Error(), "", nil)
+
+			continue
+		}
+
+		lm := newLinks()
+
+		for key, val := range p.Data {
+			if f := lm.getLinkKey(key); f != nil {
+				f.setJSONLinks(val.Data)
+			}
+		}
+
+		c.addReferences("plugin "+strings.TrimSuffix(key, pluginConfigExt), lm.references())
+	}
+
+	return nil
+}
+
+func (c *checker) checkDangling() {
+	refs := make([]reference, 0, len(c.refs))
+
+	for ref := range c.refs {
+		refs = append(refs, ref)
+	}
+
+	sort.Slice(refs, func(i, j int) bool {
+		if refs[i].Type == refs[j].Type {
+			return refs[i].ID < refs[j].ID
+		}
+
+		return refs[i].Type < refs[j].Type
+	})
+
+	for _, ref := range refs {
+		cs := c.stores[ref.Type]
+		if cs == nil {
+			continue
+		}
+
+		if _, ok := cs.existing[ref.ID]; !ok {
+			c.report(cs.name, strconv.FormatUint(ref.ID, 10), "missing file referenced by "+strings.Join(c.refs[ref], ", "), "", nil)
+		}
+	}
+}
+
+func (c *checker) checkOrphans() {
+	for _, t := range [...]linkType{linkImage, linkAudio, linkChar, linkMusic} {
+		cs := c.stores[t]
+
+		for id := range cs.existing {
+			if _, ok := cs.listed[id]; ok {
+				continue
+			} else if _, ok := c.refs[reference{t, id}]; ok {
+				continue
+			}
+
+			key := strconv.FormatUint(id, 10)
+
+			c.report(cs.name, key, "file not in any folder and not referenced", "move file to trash", func() error {
+				t, err := cs.trash(&c.config)
+				if err != nil {
+					return err
+				}
+
+				return t.add(id, "")
+			})
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package battlemap
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"vimagination.zapto.org/keystore"
+)
+
+func TestCheckRepair(t *testing.T) {
+	for n, test := range [...]struct {
+		Mode     CheckMode
+		Repairs  []string
+		Repaired bool
+		Fixed    bool
+	}{
+		{CheckReport, []string{"", ""}, false, false},
+		{CheckDryRun, []string{"remove item from folder", "move file to trash"}, false, false},
+		{CheckRepair, []string{"remove item from folder", "move file to trash"}, true, true},
+	} {
+		base := t.TempDir()
+		dir := filepath.Join(base, "assets", "images")
+
+		store, err := keystore.NewFileStore(dir, dir, keystore.NoMangle)
+		if err != nil {
+			t.Fatalf("test %d: unexpected error creating store: %s", n+1, err)
+		}
+
+		f := folders{root: newFolder()}
+		f.root.Items["missing"] = 1
+		f.root.Items["present"] = 2
+
+		store.Set("2", keystore.String("listed"))
+		store.Set("3", keystore.String("orphaned"))
+		store.Set(folderMetadata, &f)
+
+		problems, err := Check(base, test.Mode)
+		if err != nil {
+			t.Errorf("test %d: unexpected error: %s", n+1, err)
+
+			continue
+		} else if len(problems) != len(test.Repairs) {
+			t.Errorf("test %d: expecting %d problems, got %d: %v", n+1, len(test.Repairs), len(problems), problems)
+
+			continue
+		}
+
+		for m, p := range problems {
+			if p.Repair != test.Repairs[m] {
+				t.Errorf("test %d.%d: expecting repair %q, got %q", n+1, m+1, test.Repairs[m], p.Repair)
+			} else if p.Repaired != test.Repaired || p.RepairErr != nil {
+				t.Errorf("test %d.%d: expecting repaired to be %v, got %v (%v)", n+1, m+1, test.Repaired, p.Repaired, p.RepairErr)
+			}
+		}
+
+		_, err = os.Stat(filepath.Join(base, "trash", "images", "3"))
+
+		if trashed := err == nil; trashed != test.Fixed {
+			t.Errorf("test %d: expecting orphan to be trashed to be %v", n+1, test.Fixed)
+		}
+
+		if problems, err = Check(base, CheckReport); err != nil {
+			t.Errorf("test %d: unexpected error rechecking: %s", n+1, err)
+		} else if fixed := len(problems) == 0; fixed != test.Fixed {
+			t.Errorf("test %d: expecting problems to be fixed to be %v, got %v", n+1, test.Fixed, problems)
+		}
+	}
+}
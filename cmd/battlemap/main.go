@@ -95,6 +95,9 @@ func run() error {
 	if err != nil {
 		return fmt.Errorf("error getting user config dir: %w", err)
 	}
+	if len(os.Args) > 1 && os.Args[1] == "fsck" {
+		return fsck(filepath.Join(defaultDir, "battlemap"), os.Args[2:])
+	}
 	username := flag.String("user", "", "Username")
 	password := flag.String("pass", "", "Password")
 	p := flag.String("path", filepath.Join(defaultDir, "battlemap"), "Data Path")
@@ -124,5 +127,40 @@ func run() error {
 	<-sc
 	signal.Stop(sc)
 	close(sc)
-	return server.Shutdown(context.Background())
+	if err := server.Shutdown(context.Background()); err != nil {
+		return err
+	}
+	return b.Close()
+}
+
+func fsck(defaultPath string, args []string) error {
+	fs := flag.NewFlagSet("fsck", flag.ExitOnError)
+	p := fs.String("path", defaultPath, "Data Path")
+	repair := fs.Bool("repair", false, "Repair problems where possible")
+	dryRun := fs.Bool("dry-run", false, "Report the repairs that would be made, without making them")
+	fs.Parse(args)
+	mode := battlemap.CheckReport
+	if *dryRun {
+		mode = battlemap.CheckDryRun
+	} else if *repair {
+		mode = battlemap.CheckRepair
+	}
+	problems, err := battlemap.Check(*p, mode)
+	if err != nil {
+		return fmt.Errorf("error checking data directory: %w", err)
+	}
+	for _, problem := range problems {
+		fmt.Println(problem)
+		if problem.Repair == "" {
+			continue
+		} else if problem.RepairErr != nil {
+			fmt.Printf("\trepair failed (%s): %s\n", problem.Repair, problem.RepairErr)
+		} else if problem.Repaired {
+			fmt.Printf("\trepaired: %s\n", problem.Repair)
+		} else {
+			fmt.Printf("\twould repair: %s\n", problem.Repair)
+		}
+	}
+	fmt.Printf("%d problem(s) found\n", len(problems))
+	return nil
 }
@@ -19,15 +19,43 @@ func (c *config) Init(baseDir string) error {
 	c.BaseDir = baseDir
 	c.memStore = keystore.NewMemStore()
 	c.memStore.SetAll(map[string]io.WriterTo{
-		"ServerPort":     keystore.Uint16(8080),
-		"ImageAssetsDir": keystore.String("assets/images"),
-		"AudioAssetsDir": keystore.String("assets/audio"),
-		"MusicPacksDir":  keystore.String("musicPacks"),
-		"CharsDir":       keystore.String("characters"),
-		"MapsDir":        keystore.String("maps"),
-		"FilesDir":       keystore.String("files"),
-		"PluginsDir":     keystore.String("plugins"),
-		"TokensDir":      keystore.String("tokens"),
+		"ServerPort":            keystore.Uint16(8080),
+		"ImageAssetsDir":        keystore.String("assets/images"),
+		"AudioAssetsDir":        keystore.String("assets/audio"),
+		"ImageVariantsDir":      keystore.String("assets/variants"),
+		"StripImageMetadata":    keystore.Uint8(1),
+		"MusicPacksDir":         keystore.String("musicPacks"),
+		"CharsDir":              keystore.String("characters"),
+		"CharHistoryDir":        keystore.String("history"),
+		"CharHistoryLimit":      keystore.Uint64(100),
+		"CharHistoryRetention":  keystore.Uint64(30 * 24 * 60 * 60),
+		"MapsDir":               keystore.String("maps"),
+		"MapCacheSize":          keystore.Uint64(16),
+		"MapFlushInterval":      keystore.Uint64(5),
+		"FilesDir":              keystore.String("files"),
+		"PluginsDir":            keystore.String("plugins"),
+		"TokensDir":             keystore.String("tokens"),
+		"RecordingsDir":         keystore.String("recordings"),
+		"TrashDir":              keystore.String("trash"),
+		"TrashRetention":        keystore.Uint64(30 * 24 * 60 * 60),
+		"AutoCleanup":           keystore.Uint8(0),
+		"RestrictAssets":        keystore.Uint8(0),
+		"ImagesMaxFileSize":     keystore.Uint64(0),
+		"ImagesQuota":           keystore.Uint64(0),
+		"AudioMaxFileSize":      keystore.Uint64(0),
+		"AudioQuota":            keystore.Uint64(0),
+		"CharactersMaxFileSize": keystore.Uint64(0),
+		"CharactersQuota":       keystore.Uint64(0),
+		"MapsMaxFileSize":       keystore.Uint64(0),
+		"MapsQuota":             keystore.Uint64(0),
+		"FilesMaxFileSize":      keystore.Uint64(0),
+		"FilesQuota":            keystore.Uint64(0),
+		"UploadsDir":            keystore.String("uploads"),
+		"UploadExpiry":          keystore.Uint64(24 * 60 * 60),
+		"FetchTimeout":          keystore.Uint64(30),
+		"FetchMaxSize":          keystore.Uint64(100 << 20),
+		"FetchAllowHosts":       keystore.String(""),
+		"FetchDenyHosts":        keystore.String(""),
 	})
 
 	var err error
@@ -9,6 +9,8 @@ var (
 	ErrItemNotFound              = errors.New("item not found")
 	ErrFolderNotFound            = errors.New("folder not found")
 	ErrCircularFolder            = errors.New("cannot move folder to own child")
+	ErrInvalidName               = errors.New("invalid name")
+	ErrInvalidFolderOrder        = errors.New("invalid folder order")
 	ErrUnknownMap                = errors.New("unknown map")
 	ErrUnknownLayer              = errors.New("unknown layer")
 	ErrUnknownToken              = errors.New("unknown token")
@@ -34,4 +36,26 @@ var (
 	ErrUnknownMethod             = errors.New("unknown method")
 	ErrInvalidPassword           = errors.New("invalid password")
 	ErrInvalidLighting           = errors.New("invalid lighting")
+	ErrInvalidSprite             = errors.New("invalid sprite")
+	ErrUnsupportedMapVersion     = errors.New("unsupported map version")
+	ErrNoVariant                 = errors.New("no variant required")
+	ErrInvalidHash               = errors.New("invalid hash")
+	ErrFileTooLarge              = errors.New("file exceeds maximum file size")
+	ErrQuotaExceeded             = errors.New("storage quota exceeded")
+	ErrZipTooLarge               = errors.New("zip archive too large")
+	ErrTooManyZipEntries         = errors.New("too many entries in zip archive")
+	ErrInvalidURL                = errors.New("invalid URL")
+	ErrHostNotAllowed            = errors.New("host not allowed")
+	ErrTooManyRedirects          = errors.New("too many redirects")
+	ErrInvalidSchema             = errors.New("invalid schema")
+	ErrInvalidCharacterData      = errors.New("invalid character data")
+	ErrCharacterNotOwned         = errors.New("character not owned")
+	ErrPlayersUnsupported        = errors.New("auth module cannot identify players")
+	ErrKeyNotEditable            = errors.New("key not editable")
+	ErrUnknownHistoryEntry       = errors.New("unknown history entry")
+	ErrInvalidRecording          = errors.New("invalid recording")
+	ErrUnknownRecording          = errors.New("unknown recording")
+	ErrAlreadyRecording          = errors.New("already recording")
+	ErrNotRecording              = errors.New("not recording")
+	ErrCurrentlyRecording        = errors.New("cannot remove recording in progress")
 )
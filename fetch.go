@@ -0,0 +1,345 @@
+package battlemap
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"vimagination.zapto.org/keystore"
+)
+
+const maxFetchRedirects = 5
+
+// hostList is a list of host names and IP prefixes.
+//
+// A host name matches itself and any of its subdomains.
+type hostList struct {
+	names    []string
+	prefixes []netip.Prefix
+}
+
+func parseHostList(list string) hostList {
+	var h hostList
+
+	for _, host := range strings.FieldsFunc(list, func(r rune) bool { return r == ',' || r == ' ' }) {
+		if p, err := netip.ParsePrefix(host); err == nil {
+			h.prefixes = append(h.prefixes, p.Masked())
+		} else if a, err := netip.ParseAddr(host); err == nil {
+			h.prefixes = append(h.prefixes, netip.PrefixFrom(a, a.BitLen()))
+		} else {
+			h.names = append(h.names, strings.ToLower(strings.TrimSuffix(host, ".")))
+		}
+	}
+
+	return h
+}
+
+func (h hostList) isEmpty() bool {
+	return len(h.names) == 0 && len(h.prefixes) == 0
+}
+
+func (h hostList) matchName(host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+
+	for _, name := range h.names {
+		if host == name || strings.HasSuffix(host, "."+name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchAddr determines whether the address, or the IPv4 address embedded in
+// it, is in the list.
+func (h hostList) matchAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	v4, embedded := embeddedIPv4(addr)
+
+	for _, p := range h.prefixes {
+		if p.Contains(addr) || embedded && p.Contains(v4) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isLocalAddr determines whether the address is one that should not be
+// reachable from outside of the local network.
+//
+// IPv6 addresses that embed an IPv4 address, such as NAT64 and 6to4
+// addresses, are local if the embedded address is, and addresses in the
+// local-use NAT64 prefix are always local.
+func isLocalAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+
+	if v4, ok := embeddedIPv4(addr); ok && isLocalAddr(v4) {
+		return true
+	}
+
+	return addr.IsLoopback() || addr.IsPrivate() || addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() || addr.IsInterfaceLocalMulticast() || addr.IsMulticast() || addr.IsUnspecified() || cgnat.Contains(addr) || nat64Local.Contains(addr)
+}
+
+var (
+	cgnat      = netip.MustParsePrefix("100.64.0.0/10")
+	nat64      = netip.MustParsePrefix("64:ff9b::/96")
+	nat64Local = netip.MustParsePrefix("64:ff9b:1::/48")
+	sixToFour  = netip.MustParsePrefix("2002::/16")
+	siit       = netip.MustParsePrefix("::ffff:0:0:0/96")
+	ipv4Compat = netip.MustParsePrefix("::/96")
+)
+
+// embeddedIPv4 returns the IPv4 address embedded in a NAT64, 6to4, SIIT
+// translated, or IPv4-compatible IPv6 address.
+func embeddedIPv4(addr netip.Addr) (netip.Addr, bool) {
+	if !addr.Is6() {
+		return netip.Addr{}, false
+	}
+
+	b := addr.As16()
+
+	switch {
+	case sixToFour.Contains(addr):
+		return netip.AddrFrom4([4]byte(b[2:6])), true
+	case nat64.Contains(addr), siit.Contains(addr), ipv4Compat.Contains(addr):
+		return netip.AddrFrom4([4]byte(b[12:16])), true
+	}
+
+	return netip.Addr{}, false
+}
+
+// fetcher downloads assets from remote servers.
+//
+// Hosts in the deny list can never be fetched from and, if the allow list is
+// not empty, only hosts in it can be. Addresses on the local network can only
+// be fetched from when explicitly allowed.
+type fetcher struct {
+	timeout     time.Duration
+	maxSize     int64
+	allow, deny hostList
+}
+
+func (f *fetcher) Init(c *config) error {
+	var (
+		timeout, maxSize keystore.Uint64
+		allow, deny      keystore.String
+	)
+
+	if err := c.Get("FetchTimeout", &timeout); err != nil {
+		return fmt.Errorf("error getting fetch timeout: %w", err)
+	}
+
+	if err := c.Get("FetchMaxSize", &maxSize); err != nil {
+		return fmt.Errorf("error getting fetch maximum size: %w", err)
+	}
+
+	if err := c.Get("FetchAllowHosts", &allow); err != nil {
+		return fmt.Errorf("error getting fetch allowed hosts: %w", err)
+	}
+
+	if err := c.Get("FetchDenyHosts", &deny); err != nil {
+		return fmt.Errorf("error getting fetch denied hosts: %w", err)
+	}
+
+	f.timeout = time.Duration(timeout) * time.Second
+	f.maxSize = int64(maxSize)
+	f.allow = parseHostList(string(allow))
+	f.deny = parseHostList(string(deny))
+
+	return nil
+}
+
+// hostAllowed determines whether the named host can be fetched from, before
+// its addresses are known.
+func (f *fetcher) hostAllowed(host string) bool {
+	if f.deny.matchName(host) {
+		return false
+	}
+
+	if a, err := netip.ParseAddr(host); err == nil {
+		return f.addrAllowed(host, a)
+	}
+
+	return f.allow.isEmpty() || f.allow.matchName(host)
+}
+
+// addrAllowed determines whether the given address of the named host can be
+// connected to.
+func (f *fetcher) addrAllowed(host string, addr netip.Addr) bool {
+	if f.deny.matchName(host) || f.deny.matchAddr(addr) {
+		return false
+	}
+
+	allowed := f.allow.matchName(host) || f.allow.matchAddr(addr)
+
+	if isLocalAddr(addr) {
+		return allowed
+	}
+
+	return allowed || f.allow.isEmpty()
+}
+
+// dialContext dials the first allowed address of the host, so that the checked
+// address is the one connected to.
+func (f *fetcher) dialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+
+	var addrs []netip.Addr
+
+	if a, err := netip.ParseAddr(host); err == nil {
+		addrs = append(addrs, a)
+	} else {
+		ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, ip := range ips {
+			if a, ok := netip.AddrFromSlice(ip.IP); ok {
+				addrs = append(addrs, a.Unmap())
+			}
+		}
+	}
+
+	var d net.Dialer
+
+	for _, a := range addrs {
+		if f.addrAllowed(host, a) {
+			return d.DialContext(ctx, network, net.JoinHostPort(a.String(), port))
+		}
+	}
+
+	return nil, ErrHostNotAllowed
+}
+
+func (f *fetcher) checkURL(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return ErrInvalidURL
+	} else if u.Hostname() == "" {
+		return ErrInvalidURL
+	} else if !f.hostAllowed(u.Hostname()) {
+		return ErrHostNotAllowed
+	}
+
+	return nil
+}
+
+func (f *fetcher) client() *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext:           f.dialContext,
+			TLSHandshakeTimeout:   f.timeout,
+			ResponseHeaderTimeout: f.timeout,
+			DisableKeepAlives:     true,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxFetchRedirects {
+				return ErrTooManyRedirects
+			}
+
+			return f.checkURL(req.URL)
+		},
+		Timeout: f.timeout,
+	}
+}
+
+// fetchName returns a file name for a fetched asset, from either the
+// Content-Disposition header or the final URL of the response.
+func fetchName(resp *http.Response) string {
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil && params["filename"] != "" {
+		return path.Base(strings.ReplaceAll(params["filename"], "\\", "/"))
+	}
+
+	if name := path.Base(resp.Request.URL.Path); name != "/" && name != "." {
+		return name
+	}
+
+	return ""
+}
+
+// fetch downloads an asset from the given URL into the given folder, returning
+// the ID and path of the new item.
+//
+// The downloaded file goes through the same checks as an uploaded file.
+func (a *assetsDir) fetch(cd ConnData, data json.RawMessage) (json.RawMessage, error) {
+	var toFetch struct {
+		URL  string `json:"url"`
+		Path string `json:"path"`
+	}
+
+	if err := json.Unmarshal(data, &toFetch); err != nil {
+		return nil, err
+	}
+
+	u, err := url.Parse(toFetch.URL)
+	if err != nil {
+		return nil, ErrInvalidURL
+	} else if err = a.fetcher.checkURL(u); err != nil {
+		return nil, err
+	}
+
+	resp, err := a.fetcher.client().Get(u.String())
+	if err != nil {
+		for _, e := range [...]error{ErrHostNotAllowed, ErrTooManyRedirects, ErrInvalidURL} {
+			if errors.Is(err, e) {
+				return nil, e
+			}
+		}
+
+		return nil, fmt.Errorf("error fetching asset: %w", err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error fetching asset: %s", resp.Status)
+	} else if a.fetcher.maxSize > 0 && resp.ContentLength > a.fetcher.maxSize {
+		return nil, ErrFileTooLarge
+	}
+
+	body := &quotaReader{Reader: resp.Body, remaining: -1}
+
+	if a.fetcher.maxSize > 0 {
+		body.remaining = a.fetcher.maxSize
+		body.exceeded = rejectionError(ErrFileTooLarge.Error())
+	}
+
+	id, err := a.addFile(&uploader{hash: sha256.New()}, body)
+	if err != nil {
+		return nil, err
+	}
+
+	filename := fetchName(resp)
+	if filename == "" || strings.ContainsAny(filename, invalidFilenameChars) {
+		filename = strconv.FormatUint(id, 10)
+	}
+
+	a.mu.Lock()
+	folderPath, folder := a.uploadFolder(toFetch.Path)
+	name := folderPath + addItemTo(folder, filename, id)
+	a.saveFolders()
+	a.mu.Unlock()
+	a.saveHashes()
+
+	buf := strconv.AppendUint(append(json.RawMessage{}, "{\"id\":"...), id, 10)
+	buf = append(appendString(append(buf, ",\"name\":"...), name), '}')
+
+	a.socket.broadcastAdminChange(a.getBroadcastID(broadcastImageItemAdd), append(append(json.RawMessage{'['}, buf...), ']'), cd.ID)
+
+	return buf, nil
+}
@@ -0,0 +1,46 @@
+package battlemap
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestFetchAddrAllowed(t *testing.T) {
+	for n, test := range [...]struct {
+		Allow, Deny, Host, Addr string
+		Allowed                 bool
+	}{
+		{"", "", "example.com", "93.184.215.14", true},
+		{"", "", "example.com", "127.0.0.1", false},
+		{"", "", "example.com", "192.168.1.10", false},
+		{"", "", "example.com", "::ffff:10.0.0.1", false},
+		{"", "", "example.com", "169.254.169.254", false},
+		{"", "", "example.com", "::1", false},
+		{"", "", "example.com", "100.64.0.1", false},
+		{"", "", "example.com", "64:ff9b::c0a8:10a", false},
+		{"", "", "example.com", "64:ff9b::7f00:1", false},
+		{"", "", "example.com", "64:ff9b::5db8:d70e", true},
+		{"", "", "example.com", "64:ff9b:1::5db8:d70e", false},
+		{"", "", "example.com", "2002:c0a8:10a::1", false},
+		{"", "", "example.com", "2002:a9fe:a9fe::", false},
+		{"", "", "example.com", "2002:5db8:d70e::1", true},
+		{"", "", "example.com", "::ffff:0:a00:1", false},
+		{"", "", "example.com", "::ffff:0:5db8:d70e", true},
+		{"", "", "example.com", "::7f00:1", false},
+		{"", "93.184.215.0/24", "example.com", "64:ff9b::5db8:d70e", false},
+		{"nas.local", "", "nas.local", "192.168.1.10", true},
+		{"192.168.1.0/24", "", "nas.local", "192.168.1.10", true},
+		{"192.168.1.0/24", "", "nas.local", "192.168.2.10", false},
+		{"example.com", "", "images.example.com", "93.184.215.14", true},
+		{"example.com", "", "example.org", "93.184.215.14", false},
+		{"", "example.com", "cdn.example.com", "93.184.215.14", false},
+		{"", "93.184.215.0/24", "example.com", "93.184.215.14", false},
+		{"example.com", "bad.example.com", "bad.example.com", "93.184.215.14", false},
+	} {
+		f := fetcher{allow: parseHostList(test.Allow), deny: parseHostList(test.Deny)}
+
+		if allowed := f.addrAllowed(test.Host, netip.MustParseAddr(test.Addr)); allowed != test.Allowed {
+			t.Errorf("test %d: expecting allowed %v for %s (%s), got %v", n+1, test.Allowed, test.Host, test.Addr, allowed)
+		}
+	}
+}
@@ -0,0 +1,518 @@
+package battlemap
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"vimagination.zapto.org/byteio"
+	"vimagination.zapto.org/keystore"
+)
+
+// fileShare describes which players a file has been shared with.
+type fileShare struct {
+	All     bool     `json:"all"`
+	Players []string `json:"players"`
+}
+
+// sharedWith determines whether the file has been shared with the user of the
+// given connection; admins can see all shared files.
+func (s fileShare) sharedWith(cd ConnData) bool {
+	return s.All || cd.IsAdmin() || cd.Player != "" && slices.Contains(s.Players, cd.Player)
+}
+
+type fileShares map[uint64]fileShare
+
+// canAccess determines whether the user of the given connection can download
+// the file with the given ID; admins can download any file.
+func (f fileShares) canAccess(cd ConnData, id uint64) bool {
+	s, ok := f[id]
+
+	return cd.IsAdmin() || ok && s.sharedWith(cd)
+}
+
+func (f fileShares) ReadFrom(r io.Reader) (int64, error) {
+	lr := byteio.StickyLittleEndianReader{Reader: r}
+
+	for l := lr.ReadUintX(); l > 0 && lr.Err == nil; l-- {
+		id := lr.ReadUintX()
+		s := fileShare{
+			All:     lr.ReadBool(),
+			Players: []string{},
+		}
+
+		for m := readLength(&lr); m > 0 && lr.Err == nil; m-- {
+			s.Players = append(s.Players, lr.ReadStringX())
+		}
+
+		f[id] = s
+	}
+
+	return lr.Count, lr.Err
+}
+
+func (f fileShares) WriteTo(w io.Writer) (int64, error) {
+	lw := byteio.StickyLittleEndianWriter{Writer: w}
+
+	lw.WriteUintX(uint64(len(f)))
+
+	for id, s := range f {
+		lw.WriteUintX(id)
+		lw.WriteBool(s.All)
+		lw.WriteUintX(uint64(len(s.Players)))
+
+		for _, p := range s.Players {
+			lw.WriteStringX(p)
+		}
+	}
+
+	return lw.Count, lw.Err
+}
+
+// filesDir is a store for documents, such as handouts, of any type, which can
+// be shared with all, or specific, players.
+type filesDir struct {
+	folders
+	handler http.Handler
+	shares  fileShares
+}
+
+func (f *filesDir) Init(b *Battlemap, _ links) error {
+	var location keystore.String
+
+	if err := b.config.Get("FilesDir", &location); err != nil {
+		return fmt.Errorf("error getting files directory: %w", err)
+	}
+
+	l := filepath.Join(b.config.BaseDir, string(location))
+
+	store, err := keystore.NewFileStore(l, l, keystore.NoMangle)
+	if err != nil {
+		return fmt.Errorf("error creating files store: %w", err)
+	}
+
+	f.fileType = fileTypeFile
+	f.handler = http.FileServer(http.Dir(l))
+
+	if err := f.folders.Init(b, store, make(linkManager)); err != nil {
+		return err
+	}
+
+	if err := f.initTrash("files", l); err != nil {
+		return err
+	}
+
+	if err := f.initQuota("Files"); err != nil {
+		return err
+	}
+
+	f.shares = make(fileShares)
+
+	if f.Exists(fileSharesKey) {
+		if err := f.Get(fileSharesKey, f.shares); err != nil {
+			return fmt.Errorf("error reading file shares: %w", err)
+		}
+	}
+
+	pruned := false
+
+	for id := range f.shares {
+		if f.itemName(id) == "" {
+			delete(f.shares, id)
+
+			pruned = true
+		}
+	}
+
+	if pruned {
+		if err := f.Set(fileSharesKey, f.shares); err != nil {
+			return fmt.Errorf("error writing file shares: %w", err)
+		}
+	}
+
+	f.trashed = func(ids []uint64) {
+		for _, id := range ids {
+			delete(f.shares, id)
+		}
+
+		f.Set(fileSharesKey, f.shares)
+	}
+
+	return nil
+}
+
+// cleanup moves all files that are not in any folder into the trash.
+func (f *filesDir) cleanup() {
+	l := make(linkManager)
+
+	walkFolders(f.root, func(items map[string]uint64) bool {
+		for _, id := range items {
+			l.setLink(id)
+		}
+
+		return false
+	})
+
+	f.folders.cleanup(l)
+}
+
+func (f *filesDir) RPCData(cd ConnData, method string, data json.RawMessage) (interface{}, error) {
+	if method == "shared" {
+		return f.shared(cd)
+	} else if cd.IsAdmin() {
+		switch method {
+		case "share":
+			return nil, f.share(cd, data)
+		case "unshare":
+			return nil, f.unshare(cd, data)
+		case "shares":
+			return f.listShares()
+		}
+	}
+
+	return f.folders.RPCData(cd, method, data)
+}
+
+// itemName returns the name of an item with the given ID.
+//
+// The caller must hold f.mu.
+func (f *filesDir) itemName(id uint64) string {
+	var name string
+
+	walkPaths(f.root, "/", func(_, n string, iid uint64) {
+		if iid == id && (name == "" || n < name) {
+			name = n
+		}
+	})
+
+	return name
+}
+
+// shared returns the IDs and names of all files shared with the user.
+func (f *filesDir) shared(cd ConnData) (json.RawMessage, error) {
+	files := []idName{}
+
+	f.mu.RLock()
+
+	for id, s := range f.shares {
+		if s.sharedWith(cd) {
+			if name := f.itemName(id); name != "" {
+				files = append(files, idName{id, name})
+			}
+		}
+	}
+
+	f.mu.RUnlock()
+
+	slices.SortFunc(files, func(a, b idName) int {
+		if c := strings.Compare(a.Name, b.Name); c != 0 {
+			return c
+		}
+
+		return cmp.Compare(a.ID, b.ID)
+	})
+
+	return json.Marshal(files)
+}
+
+type idShare struct {
+	ID uint64 `json:"id"`
+	fileShare
+}
+
+func (f *filesDir) listShares() (json.RawMessage, error) {
+	shares := []idShare{}
+
+	f.mu.RLock()
+
+	for id, s := range f.shares {
+		shares = append(shares, idShare{id, s})
+	}
+
+	f.mu.RUnlock()
+
+	slices.SortFunc(shares, func(a, b idShare) int { return cmp.Compare(a.ID, b.ID) })
+
+	return json.Marshal(shares)
+}
+
+// share sets the players that a file is shared with, sharing it with all
+// players when none are given.
+//
+// Sharing with specific players requires an Auth module that implements
+// PlayerAuth.
+func (f *filesDir) share(cd ConnData, data json.RawMessage) error {
+	var toShare idShare
+
+	if err := json.Unmarshal(data, &toShare); err != nil {
+		return err
+	}
+
+	toShare.Players = slices.DeleteFunc(toShare.Players, func(p string) bool { return p == "" })
+
+	slices.Sort(toShare.Players)
+
+	toShare.Players = slices.Compact(toShare.Players)
+	toShare.All = len(toShare.Players) == 0
+
+	if !toShare.All && !f.identifiesPlayers() {
+		return ErrPlayersUnsupported
+	}
+
+	f.mu.Lock()
+
+	name := f.itemName(toShare.ID)
+	if name == "" {
+		f.mu.Unlock()
+
+		return ErrItemNotFound
+	}
+
+	old := f.shares[toShare.ID]
+	f.shares[toShare.ID] = toShare.fileShare
+	err := f.Set(fileSharesKey, f.shares)
+
+	f.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	f.broadcastShare(cd, toShare.ID, name, old, toShare.fileShare)
+
+	return nil
+}
+
+// unshare stops a file from being shared with any player.
+func (f *filesDir) unshare(cd ConnData, data json.RawMessage) error {
+	var id uint64
+
+	if err := json.Unmarshal(data, &id); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+
+	old, ok := f.shares[id]
+	if !ok {
+		f.mu.Unlock()
+
+		return nil
+	}
+
+	delete(f.shares, id)
+
+	err := f.Set(fileSharesKey, f.shares)
+
+	f.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	f.broadcastShare(cd, id, "", old, fileShare{Players: []string{}})
+
+	return nil
+}
+
+// broadcastShare sends the new share state of a file to the other admins, and
+// tells the players who have gained or lost access to the file.
+func (f *filesDir) broadcastShare(cd ConnData, id uint64, name string, old, curr fileShare) {
+	data, _ := json.Marshal(idShare{id, curr})
+
+	f.socket.broadcastAdminChange(broadcastFileShare, data, cd.ID)
+
+	shared, _ := json.Marshal(idName{id, name})
+	unshared := strconv.AppendUint(nil, id, 10)
+
+	f.socket.broadcastPlayerChange(func(pcd ConnData) (int, json.RawMessage) {
+		if was, is := old.sharedWith(pcd), curr.sharedWith(pcd); is && !was {
+			return broadcastFileShared, shared
+		} else if was && !is {
+			return broadcastFileUnshared, unshared
+		}
+
+		return 0, nil
+	})
+}
+
+func (f *filesDir) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		id, err := strconv.ParseUint(r.URL.Path, 10, 64)
+		if err != nil {
+			http.NotFound(w, r)
+
+			return
+		}
+
+		cd := ConnData{Player: f.player(r)}
+
+		if f.auth.IsAdmin(r) {
+			cd.userState = userStateAdmin
+		} else if f.auth.IsUser(r) {
+			cd.userState = userStateUser
+		}
+
+		f.mu.RLock()
+		access := f.shares.canAccess(cd, id)
+		name := f.itemName(id)
+		f.mu.RUnlock()
+
+		if name == "" || !access {
+			http.NotFound(w, r)
+
+			return
+		}
+
+		if t := mime.TypeByExtension(path.Ext(name)); t != "" {
+			w.Header().Set(contentType, t)
+		}
+
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("inline", map[string]string{"filename": name}))
+		w.Header().Set("Content-Security-Policy", "sandbox")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		f.handler.ServeHTTP(w, r)
+	case http.MethodPost:
+		if !f.auth.IsAdmin(r) {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		} else if r.URL.Path != "" {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		} else if err := f.Post(w, r); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+	default:
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+// Post stores all of the files in a multipart upload, adding them to the
+// folder given in the 'path' form value.
+func (f *filesDir) Post(w http.ResponseWriter, r *http.Request) error {
+	defer r.Body.Close()
+
+	m, err := r.MultipartReader()
+	if err != nil {
+		return err
+	}
+
+	if err := r.ParseForm(); err != nil {
+		return fmt.Errorf("error processing form data: %w", err)
+	}
+
+	var (
+		added    []idName
+		rejected []rejection
+	)
+
+	for {
+		p, err := m.NextPart()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		filename := p.FileName()
+		if filename == "" {
+			continue
+		}
+
+		id, err := f.addFile(p)
+		if reason, ok := err.(rejectionError); ok {
+			rejected = append(rejected, rejection{Name: filename, Reason: reason.Error()})
+
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		if strings.ContainsAny(filename, invalidFilenameChars) {
+			filename = strconv.FormatUint(id, 10)
+		}
+
+		added = append(added, idName{id, filename})
+	}
+
+	if len(rejected) > 0 {
+		w.Header().Set("X-Rejected", string(asciiJSON(appendRejections(nil, rejected))))
+	}
+
+	f.mu.Lock()
+
+	folderPath := path.Clean("/" + r.Form.Get("path"))
+	folder := f.getFolder(folderPath)
+
+	if folder == nil {
+		folderPath, folder = "/", f.root
+	} else if folderPath != "/" {
+		folderPath += "/"
+	}
+
+	for n := range added {
+		added[n].Name = folderPath + addItemTo(folder.Items, added[n].Name, added[n].ID)
+	}
+
+	if len(added) > 0 {
+		f.saveFolders()
+	}
+
+	f.mu.Unlock()
+
+	if len(added) == 0 {
+		added = []idName{}
+	} else {
+		data, _ := json.Marshal(added)
+
+		f.socket.broadcastAdminChange(broadcastFileItemAdd, data, SocketIDFromRequest(r))
+	}
+
+	w.Header().Set(contentType, "application/json")
+
+	return json.NewEncoder(w).Encode(added)
+}
+
+// addFile stores the given file, rejecting it if it would exceed the size limits
+// of the store.
+func (f *filesDir) addFile(r io.Reader) (uint64, error) {
+	p := f.limitReader(r)
+
+	f.mu.Lock()
+	f.lastID++
+	id := f.lastID
+	f.mu.Unlock()
+
+	idStr := strconv.FormatUint(id, 10)
+
+	if err := f.Set(idStr, readerWriterTo{p}); err != nil {
+		f.Remove(idStr)
+
+		if p.err != nil {
+			return 0, p.err
+		}
+
+		return 0, err
+	}
+
+	f.updateUsage(id)
+
+	return id, nil
+}
+
+type readerWriterTo struct {
+	io.Reader
+}
+
+func (r readerWriterTo) WriteTo(w io.Writer) (int64, error) {
+	return io.Copy(w, r.Reader)
+}
+
+const fileSharesKey = "shares"
@@ -0,0 +1,123 @@
+package battlemap
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"vimagination.zapto.org/byteio"
+	"vimagination.zapto.org/memio"
+)
+
+func TestFileShares(t *testing.T) {
+	shares := fileShares{
+		1: {All: true, Players: []string{}},
+		2: {Players: []string{"alice", "bob"}},
+		3: {Players: []string{}},
+	}
+
+	for n, test := range [...]struct {
+		ID     uint64
+		User   userState
+		Player string
+		Shared bool
+		Access bool
+	}{
+		{1, userStateUser, "", true, true},
+		{1, userStateUser, "alice", true, true},
+		{1, userStateAdmin, "", true, true},
+		{2, userStateUser, "alice", true, true},
+		{2, userStateUser, "bob", true, true},
+		{2, userStateUser, "carol", false, false},
+		{2, userStateUser, "", false, false},
+		{2, userStateAdmin, "", true, true},
+		{3, userStateUser, "alice", false, false},
+		{3, userStateAdmin, "", true, true},
+		{4, userStateUser, "alice", false, false},
+		{4, userStateUser, "", false, false},
+		{4, userStateAdmin, "", true, true},
+	} {
+		cd := ConnData{Player: test.Player, userState: test.User}
+
+		if shared := shares[test.ID].sharedWith(cd); shared != test.Shared {
+			t.Errorf("test %d: expecting shared to be %v, got %v", n+1, test.Shared, shared)
+		} else if access := shares.canAccess(cd, test.ID); access != test.Access {
+			t.Errorf("test %d: expecting access to be %v, got %v", n+1, test.Access, access)
+		}
+	}
+}
+
+type testAuth struct {
+	http.Handler
+}
+
+func (testAuth) Auth(r *http.Request) *http.Request { return r }
+func (testAuth) IsAdmin(*http.Request) bool         { return false }
+func (testAuth) IsUser(*http.Request) bool          { return true }
+
+type testPlayerAuth struct {
+	testAuth
+}
+
+func (testPlayerAuth) Player(r *http.Request) string {
+	return r.Header.Get("X-Player")
+}
+
+func TestFileShareAuth(t *testing.T) {
+	for n, test := range [...]struct {
+		Auth Auth
+		Data string
+		Err  error
+	}{
+		{testAuth{}, `{"id":1,"players":[]}`, ErrItemNotFound},
+		{testAuth{}, `{"id":1,"players":[""]}`, ErrItemNotFound},
+		{testAuth{}, `{"id":1,"players":["alice"]}`, ErrPlayersUnsupported},
+		{testPlayerAuth{}, `{"id":1,"players":["alice"]}`, ErrItemNotFound},
+	} {
+		f := filesDir{
+			folders: folders{Battlemap: &Battlemap{auth: test.Auth}, root: newFolder()},
+			shares:  make(fileShares),
+		}
+
+		if err := f.share(ConnData{}, json.RawMessage(test.Data)); err != test.Err {
+			t.Errorf("test %d: expecting error %v, got %v", n+1, test.Err, err)
+		}
+	}
+}
+
+func TestFileSharesReadFrom(t *testing.T) {
+	for n, test := range [...]struct {
+		Write  func(*byteio.StickyLittleEndianWriter)
+		Shares fileShares
+		Err    error
+	}{
+		{
+			Write: func(lw *byteio.StickyLittleEndianWriter) {
+				fileShares{1: {All: true, Players: []string{}}, 2: {Players: []string{"alice", "bob"}}}.WriteTo(lw.Writer)
+			},
+			Shares: fileShares{1: {All: true, Players: []string{}}, 2: {Players: []string{"alice", "bob"}}},
+		},
+		{
+			Write: func(lw *byteio.StickyLittleEndianWriter) {
+				lw.WriteUintX(1)
+				lw.WriteUintX(1)
+				lw.WriteBool(false)
+				lw.WriteUintX(1 << 40)
+			},
+			Err: ErrInvalidData,
+		},
+	} {
+		var buf memio.Buffer
+
+		test.Write(&byteio.StickyLittleEndianWriter{Writer: &buf})
+
+		shares := make(fileShares)
+
+		if _, err := shares.ReadFrom(&buf); err != test.Err {
+			t.Errorf("test %d: expecting error %v, got %v", n+1, test.Err, err)
+		} else if test.Err == nil && !reflect.DeepEqual(shares, test.Shares) {
+			t.Errorf("test %d: expecting shares %v, got %v", n+1, test.Shares, shares)
+		}
+	}
+}
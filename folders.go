@@ -16,8 +16,10 @@ import (
 )
 
 type folder struct {
-	Folders map[string]*folder `json:"folders"`
-	Items   map[string]uint64  `json:"items"`
+	Folders     map[string]*folder `json:"folders"`
+	Items       map[string]uint64  `json:"items"`
+	FolderOrder []string           `json:"folderOrder,omitempty"`
+	ItemOrder   []string           `json:"itemOrder,omitempty"`
 }
 
 func newFolder() *folder {
@@ -74,6 +76,18 @@ type folders struct {
 	lastID uint64
 	root   *folder
 	json   memio.Buffer
+	trash  *trash
+	quota  storeQuota
+	space  storeSpace
+	tags   itemTags
+
+	// trashed, when set, is called with the IDs of the files that have been
+	// moved into the trash, with f.mu held for writing.
+	trashed func(ids []uint64)
+
+	// purged, when set, is called with the ID of each file permanently
+	// removed from the trash; it is called without f.mu held.
+	purged func(id uint64)
 }
 
 func (f *folders) Init(b *Battlemap, store *keystore.FileStore, l linkManager) error {
@@ -87,10 +101,45 @@ func (f *folders) Init(b *Battlemap, store *keystore.FileStore, l linkManager) e
 
 	f.processFolder(f.root, l)
 
+	for _, key := range f.Keys() {
+		if id, err := strconv.ParseUint(key, 10, 64); err == nil && id > f.lastID {
+			f.lastID = id
+		}
+	}
+
+	pruneOrder(f.root)
+
+	if err := f.initTags(); err != nil {
+		return err
+	}
+
 	return f.encodeJSON()
 }
 
+// initTrash sets up the trash area for the store, which is located in the
+// given directory.
+func (f *folders) initTrash(name, dir string) error {
+	f.trash = &trash{purged: f.purged}
+
+	if err := f.trash.Init(&f.config, name, dir); err != nil {
+		return err
+	}
+
+	for id := range f.trash.items {
+		if id > f.lastID {
+			f.lastID = id
+		}
+	}
+
+	f.pruneTags()
+
+	return nil
+}
+
+// cleanup moves all files not referenced by the link manager into the trash.
 func (f *folders) cleanup(l linkManager) {
+	unreferenced := make(map[uint64]string)
+
 	for _, key := range f.Keys() {
 		id, err := strconv.ParseUint(key, 10, 64)
 		if err != nil {
@@ -98,15 +147,56 @@ func (f *folders) cleanup(l linkManager) {
 		}
 
 		if _, ok := l[id]; !ok {
-			f.Remove(key)
+			unreferenced[id] = ""
 		}
 	}
+
+	f.trashItems(unreferenced)
+}
+
+// trashItems moves the given files into the trash, recording the names that
+// they will be restored with.
+func (f *folders) trashItems(items map[uint64]string) {
+	var trashed []uint64
+
+	for id, name := range items {
+		if f.trash.add(id, name) == nil {
+			trashed = append(trashed, id)
+		}
+	}
+
+	f.updateUsage(trashed...)
+
+	if len(trashed) > 0 && f.trashed != nil {
+		f.trashed(trashed)
+	}
+}
+
+// trashRemovedLocked moves the given files, which have been removed from a
+// folder, into the trash when they are no longer in any other folder.
+//
+// The caller must hold f.mu for writing.
+func (f *folders) trashRemovedLocked(removed map[uint64]string) {
+	if f.trash == nil {
+		return
+	}
+
+	walkFolders(f.root, func(items map[string]uint64) bool {
+		for _, id := range items {
+			delete(removed, id)
+		}
+
+		return len(removed) == 0
+	})
+
+	f.trashItems(removed)
 }
 
 func (f *folders) WriteTo(w io.Writer) (int64, error) {
 	lw := byteio.StickyLittleEndianWriter{Writer: w}
 
 	f.root.WriteToX(&lw)
+	f.root.writeOrderX(&lw)
 
 	return lw.Count, lw.Err
 }
@@ -116,6 +206,10 @@ func (f *folders) ReadFrom(r io.Reader) (int64, error) {
 
 	f.root.ReadFromX(&lr)
 
+	if lr.Err == nil {
+		f.root.readOrderX(&lr)
+	}
+
 	return lr.Count, lr.Err
 }
 
@@ -218,6 +312,7 @@ func (f *folders) getFolderItem(p string) (parent *folder, name string, iid uint
 }
 
 func (f *folders) saveFolders() {
+	pruneOrder(f.root)
 	f.Set(folderMetadata, f)
 	f.encodeJSON()
 }
@@ -259,6 +354,41 @@ func (f *folders) RPCData(cd ConnData, method string, data json.RawMessage) (int
 			return nil, f.folderDelete(cd, data)
 		case "copy":
 			return f.copyItem(cd, data)
+		case "bulk":
+			return f.bulk(cd, data)
+		case "rename":
+			return f.itemRename(cd, data)
+		case "renameFolder":
+			return f.folderRename(cd, data)
+		case "setOrder":
+			return nil, f.setOrder(cd, data)
+		case "quota":
+			return f.quotaJSON(), nil
+		case "tag":
+			return f.setTags(data, true)
+		case "untag":
+			return f.setTags(data, false)
+		case "tags":
+			return f.getTags(data)
+		case "search":
+			return f.search(data)
+		}
+
+		if f.trash != nil {
+			switch method {
+			case "trash":
+				return f.trash.list(), nil
+			case "restore":
+				return f.restore(cd, data)
+			case "purge":
+				var ids []uint64
+
+				if err := json.Unmarshal(data, &ids); err != nil {
+					return nil, err
+				}
+
+				return nil, f.trash.purge(ids)
+			}
 		}
 	}
 
@@ -283,6 +413,22 @@ func (f *folders) folderCreate(cd ConnData, data json.RawMessage) (string, error
 	f.mu.Lock()
 	defer f.mu.Unlock()
 
+	dir, err := f.createFolderLocked(dir)
+	if err != nil {
+		return "", err
+	}
+
+	f.saveFolders()
+	f.socket.broadcastAdminChange(f.getBroadcastID(broadcastImageFolderAdd), data, cd.ID)
+
+	return dir, nil
+}
+
+// createFolderLocked creates a new folder at the given path, returning the
+// path of the folder, which may have been renamed to make it unique.
+//
+// The caller must hold f.mu for writing.
+func (f *folders) createFolderLocked(dir string) (string, error) {
 	parent, name, _ := f.getParentFolder(dir)
 	if parent == nil || name == "" {
 		return "", ErrFolderNotFound
@@ -290,13 +436,7 @@ func (f *folders) folderCreate(cd ConnData, data json.RawMessage) (string, error
 
 	newName := addFolderTo(parent.Folders, name, newFolder())
 
-	f.saveFolders()
-
-	dir = dir[:len(dir)-len(name)] + newName
-
-	f.socket.broadcastAdminChange(f.getBroadcastID(broadcastImageFolderAdd), data, cd.ID)
-
-	return dir, nil
+	return dir[:len(dir)-len(name)] + newName, nil
 }
 
 type fromTo struct {
@@ -314,7 +454,23 @@ func (f *folders) itemMove(cd ConnData, data json.RawMessage) (string, error) {
 	f.mu.Lock()
 	defer f.mu.Unlock()
 
-	oldParent, oldName, iid := f.getFolderItem(itemMove.From)
+	to, err := f.moveItemLocked(itemMove.From, itemMove.To)
+	if err != nil {
+		return "", err
+	}
+
+	f.saveFolders()
+	f.socket.broadcastAdminChange(f.getBroadcastID(broadcastImageItemMove), data, cd.ID)
+
+	return to, nil
+}
+
+// moveItemLocked moves an item to either the given folder, when the path ends
+// with a slash, or the given path, returning the new path of the item.
+//
+// The caller must hold f.mu for writing.
+func (f *folders) moveItemLocked(from, to string) (string, error) {
+	oldParent, oldName, iid := f.getFolderItem(from)
 	if oldParent == nil || iid == 0 {
 		return "", ErrItemNotFound
 	}
@@ -324,27 +480,26 @@ func (f *folders) itemMove(cd ConnData, data json.RawMessage) (string, error) {
 		newName   string
 	)
 
-	if strings.HasSuffix(itemMove.To, "/") {
-		newParent = f.getFolder(strings.TrimRight(itemMove.To, "/"))
+	if strings.HasSuffix(to, "/") {
+		to = strings.TrimRight(to, "/")
+		newParent = f.getFolder(to)
 		newName = oldName
 	} else {
-		path, file := path.Split(itemMove.To)
+		path, file := path.Split(to)
 		newName = file
-		itemMove.To = strings.TrimRight(path, "/")
-		newParent = f.getFolder(itemMove.To)
+		to = strings.TrimRight(path, "/")
+		newParent = f.getFolder(to)
+	}
+
+	if newParent == nil {
+		return "", ErrFolderNotFound
 	}
 
 	delete(oldParent.Items, oldName)
 
 	newName = addItemTo(newParent.Items, newName, iid)
 
-	f.saveFolders()
-
-	itemMove.To += "/" + newName
-
-	f.socket.broadcastAdminChange(f.getBroadcastID(broadcastImageItemMove), data, cd.ID)
-
-	return itemMove.To, nil
+	return to + "/" + newName, nil
 }
 
 func (f *folders) folderMove(cd ConnData, data json.RawMessage) (string, error) {
@@ -357,7 +512,23 @@ func (f *folders) folderMove(cd ConnData, data json.RawMessage) (string, error)
 	f.mu.Lock()
 	defer f.mu.Unlock()
 
-	oldParent, oldName, fd := f.getParentFolder(folderMove.From)
+	to, err := f.moveFolderLocked(folderMove.From, folderMove.To)
+	if err != nil {
+		return "", err
+	}
+
+	f.saveFolders()
+	f.socket.broadcastAdminChange(f.getBroadcastID(broadcastImageFolderMove), data, cd.ID)
+
+	return to, nil
+}
+
+// moveFolderLocked moves a folder to either the given folder, when the path
+// ends with a slash, or the given path, returning the new path of the folder.
+//
+// The caller must hold f.mu for writing.
+func (f *folders) moveFolderLocked(from, to string) (string, error) {
+	oldParent, oldName, fd := f.getParentFolder(from)
 	if oldParent == nil || fd == nil {
 		return "", ErrFolderNotFound
 	}
@@ -367,31 +538,28 @@ func (f *folders) folderMove(cd ConnData, data json.RawMessage) (string, error)
 		newName   string
 	)
 
-	if strings.HasSuffix(folderMove.To, "/") {
-		newParent = f.getFolder(strings.TrimRight(folderMove.To, "/"))
+	if strings.HasSuffix(to, "/") {
+		to = strings.TrimRight(to, "/")
+		newParent = f.getFolder(to)
 		newName = oldName
 	} else {
-		path, file := path.Split(folderMove.To)
+		path, file := path.Split(to)
 		newName = file
-		folderMove.To = strings.TrimRight(path, "/")
-		newParent = f.getFolder(folderMove.To)
+		to = strings.TrimRight(path, "/")
+		newParent = f.getFolder(to)
 	}
 
-	if strings.HasSuffix(folderMove.To, folderMove.From) {
+	if strings.HasSuffix(to, from) {
 		return "", ErrCircularFolder
+	} else if newParent == nil {
+		return "", ErrFolderNotFound
 	}
 
 	delete(oldParent.Folders, oldName)
 
 	newName = addFolderTo(newParent.Folders, newName, fd)
 
-	f.saveFolders()
-
-	folderMove.To += "/" + newName
-
-	f.socket.broadcastAdminChange(f.getBroadcastID(broadcastImageFolderMove), data, cd.ID)
-
-	return folderMove.To, nil
+	return to + "/" + newName, nil
 }
 
 func (f *folders) itemDelete(cd ConnData, data json.RawMessage) error {
@@ -410,13 +578,31 @@ func (f *folders) itemDeleteString(item string) error {
 	f.mu.Lock()
 	defer f.mu.Unlock()
 
+	removed := make(map[uint64]string)
+
+	if err := f.removeItemLocked(item, removed); err != nil {
+		return err
+	}
+
+	f.trashRemovedLocked(removed)
+	f.saveFolders()
+
+	return nil
+}
+
+// removeItemLocked removes the item at the given path from its folder,
+// recording the removed file in the given map.
+//
+// The caller must hold f.mu for writing.
+func (f *folders) removeItemLocked(item string, removed map[uint64]string) error {
 	parent, oldName, iid := f.getFolderItem(item)
 	if parent == nil || iid == 0 {
 		return ErrItemNotFound
 	}
 
 	delete(parent.Items, oldName)
-	f.saveFolders()
+
+	removed[iid] = oldName
 
 	return nil
 }
@@ -431,14 +617,38 @@ func (f *folders) folderDelete(cd ConnData, data json.RawMessage) error {
 	f.mu.Lock()
 	defer f.mu.Unlock()
 
+	removed := make(map[uint64]string)
+
+	if err := f.removeFolderLocked(folder, removed); err != nil {
+		return err
+	}
+
+	f.trashRemovedLocked(removed)
+	f.saveFolders()
+	f.socket.broadcastAdminChange(f.getBroadcastID(broadcastImageFolderRemove), data, cd.ID)
+
+	return nil
+}
+
+// removeFolderLocked removes the folder at the given path, along with all of
+// its contents, recording the removed files in the given map.
+//
+// The caller must hold f.mu for writing.
+func (f *folders) removeFolderLocked(folder string, removed map[uint64]string) error {
 	parent, oldName, fd := f.getParentFolder(folder)
 	if parent == nil || fd == nil {
 		return ErrFolderNotFound
 	}
 
 	delete(parent.Folders, oldName)
-	f.saveFolders()
-	f.socket.broadcastAdminChange(f.getBroadcastID(broadcastImageFolderRemove), data, cd.ID)
+
+	walkFolders(fd, func(items map[string]uint64) bool {
+		for name, id := range items {
+			removed[id] = name
+		}
+
+		return false
+	})
 
 	return nil
 }
@@ -481,6 +691,45 @@ func (f *folders) copyItem(cd ConnData, data json.RawMessage) (interface{}, erro
 	return data, nil
 }
 
+func (f *folders) restore(cd ConnData, data json.RawMessage) (json.RawMessage, error) {
+	var id uint64
+
+	if err := json.Unmarshal(data, &id); err != nil {
+		return nil, err
+	}
+
+	name, err := f.restoreItem(id)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := append(appendString(append(strconv.AppendUint(append(json.RawMessage{}, "[{\"id\":"...), id, 10), ",\"name\":"...), name), '}', ']')
+
+	f.socket.broadcastAdminChange(f.getBroadcastID(broadcastImageItemAdd), buf, cd.ID)
+
+	return buf[1 : len(buf)-1], nil
+}
+
+// restoreItem moves an item out of the trash and adds it to the root folder,
+// returning the path of the restored item.
+func (f *folders) restoreItem(id uint64) (string, error) {
+	name, err := f.trash.restore(id)
+	if err != nil {
+		return "", err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.updateUsage(id)
+
+	name = addItemTo(f.root.Items, name, id)
+
+	f.saveFolders()
+
+	return "/" + name, nil
+}
+
 func (f *folders) getBroadcastID(base int) int {
 	switch f.fileType {
 	case fileTypeAudio:
@@ -489,9 +738,27 @@ func (f *folders) getBroadcastID(base int) int {
 		return base - 2
 	case fileTypeMap:
 		return base - 3
+	case fileTypeFile:
+		if id, ok := fileBroadcasts[base]; ok {
+			return id
+		}
 	}
 
 	return base
 }
 
+// fileBroadcasts maps the image broadcast IDs to those of the files store,
+// which are not grouped with the other stores.
+var fileBroadcasts = map[int]int{
+	broadcastImageItemAdd:      broadcastFileItemAdd,
+	broadcastImageItemMove:     broadcastFileItemMove,
+	broadcastImageItemRemove:   broadcastFileItemRemove,
+	broadcastImageItemCopy:     broadcastFileItemCopy,
+	broadcastImageFolderAdd:    broadcastFileFolderAdd,
+	broadcastImageFolderMove:   broadcastFileFolderMove,
+	broadcastImageFolderRemove: broadcastFileFolderRemove,
+	broadcastImageFolderBulk:   broadcastFileFolderBulk,
+	broadcastImageFolderOrder:  broadcastFileFolderOrder,
+}
+
 const folderMetadata = "folders"
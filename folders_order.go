@@ -0,0 +1,271 @@
+package battlemap
+
+import (
+	"encoding/json"
+	"io"
+	"path"
+	"slices"
+	"strings"
+
+	"vimagination.zapto.org/byteio"
+)
+
+// folderOrderVersion marks the start of the folder order data, which follows
+// the folder tree in the folders metadata file.
+//
+// Files written before the order data was added end after the folder tree, so
+// a missing marker means no folder has a custom order; older readers ignore
+// the trailing data.
+const folderOrderVersion = 1
+
+// maxOrderNames is the largest number of names accepted in a single stored
+// folder or item order.
+const maxOrderNames = 1 << 16
+
+// writeOrderX writes the custom order of every folder in the tree that has
+// one.
+func (f *folder) writeOrderX(lw *byteio.StickyLittleEndianWriter) {
+	var paths []string
+
+	walkFolderPaths(f, "/", func(p string, fd *folder) {
+		if len(fd.FolderOrder) > 0 || len(fd.ItemOrder) > 0 {
+			paths = append(paths, p)
+		}
+	})
+
+	lw.WriteUint8(folderOrderVersion)
+	lw.WriteUintX(uint64(len(paths)))
+
+	for _, p := range paths {
+		fd := getFolderFrom(f, p)
+
+		lw.WriteStringX(p)
+
+		for _, names := range [...][]string{fd.FolderOrder, fd.ItemOrder} {
+			lw.WriteUintX(uint64(len(names)))
+
+			for _, name := range names {
+				lw.WriteStringX(name)
+			}
+		}
+	}
+}
+
+// readOrderX reads the custom folder orders, if there are any.
+//
+// An order claiming more than maxOrderNames names is rejected as corrupt.
+func (f *folder) readOrderX(lr *byteio.StickyLittleEndianReader) {
+	if lr.ReadUint8() != folderOrderVersion {
+		if lr.Err == io.EOF {
+			lr.Err = nil
+		}
+
+		return
+	}
+
+	for l := lr.ReadUintX(); l > 0 && lr.Err == nil; l-- {
+		p := lr.ReadStringX()
+
+		var names [2][]string
+
+		for n := range names {
+			count := lr.ReadUintX()
+			if count > maxOrderNames {
+				if lr.Err == nil {
+					lr.Err = ErrInvalidFolderOrder
+				}
+
+				return
+			}
+
+			for m := count; m > 0 && lr.Err == nil; m-- {
+				names[n] = append(names[n], lr.ReadStringX())
+			}
+		}
+
+		if fd := getFolderFrom(f, p); fd != nil {
+			fd.FolderOrder, fd.ItemOrder = names[0], names[1]
+		}
+	}
+}
+
+// walkFolderPaths calls the given function for every folder in the tree, with
+// the path of the folder.
+func walkFolderPaths(f *folder, p string, fn func(string, *folder)) {
+	fn(p, f)
+
+	for name, fd := range f.Folders {
+		walkFolderPaths(fd, p+name+"/", fn)
+	}
+}
+
+// pruneOrder removes any names from the custom orders in the folder tree that
+// no longer exist in their folders.
+func pruneOrder(f *folder) {
+	walkFolderPaths(f, "/", func(_ string, fd *folder) {
+		fd.FolderOrder = slices.DeleteFunc(fd.FolderOrder, func(name string) bool { return fd.Folders[name] == nil })
+		fd.ItemOrder = slices.DeleteFunc(fd.ItemOrder, func(name string) bool {
+			_, ok := fd.Items[name]
+
+			return !ok
+		})
+	})
+}
+
+func getFolderFrom(d *folder, p string) *folder {
+	for _, name := range strings.Split(p, "/") {
+		if name == "" {
+			continue
+		}
+
+		if d = d.Folders[name]; d == nil {
+			return nil
+		}
+	}
+
+	return d
+}
+
+func replaceName(names []string, oldName, newName string) {
+	if n := slices.Index(names, oldName); n >= 0 {
+		names[n] = newName
+	}
+}
+
+func isValidName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.Contains(name, "/")
+}
+
+// itemRename renames an item within its folder, keeping its position in any
+// custom order, and returns the new path of the item.
+func (f *folders) itemRename(cd ConnData, data json.RawMessage) (string, error) {
+	var r fromTo
+
+	if err := json.Unmarshal(data, &r); err != nil {
+		return "", err
+	} else if !isValidName(r.To) {
+		return "", ErrInvalidName
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	parent, oldName, _ := f.getFolderItem(r.From)
+	if parent == nil {
+		return "", ErrItemNotFound
+	}
+
+	dir, _ := path.Split(r.From)
+
+	to, err := f.moveItemLocked(r.From, dir+r.To)
+	if err != nil {
+		return "", err
+	}
+
+	replaceName(parent.ItemOrder, oldName, path.Base(to))
+	f.saveFolders()
+
+	r.To = to
+
+	data, _ = json.Marshal(r)
+
+	f.socket.broadcastAdminChange(f.getBroadcastID(broadcastImageItemMove), data, cd.ID)
+
+	return to, nil
+}
+
+// folderRename renames a folder within its parent, keeping its position in any
+// custom order, and returns the new path of the folder.
+func (f *folders) folderRename(cd ConnData, data json.RawMessage) (string, error) {
+	var r fromTo
+
+	if err := json.Unmarshal(data, &r); err != nil {
+		return "", err
+	} else if !isValidName(r.To) {
+		return "", ErrInvalidName
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	parent, oldName, fd := f.getParentFolder(r.From)
+	if parent == nil || fd == nil {
+		return "", ErrFolderNotFound
+	}
+
+	dir, _ := path.Split(path.Clean(strings.TrimRight(r.From, "/")))
+
+	to, err := f.moveFolderLocked(r.From, dir+r.To)
+	if err != nil {
+		return "", err
+	}
+
+	replaceName(parent.FolderOrder, oldName, path.Base(to))
+	f.saveFolders()
+
+	r.To = to
+
+	data, _ = json.Marshal(r)
+
+	f.socket.broadcastAdminChange(f.getBroadcastID(broadcastImageFolderMove), data, cd.ID)
+
+	return to, nil
+}
+
+// setOrder sets the custom order of the sub-folders and items of a folder.
+// Names not in the order are sorted after those that are.
+func (f *folders) setOrder(cd ConnData, data json.RawMessage) error {
+	var order struct {
+		Path    string   `json:"path"`
+		Folders []string `json:"folders"`
+		Items   []string `json:"items"`
+	}
+
+	if err := json.Unmarshal(data, &order); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	fd := f.getFolder(order.Path)
+	if fd == nil {
+		return ErrFolderNotFound
+	}
+
+	for _, name := range order.Folders {
+		if fd.Folders[name] == nil {
+			return ErrFolderNotFound
+		}
+	}
+
+	for _, name := range order.Items {
+		if _, ok := fd.Items[name]; !ok {
+			return ErrItemNotFound
+		}
+	}
+
+	fd.FolderOrder = uniqueNames(order.Folders)
+	fd.ItemOrder = uniqueNames(order.Items)
+
+	f.saveFolders()
+	f.socket.broadcastAdminChange(f.getBroadcastID(broadcastImageFolderOrder), data, cd.ID)
+
+	return nil
+}
+
+// uniqueNames removes any repeated names from the list, keeping the first
+// occurrence of each.
+func uniqueNames(names []string) []string {
+	seen := make(map[string]struct{}, len(names))
+
+	return slices.DeleteFunc(names, func(name string) bool {
+		if _, ok := seen[name]; ok {
+			return true
+		}
+
+		seen[name] = struct{}{}
+
+		return false
+	})
+}
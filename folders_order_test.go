@@ -0,0 +1,102 @@
+package battlemap
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+
+	"vimagination.zapto.org/byteio"
+	"vimagination.zapto.org/memio"
+)
+
+func TestFolderOrderRoundTrip(t *testing.T) {
+	many := newFolder()
+
+	for n := range 2000 {
+		name := strconv.Itoa(n)
+		many.Items[name] = uint64(n + 1)
+		many.ItemOrder = append(many.ItemOrder, name)
+	}
+
+	for n, test := range [...]struct {
+		Root   func() *folder
+		Legacy bool
+		Err    error
+	}{
+		{
+			Root: newFolder,
+		},
+		{
+			Root: func() *folder {
+				f := newFolder()
+				f.Folders["a"] = newFolder()
+				f.Folders["b"] = newFolder()
+				f.Items["c"] = 1
+				f.Items["d"] = 2
+				f.FolderOrder = []string{"b", "a"}
+				f.ItemOrder = []string{"d"}
+				f.Folders["a"].Items["e"] = 3
+				f.Folders["a"].Items["f"] = 4
+				f.Folders["a"].ItemOrder = []string{"f", "e"}
+
+				return f
+			},
+		},
+		{
+			Root: func() *folder {
+				f := newFolder()
+				f.Folders["a"] = many
+
+				return f
+			},
+		},
+		{
+			Root: func() *folder {
+				f := newFolder()
+				f.Items["a"] = 1
+
+				return f
+			},
+			Legacy: true,
+		},
+		{
+			Root: func() *folder {
+				f := newFolder()
+				f.Items["a"] = 1
+
+				return f
+			},
+			Err: ErrInvalidFolderOrder,
+		},
+	} {
+		var (
+			buf  memio.Buffer
+			from = folders{root: test.Root()}
+			to   = folders{root: newFolder()}
+		)
+
+		if test.Legacy {
+			lw := byteio.StickyLittleEndianWriter{Writer: &buf}
+
+			from.root.WriteToX(&lw)
+		} else if test.Err != nil {
+			lw := byteio.StickyLittleEndianWriter{Writer: &buf}
+
+			from.root.WriteToX(&lw)
+			lw.WriteUint8(folderOrderVersion)
+			lw.WriteUintX(1)
+			lw.WriteStringX("/")
+			lw.WriteUintX(maxOrderNames + 1)
+		} else if _, err := from.WriteTo(&buf); err != nil {
+			t.Errorf("test %d: unexpected error writing folders: %s", n+1, err)
+
+			continue
+		}
+
+		if _, err := to.ReadFrom(&buf); err != test.Err {
+			t.Errorf("test %d: expecting error %v, got %v", n+1, test.Err, err)
+		} else if test.Err == nil && !reflect.DeepEqual(from.root, to.root) {
+			t.Errorf("test %d: folder tree did not survive round trip", n+1)
+		}
+	}
+}
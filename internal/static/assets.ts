@@ -208,8 +208,8 @@ export const audioAssetName = (id: Uint, fn: (name: string) => void) => getAsset
 imageAssetName = (id: Uint, fn: (name: string) => void) => getAssetName(id, fn, imageAssets),
 uploadImages = uploadAsset.bind(null, imageRoot, "images"),
 uploadAudio = uploadAsset.bind(null, audioRoot, "audio"),
-dragImageFiles = new DragFiles("image/gif", "image/png", "image/jpeg", "image/webp", "video/apng", "image/svg+xml"),
-dragAudioFiles = new DragFiles("application/ogg", "audio/mpeg"),
+dragImageFiles = new DragFiles("image/gif", "image/png", "image/jpeg", "image/webp", "video/apng", "image/svg+xml", "image/avif", "video/mp4", "video/webm"),
+dragAudioFiles = new DragFiles("application/ogg", "audio/mpeg", "audio/wav", "audio/flac", "audio/webm", "audio/ogg"),
 dragAudio = new DragTransfer<AudioAsset>("audioasset"),
 dragImage = new DragTransfer<ImageAsset>("imageasset");
 
@@ -565,6 +565,26 @@ export class Root {
 			rpcFuncs.waitFolderAdded().when(folder => this.addFolder(folder));
 			rpcFuncs.waitFolderMoved().when(({from, to}) => this.moveFolder(from, to));
 			rpcFuncs.waitFolderRemoved().when(folder => this.removeFolder(folder));
+			rpcFuncs.waitBulk().when(ops => {
+				for (const {op, path = "", from = "", to = ""} of ops) {
+					switch (op) {
+					case "createFolder":
+						this.addFolder(path);
+						break;
+					case "move":
+						this.moveItem(from, to);
+						break;
+					case "moveFolder":
+						this.moveFolder(from, to);
+						break;
+					case "remove":
+						this.removeItem(path);
+						break;
+					case "removeFolder":
+						this.removeFolder(path);
+					}
+				}
+			});
 		}
 	}
 	get filter() { return true; }
@@ -1,6 +1,6 @@
 import type {Binding} from './lib/bind.js';
 import type {TypeGuard} from './lib/typeguard.js';
-import type {Broadcast, FolderItems, GridDetails, IDName, IDPath, Keystore, LayerRename, MapData, MapStart, Mask, MusicPack, NewMap, Plugin, Token, TokenSet, Wall} from './types.js';
+import type {Broadcast, CharacterDataChange, CharacterHistory, CharacterKeys, CharacterOwners, FileShare, FolderBulk, FolderItems, FolderOrder, GridDetails, IDName, IDPath, Keystore, LayerRename, MapData, MapStart, Mask, MusicPack, NewMap, Plugin, Token, TokenSet, Wall} from './types.js';
 import {WS} from './lib/conn.js';
 import {Subscription} from './lib/inter.js';
 import pageLoad from './lib/load.js';
@@ -8,10 +8,10 @@ import {RPC} from './lib/rpc.js';
 import {And, Arr, Obj, Rec, Tuple, Void} from './lib/typeguard.js';
 import {Colour, isColour} from './colours.js';
 import lang from './language.js';
-import {isArrIDName, isBool, isBroadcast, isBroadcastWindow, isCharacterDataChange, isFolderItems, isFromTo, isIDName, isIDPath, isKeyData, isKeystore, isLayerMove, isLayerRename, isLayerShift, isMapData, isMapDetails, isMapStart, isMask, isMaskSet, isMusicPack, isMusicPackPlay, isMusicPackTrackAdd, isMusicPackTrackRemove, isMusicPackTrackRepeat, isMusicPackTrackVolume, isMusicPackVolume, isPlugin, isPluginDataChange, isStr, isTokenAdd, isTokenMoveLayerPos, isTokenSet, isUint, isWall, isWallPath} from './types.js';
+import {isArrIDName, isBool, isBroadcast, isBroadcastWindow, isCharacterDataChange, isCharacterHistory, isCharacterKeys, isCharacterOwners, isCharacterSchema, isFileShare, isFolderBulk, isFolderItems, isFolderOrder, isFromTo, isIDName, isIDPath, isKeyData, isKeystore, isLayerMove, isLayerRename, isLayerShift, isMapData, isMapDetails, isMapStart, isMask, isMaskSet, isMusicPack, isMusicPackPlay, isMusicPackTrackAdd, isMusicPackTrackRemove, isMusicPackTrackRepeat, isMusicPackTrackVolume, isMusicPackVolume, isPlugin, isPluginDataChange, isStr, isTokenAdd, isTokenMoveLayerPos, isTokenSet, isUint, isUnknown, isWall, isWallPath} from './types.js';
 import {shell} from './windows.js';
 
-const broadcastIsAdmin = -1, broadcastCurrentUserMap = -2, broadcastCurrentUserMapData = -3, broadcastMapDataSet = -4, broadcastMapDataRemove = -5, broadcastMapStartChange = -6, broadcastImageItemAdd = -7, broadcastAudioItemAdd = -8, broadcastCharacterItemAdd = -9, broadcastMapItemAdd = -10, broadcastImageItemMove = -11, broadcastAudioItemMove = -12, broadcastCharacterItemMove = -13, broadcastMapItemMove = -14, broadcastImageItemRemove = -15, broadcastAudioItemRemove = -16, broadcastCharacterItemRemove = -17, broadcastMapItemRemove = -18, broadcastImageItemCopy = -19, broadcastAudioItemCopy = -20, broadcastCharacterItemCopy = -21, broadcastMapItemCopy = -22, broadcastImageFolderAdd = -23, broadcastAudioFolderAdd = -24, broadcastCharacterFolderAdd = -25, broadcastMapFolderAdd = -26, broadcastImageFolderMove = -27, broadcastAudioFolderMove = -28, broadcastCharacterFolderMove = -29, broadcastMapFolderMove = -30, broadcastImageFolderRemove = -31, broadcastAudioFolderRemove = -32, broadcastCharacterFolderRemove = -33, broadcastMapFolderRemove = -34, broadcastMapItemChange = -35, broadcastCharacterDataChange = -36, broadcastLayerAdd = -37, broadcastLayerFolderAdd = -38, broadcastLayerMove = -39, broadcastLayerRename = -40, broadcastLayerRemove = -41, broadcastGridDistanceChange = -42, broadcastGridDiagonalChange = -43, broadcastMapLightChange = -44, broadcastLayerShow = -45, broadcastLayerHide = -46, broadcastLayerLock = -47, broadcastLayerUnlock = -48, broadcastMaskAdd = -49, broadcastMaskRemove = -50, broadcastMaskSet = -51, broadcastTokenAdd = -52, broadcastTokenRemove = -53, broadcastTokenMoveLayerPos = -54, broadcastTokenSet = -55, broadcastTokenSetMulti = -56, broadcastLayerShift = -57, broadcastWallAdd = -58, broadcastWallRemove = -59, broadcastWallModify = -60, broadcastWallMoveLayer = -61, broadcastMusicPackAdd = -62, broadcastMusicPackRename = -63, broadcastMusicPackRemove = -64, broadcastMusicPackCopy = -65, broadcastMusicPackVolume = -66, broadcastMusicPackPlay = -67, broadcastMusicPackStop = -68, broadcastMusicPackStopAll = -69, broadcastMusicPackTrackAdd = -70, broadcastMusicPackTrackRemove = -71, broadcastMusicPackTrackVolume = -72, broadcastMusicPackTrackRepeat = -73, broadcastPluginChange = -74, broadcastPluginSettingChange = -75, broadcastWindow = -76, broadcastSignalMeasure = -77, broadcastSignalPosition = -78, broadcastSignalMovePosition = -79, broadcastAny = -80;
+const broadcastIsAdmin = -1, broadcastCurrentUserMap = -2, broadcastCurrentUserMapData = -3, broadcastMapDataSet = -4, broadcastMapDataRemove = -5, broadcastMapStartChange = -6, broadcastImageItemAdd = -7, broadcastAudioItemAdd = -8, broadcastCharacterItemAdd = -9, broadcastMapItemAdd = -10, broadcastImageItemMove = -11, broadcastAudioItemMove = -12, broadcastCharacterItemMove = -13, broadcastMapItemMove = -14, broadcastImageItemRemove = -15, broadcastAudioItemRemove = -16, broadcastCharacterItemRemove = -17, broadcastMapItemRemove = -18, broadcastImageItemCopy = -19, broadcastAudioItemCopy = -20, broadcastCharacterItemCopy = -21, broadcastMapItemCopy = -22, broadcastImageFolderAdd = -23, broadcastAudioFolderAdd = -24, broadcastCharacterFolderAdd = -25, broadcastMapFolderAdd = -26, broadcastImageFolderMove = -27, broadcastAudioFolderMove = -28, broadcastCharacterFolderMove = -29, broadcastMapFolderMove = -30, broadcastImageFolderRemove = -31, broadcastAudioFolderRemove = -32, broadcastCharacterFolderRemove = -33, broadcastMapFolderRemove = -34, broadcastMapItemChange = -35, broadcastCharacterDataChange = -36, broadcastLayerAdd = -37, broadcastLayerFolderAdd = -38, broadcastLayerMove = -39, broadcastLayerRename = -40, broadcastLayerRemove = -41, broadcastGridDistanceChange = -42, broadcastGridDiagonalChange = -43, broadcastMapLightChange = -44, broadcastLayerShow = -45, broadcastLayerHide = -46, broadcastLayerLock = -47, broadcastLayerUnlock = -48, broadcastMaskAdd = -49, broadcastMaskRemove = -50, broadcastMaskSet = -51, broadcastTokenAdd = -52, broadcastTokenRemove = -53, broadcastTokenMoveLayerPos = -54, broadcastTokenSet = -55, broadcastTokenSetMulti = -56, broadcastLayerShift = -57, broadcastWallAdd = -58, broadcastWallRemove = -59, broadcastWallModify = -60, broadcastWallMoveLayer = -61, broadcastMusicPackAdd = -62, broadcastMusicPackRename = -63, broadcastMusicPackRemove = -64, broadcastMusicPackCopy = -65, broadcastMusicPackVolume = -66, broadcastMusicPackPlay = -67, broadcastMusicPackStop = -68, broadcastMusicPackStopAll = -69, broadcastMusicPackTrackAdd = -70, broadcastMusicPackTrackRemove = -71, broadcastMusicPackTrackVolume = -72, broadcastMusicPackTrackRepeat = -73, broadcastPluginChange = -74, broadcastPluginSettingChange = -75, broadcastWindow = -76, broadcastSignalMeasure = -77, broadcastSignalPosition = -78, broadcastSignalMovePosition = -79, broadcastAny = -80, broadcastImageFolderBulk = -81, broadcastAudioFolderBulk = -82, broadcastCharacterFolderBulk = -83, broadcastMapFolderBulk = -84, broadcastImageFolderOrder = -85, broadcastAudioFolderOrder = -86, broadcastCharacterFolderOrder = -87, broadcastMapFolderOrder = -88, broadcastFileItemAdd = -89, broadcastFileItemMove = -90, broadcastFileItemRemove = -91, broadcastFileItemCopy = -92, broadcastFileFolderAdd = -93, broadcastFileFolderMove = -94, broadcastFileFolderRemove = -95, broadcastFileFolderBulk = -96, broadcastFileFolderOrder = -97, broadcastFileShare = -98, broadcastFileShared = -99, broadcastFileUnshared = -100, broadcastCharacterSchemaSet = -101, broadcastCharacterSchemaRemove = -102, broadcastCharacterOwners = -103, broadcastCharacterOwned = -104, broadcastCharacterUnowned = -105;
 
 type WaitersOf<T> = {[K in keyof T as K extends `wait${string}` ? K : never]: T[K]}
 
@@ -39,12 +39,18 @@ export const handleError = (e: Error | string | Binding) => {
 		audio: {[K in keyof FolderWaiters]: FolderWaiters[K]};
 		characters: {[K in keyof FolderWaiters]: FolderWaiters[K]};
 		maps: {[K in keyof FolderWaiters]: FolderWaiters[K]};
+		files: {[K in keyof FolderWaiters]: FolderWaiters[K]};
 	}
 
 	const isVoid = Void(),
 	      isMusicPacks = Arr(isMusicPack),
 	      isPlugins = Rec(isStr, isPlugin),
 	      isCopy = And(isIDName, Obj({"newID": isUint})),
+	      isFileShares = Arr(isFileShare),
+	      isCharacterSchemas = Rec(isStr, isUnknown),
+	      isCharacterOwnersList = Arr(isCharacterOwners),
+	      isCharacterKeysList = Arr(isCharacterKeys),
+	      isCharacterHistoryList = Arr(isCharacterHistory),
 	      isCopied = Obj({"oldID": isUint, "newID": isUint, "path": isStr}),
 	      isSignalMeasure = Tuple(isUint, isUint, isUint, isUint, ...isUint),
 	      isSignalPosition = Tuple(isUint, isUint),
@@ -52,13 +58,15 @@ export const handleError = (e: Error | string | Binding) => {
 		      "images": {},
 		      "audio": {},
 		      "characters": {},
-		      "maps": {}
+		      "maps": {},
+		      "files": {}
 	      },
 	      combined = {
 		      "images": {},
 		      "audio": {},
 		      "characters": {},
-		      "maps": {}
+		      "maps": {},
+		      "files": {}
 	      },
 	      ep = <const Args extends any[], T extends any, const ArgNames extends string[] = ArgTuple<Args["length"]>>(endpoint: string, args: ArgNames, typeguard: TypeGuard<T>, waiter?: `wait${string}`, modFn?: (params: any, ret: T) => any, on: any = internal) => {
 		const [sub, sFn] = Subscription.bind(1);
@@ -91,7 +99,7 @@ export const handleError = (e: Error | string | Binding) => {
 		return fn;
 	      },
 	      modTo = (p: any, to: string) => (p["to"] = to, p),
-	      folderEPs = (prefix: keyof typeof internal, added: number, moved: number, removed: number, copied: number, folderAdded: number, folderMoved: number, folderRemove: number) => Object.freeze({
+	      folderEPs = (prefix: keyof typeof internal, added: number, moved: number, removed: number, copied: number, folderAdded: number, folderMoved: number, folderRemove: number, bulk: number, order: number) => Object.freeze({
 		"list":         ep<[], FolderItems>         (`${prefix}.list`,         [],             isFolderItems),
 		"createFolder": ep<[string], string>        (`${prefix}.createFolder`, [""],           isStr,    "waitFolderAdded",   undefined, internal[prefix]),
 		"move":         ep<[string, string], string>(`${prefix}.move`,         ["from", "to"], isStr,    "waitMoved",         modTo,     internal[prefix]),
@@ -99,6 +107,10 @@ export const handleError = (e: Error | string | Binding) => {
 		"remove":       ep<[string],         void>  (`${prefix}.remove`,       [""],           isVoid,   "waitRemoved",       undefined, internal[prefix]),
 		"removeFolder": ep<[string],         void>  (`${prefix}.removeFolder`, [""],           isVoid,   "waitFolderRemoved", undefined, internal[prefix]),
 		"copy":         ep<[number, string], IDPath>(`${prefix}.copy`,         ["id", "path"], isIDPath, "waitCopied",        undefined, internal[prefix]),
+		"bulk":         ep<[FolderBulk], FolderBulk>(`${prefix}.bulk`,         [""],           isFolderBulk, "waitBulk",      undefined, internal[prefix]),
+		"rename":       ep<[string, string], string>(`${prefix}.rename`,       ["from", "to"], isStr,    "waitMoved",         modTo,     internal[prefix]),
+		"renameFolder": ep<[string, string], string>(`${prefix}.renameFolder`, ["from", "to"], isStr,    "waitFolderMoved",   modTo,     internal[prefix]),
+		"setOrder":     ep<[FolderOrder], void>     (`${prefix}.setOrder`,     [""],           isVoid,   "waitOrder",         undefined, internal[prefix]),
 
 		"waitAdded":         w(added,         isArrIDName, "waitAdded",         combined[prefix], internal[prefix]),
 		"waitMoved":         w(moved,         isFromTo,    "waitMoved",         combined[prefix], internal[prefix]),
@@ -106,7 +118,9 @@ export const handleError = (e: Error | string | Binding) => {
 		"waitCopied":        w(copied,        isCopied,    "waitCopied",        combined[prefix], internal[prefix]),
 		"waitFolderAdded":   w(folderAdded,   isStr,       "waitFolderAdded",   combined[prefix], internal[prefix]),
 		"waitFolderMoved":   w(folderMoved,   isFromTo,    "waitFolderMoved",   combined[prefix], internal[prefix]),
-		"waitFolderRemoved": w(folderRemove,  isStr,       "waitFolderRemoved", combined[prefix], internal[prefix])
+		"waitFolderRemoved": w(folderRemove,  isStr,       "waitFolderRemoved", combined[prefix], internal[prefix]),
+		"waitBulk":          w(bulk,          isFolderBulk, "waitBulk",         combined[prefix], internal[prefix]),
+		"waitOrder":         w(order,         isFolderOrder, "waitOrder",       combined[prefix], internal[prefix])
 	      }),
 	      rpc = {
 		"ready": ep<[], void>("conn.ready", [], isVoid),
@@ -170,6 +184,17 @@ export const handleError = (e: Error | string | Binding) => {
 		"characterModify": ep<[number, Keystore, string[]], void>    ("character.modify", ["id", "setting", "removing"], isVoid, "waitCharacterDataChange"),
 		"characterGet":    ep<[number],                     Keystore>("character.get",    [""],                          isKeystore),
 
+		"characterSchemas":      ep<[],                Record<string, unknown>>("characters.schemas",      [],                isCharacterSchemas),
+		"setCharacterSchema":    ep<[string, unknown], void>                   ("characters.setSchema",    ["key", "schema"], isVoid, "waitCharacterSchemaSet"),
+		"removeCharacterSchema": ep<[string],          void>                   ("characters.removeSchema", [""],              isVoid, "waitCharacterSchemaRemove"),
+
+		"characterOwners":    ep<[],                           CharacterOwners[]>("characters.owners",    [],                        isCharacterOwnersList),
+		"setCharacterOwners": ep<[number, string[], string[]], void>             ("characters.setOwners", ["id", "players", "keys"], isVoid),
+		"ownedCharacters":    ep<[],                           CharacterKeys[]>  ("characters.owned",     [],                        isCharacterKeysList),
+
+		"characterHistory": ep<[number],         CharacterHistory[]> ("characters.history", [""],            isCharacterHistoryList),
+		"characterRevert":  ep<[number, number], CharacterDataChange>("characters.revert",  ["id", "entry"], isCharacterDataChange),
+
 		"listPlugins":   ep<[],                           Record<string, Plugin>>("plugins.list",   [],                            isPlugins),
 		"enablePlugin":  ep<[string],                     void>                  ("plugin.enable",  [""],                          isVoid),
 		"disablePlugin": ep<[string],                     void>                  ("plugin.disable", [""],                          isVoid),
@@ -178,10 +203,16 @@ export const handleError = (e: Error | string | Binding) => {
 		"broadcastWindow": ep<[string, number, string], void>("broadcastWindow", ["module", "id", "contents"], isVoid, "waitBroadcastWindow"),
 		"broadcast":       ep<[Broadcast],              void>("broadcast",       [""],                         isVoid, "waitBroadcast"),
 
-		"images": folderEPs("images", broadcastImageItemAdd, broadcastImageItemMove, broadcastImageItemRemove, broadcastImageItemCopy, broadcastImageFolderAdd, broadcastImageFolderMove, broadcastImageFolderRemove),
-		"audio": folderEPs("audio", broadcastAudioItemAdd, broadcastAudioItemMove, broadcastAudioItemRemove, broadcastAudioItemCopy, broadcastAudioFolderAdd, broadcastAudioFolderMove, broadcastAudioFolderRemove),
-		"maps": folderEPs("maps", broadcastMapItemAdd, broadcastMapItemMove, broadcastMapItemRemove, broadcastMapItemCopy, broadcastMapFolderAdd, broadcastMapFolderMove, broadcastMapFolderRemove),
-		"characters": folderEPs("characters", broadcastCharacterItemAdd, broadcastCharacterItemMove, broadcastCharacterItemRemove, broadcastCharacterItemCopy, broadcastCharacterFolderAdd, broadcastCharacterFolderMove, broadcastCharacterFolderRemove),
+		"images": folderEPs("images", broadcastImageItemAdd, broadcastImageItemMove, broadcastImageItemRemove, broadcastImageItemCopy, broadcastImageFolderAdd, broadcastImageFolderMove, broadcastImageFolderRemove, broadcastImageFolderBulk, broadcastImageFolderOrder),
+		"audio": folderEPs("audio", broadcastAudioItemAdd, broadcastAudioItemMove, broadcastAudioItemRemove, broadcastAudioItemCopy, broadcastAudioFolderAdd, broadcastAudioFolderMove, broadcastAudioFolderRemove, broadcastAudioFolderBulk, broadcastAudioFolderOrder),
+		"maps": folderEPs("maps", broadcastMapItemAdd, broadcastMapItemMove, broadcastMapItemRemove, broadcastMapItemCopy, broadcastMapFolderAdd, broadcastMapFolderMove, broadcastMapFolderRemove, broadcastMapFolderBulk, broadcastMapFolderOrder),
+		"characters": folderEPs("characters", broadcastCharacterItemAdd, broadcastCharacterItemMove, broadcastCharacterItemRemove, broadcastCharacterItemCopy, broadcastCharacterFolderAdd, broadcastCharacterFolderMove, broadcastCharacterFolderRemove, broadcastCharacterFolderBulk, broadcastCharacterFolderOrder),
+		"files": folderEPs("files", broadcastFileItemAdd, broadcastFileItemMove, broadcastFileItemRemove, broadcastFileItemCopy, broadcastFileFolderAdd, broadcastFileFolderMove, broadcastFileFolderRemove, broadcastFileFolderBulk, broadcastFileFolderOrder),
+
+		"sharedFiles": ep<[],                 IDName[]>   ("files.shared",  [],              isArrIDName),
+		"fileShares":  ep<[],                 FileShare[]>("files.shares",  [],              isFileShares),
+		"shareFile":   ep<[number, string[]], void>       ("files.share",   ["id", "players"], isVoid),
+		"unshareFile": ep<[number],           void>       ("files.unshare", [""],             isVoid),
 
 		"waitCurrentUserMap":       w(broadcastCurrentUserMap,       isUint,                 "waitCurrentUserMap"),
 		"waitCurrentUserMapData":   w(broadcastCurrentUserMapData,   isMapData,              "waitCurrentUserMapData"),
@@ -233,7 +264,15 @@ export const handleError = (e: Error | string | Binding) => {
 		"waitSignalPosition":       w(broadcastSignalPosition,       isSignalPosition,       "waitSignalPosition"),
 		"waitSignalMovePosition":   w(broadcastSignalMovePosition,   isSignalPosition,       "waitSignalMovePosition"),
 		"waitBroadcastWindow":      w(broadcastWindow,               isBroadcastWindow,      "waitBroadcastWindow"),
-		"waitBroadcast":            w(broadcastAny,                  isBroadcast,            "waitBroadcast")
+		"waitBroadcast":            w(broadcastAny,                  isBroadcast,            "waitBroadcast"),
+		"waitFileShare":            w(broadcastFileShare,            isFileShare,            "waitFileShare"),
+		"waitFileShared":           w(broadcastFileShared,           isIDName,               "waitFileShared"),
+		"waitFileUnshared":         w(broadcastFileUnshared,         isUint,                 "waitFileUnshared"),
+		"waitCharacterSchemaSet":    w(broadcastCharacterSchemaSet,    isCharacterSchema,      "waitCharacterSchemaSet"),
+		"waitCharacterSchemaRemove": w(broadcastCharacterSchemaRemove, isStr,                  "waitCharacterSchemaRemove"),
+		"waitCharacterOwners":       w(broadcastCharacterOwners,       isCharacterOwners,      "waitCharacterOwners"),
+		"waitCharacterOwned":        w(broadcastCharacterOwned,        isCharacterKeys,        "waitCharacterOwned"),
+		"waitCharacterUnowned":      w(broadcastCharacterUnowned,      isUint,                 "waitCharacterUnowned")
 	      };
 
 	return [Object.freeze(rpc), Object.freeze(internal as {[K in keyof InternalWaiters]: InternalWaiters[K]}), Object.freeze(combined as {[K in keyof InternalWaiters]: InternalWaiters[K]})] as const;
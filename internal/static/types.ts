@@ -65,7 +65,12 @@ isTokenImage = And(isTokenShared, Obj({
 	patternWidth: isUint,
 	patternHeight: isUint,
 	flip: isBool,
-	flop: isBool
+	flop: isBool,
+	frameWidth: Opt(isUint),
+	frameHeight: Opt(isUint),
+	frameCount: Opt(isUint),
+	frameRate: Opt(isUint),
+	loopMode: Opt(isByte)
 })),
 isTokenShape = And(isTokenShared, Obj({
 	fill: isColour,
@@ -98,7 +103,9 @@ isLayerTokens = And(isIDName, Obj({
 })),
 isFolderItems: TypeGuard<FolderItems> = Obj({
 	folder: Rec(isStr, Recur(() => isFolderItems)),
-	items: Rec(isStr, isUint)
+	items: Rec(isStr, isUint),
+	folderOrder: Opt(Arr(isStr)),
+	itemOrder: Opt(Arr(isStr))
 }),
 isLayerFolder: TypeGuard<LayerFolder> = And(isFolderItems, isIDName, Obj({
 	hidden: isBool,
@@ -223,6 +230,47 @@ isIDPath = And(isID, Obj({
 	path: isStr
 })),
 isMapStart = Tuple(isUint, isUint),
+isFolderBulk = Arr(Obj({
+	op: Or(Val("createFolder"), Val("move"), Val("moveFolder"), Val("remove"), Val("removeFolder")),
+	path: Opt(isStr),
+	from: Opt(isStr),
+	to: Opt(isStr)
+})),
+isFolderOrder = Obj({
+	path: isStr,
+	folders: Arr(isStr),
+	items: Arr(isStr)
+}),
+isFileShare = Obj({
+	id: isUint,
+	all: isBool,
+	players: Arr(isStr)
+}),
+isCharacterSchema = Obj({
+	key: isStr,
+	schema: isUnknown
+}),
+isCharacterOwners = Obj({
+	id: isUint,
+	players: Arr(isStr),
+	keys: Arr(isStr)
+}),
+isCharacterKeys = Obj({
+	id: isUint,
+	keys: Arr(isStr)
+}),
+isCharacterHistoryValue = Or(Val(null), isKeystoreData),
+isCharacterHistory = Obj({
+	id: isUint,
+	time: isUint,
+	admin: isBool,
+	player: isStr,
+	changes: Arr(Obj({
+		key: isStr,
+		old: isCharacterHistoryValue,
+		new: isCharacterHistoryValue
+	}))
+}),
 isCopy = Obj({
 	oldID: isUint,
 	newID: isUint,
@@ -271,6 +319,8 @@ export type LayerTokens = TypeGuardOf<typeof isLayerTokens>;
 export type FolderItems = {
 	folders: Record<string, FolderItems>;
 	items:  Record<string, Uint>;
+	folderOrder?: string[];
+	itemOrder?: string[];
 }
 
 export type LayerFolder = FolderItems & IDName & {
@@ -338,4 +388,18 @@ export type MusicPackTrackRepeat = TypeGuardOf<typeof isMusicPackTrackRepeat>;
 
 export type IDPath = TypeGuardOf<typeof isIDPath>;
 
+export type FolderBulk = TypeGuardOf<typeof isFolderBulk>;
+
+export type FolderOrder = TypeGuardOf<typeof isFolderOrder>;
+
+export type FileShare = TypeGuardOf<typeof isFileShare>;
+
+export type CharacterSchema = TypeGuardOf<typeof isCharacterSchema>;
+
+export type CharacterOwners = TypeGuardOf<typeof isCharacterOwners>;
+
+export type CharacterKeys = TypeGuardOf<typeof isCharacterKeys>;
+
+export type CharacterHistory = TypeGuardOf<typeof isCharacterHistory>;
+
 export type MapStart = TypeGuardOf<typeof isMapStart>;
@@ -2,7 +2,10 @@ package battlemap
 
 import (
 	"encoding/json"
+	"io"
 	"strings"
+
+	"vimagination.zapto.org/byteio"
 )
 
 type links struct {
@@ -19,20 +22,114 @@ func newLinks() links {
 	}
 }
 
-func (l *links) getLinkKey(key string) linkManager {
+type linkType uint8
+
+const (
+	linkNone linkType = iota
+	linkImage
+	linkAudio
+	linkChar
+	linkMusic
+)
+
+func getLinkType(key string) linkType {
 	if strings.HasPrefix(key, "store-image") {
-		return l.images
+		return linkImage
 	} else if strings.HasPrefix(key, "store-audio") {
-		return l.audio
+		return linkAudio
 	} else if strings.HasPrefix(key, "store-character") {
-		return l.chars
+		return linkChar
 	} else if strings.HasPrefix(key, "store-music") {
+		return linkMusic
+	}
+
+	return linkNone
+}
+
+func (l *links) getLinkKey(key string) linkManager {
+	return l.getLinkType(getLinkType(key))
+}
+
+func (l *links) getLinkType(t linkType) linkManager {
+	switch t {
+	case linkImage:
+		return l.images
+	case linkAudio:
+		return l.audio
+	case linkChar:
+		return l.chars
+	case linkMusic:
 		return l.music
 	}
 
 	return nil
 }
 
+type reference struct {
+	Type linkType
+	ID   uint64
+}
+
+func (l *links) references() []reference {
+	var refs []reference
+
+	for _, t := range [...]linkType{linkImage, linkAudio, linkChar, linkMusic} {
+		for id := range l.getLinkType(t) {
+			refs = append(refs, reference{Type: t, ID: id})
+		}
+	}
+
+	return refs
+}
+
+func (l *links) setReferences(refs []reference) {
+	for _, ref := range refs {
+		if lm := l.getLinkType(ref.Type); lm != nil {
+			lm.setLink(ref.ID)
+		}
+	}
+}
+
+type referenceIndex map[uint64][]reference
+
+func (r referenceIndex) ReadFrom(rd io.Reader) (int64, error) {
+	lr := byteio.StickyLittleEndianReader{Reader: rd}
+
+	for l := lr.ReadUintX(); l > 0; l-- {
+		id := lr.ReadUintX()
+		refs := make([]reference, lr.ReadUintX())
+
+		for n := range refs {
+			refs[n] = reference{
+				Type: linkType(lr.ReadUint8()),
+				ID:   lr.ReadUintX(),
+			}
+		}
+
+		r[id] = refs
+	}
+
+	return lr.Count, lr.Err
+}
+
+func (r referenceIndex) WriteTo(w io.Writer) (int64, error) {
+	lw := byteio.StickyLittleEndianWriter{Writer: w}
+
+	lw.WriteUintX(uint64(len(r)))
+
+	for id, refs := range r {
+		lw.WriteUintX(id)
+		lw.WriteUintX(uint64(len(refs)))
+
+		for _, ref := range refs {
+			lw.WriteUint8(uint8(ref.Type))
+			lw.WriteUintX(ref.ID)
+		}
+	}
+
+	return lw.Count, lw.Err
+}
+
 type linkManager map[uint64]struct{}
 
 func (l linkManager) setLink(id uint64) {
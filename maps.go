@@ -1,30 +1,67 @@
 package battlemap
 
 import (
+	"container/list"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"vimagination.zapto.org/keystore"
+	"vimagination.zapto.org/memio"
 )
 
 type mapsDir struct {
 	folders
-	maps    map[uint64]*levelMap
-	handler http.Handler
+	dir string
+
+	cacheMu   sync.Mutex
+	maps      map[uint64]*list.Element
+	lru       list.List
+	cacheSize uint64
+	refs      referenceIndex
+
+	queued []mapBroadcast
+
+	flushMu   sync.Mutex
+	dirty     map[uint64]struct{}
+	refsDirty bool
+	done      chan struct{}
+	stopped   chan struct{}
+}
+
+type cachedMap struct {
+	id uint64
+	*levelMap
 }
 
 func (m *mapsDir) Init(b *Battlemap, links links) error {
-	var location keystore.String
+	var (
+		location      keystore.String
+		cacheSize     keystore.Uint64
+		flushInterval keystore.Uint64
+	)
 
 	if err := b.config.Get("MapsDir", &location); err != nil {
 		return fmt.Errorf("error getting map directory: %w", err)
 	}
 
+	if err := b.config.Get("MapCacheSize", &cacheSize); err != nil {
+		return fmt.Errorf("error getting map cache size: %w", err)
+	}
+
+	if err := b.config.Get("MapFlushInterval", &flushInterval); err != nil {
+		return fmt.Errorf("error getting map flush interval: %w", err)
+	}
+
 	sp := filepath.Join(b.config.BaseDir, string(location))
+	m.dir = sp
 
 	store, err := keystore.NewFileStore(sp, sp, keystore.NoMangle)
 	if err != nil {
@@ -37,42 +74,326 @@ func (m *mapsDir) Init(b *Battlemap, links links) error {
 		return fmt.Errorf("error parsing maps keystore folders: %w", err)
 	}
 
-	m.maps = make(map[uint64]*levelMap, len(links.maps))
+	if err := m.initQuota("Maps"); err != nil {
+		return err
+	}
 
-	for id := range links.maps {
-		key := strconv.FormatUint(id, 10)
-		mp := new(levelMap)
+	m.pruneTags()
 
-		if err = m.Get(key, mp); err != nil {
-			return fmt.Errorf("error reading map data (%q): %w", key, err)
+	m.maps = make(map[uint64]*list.Element)
+	m.cacheSize = max(uint64(cacheSize), 1)
+	m.refs = make(referenceIndex)
+
+	var indexTime time.Time
+
+	if fi, err := m.Stat(mapReferences); err == nil {
+		if err := m.Get(mapReferences, m.refs); err != nil {
+			clear(m.refs)
+		} else {
+			indexTime = fi.ModTime()
 		}
+	}
 
-		for key, value := range mp.Data {
-			if f := links.getLinkKey(key); f != nil {
-				f.setJSONLinks(value)
-			}
+	changed := false
+
+	for id := range m.refs {
+		if _, ok := links.maps[id]; !ok {
+			delete(m.refs, id)
+
+			changed = true
 		}
+	}
+
+	for id := range links.maps {
+		key := strconv.FormatUint(id, 10)
+		refs, ok := m.refs[id]
 
-		for _, t := range mp.tokens {
-			if t.Source > 0 {
-				links.images.setLink(t.Source)
+		if fi, err := m.Stat(key); !ok || err != nil || fi.ModTime().After(indexTime) {
+			mp := new(levelMap)
+
+			if err = m.Get(key, mp); err != nil {
+				return fmt.Errorf("error reading map data (%q): %w", key, err)
 			}
 
-			for key, value := range t.TokenData {
-				if f := links.getLinkKey(key); f != nil {
-					f.setJSONLinks(value.Data)
+			if mp.legacy {
+				if err = m.writeMap(id, mp); err != nil {
+					return fmt.Errorf("error migrating map data (%q): %w", key, err)
 				}
 			}
+
+			refs = mp.references()
+			m.refs[id] = refs
+			changed = true
 		}
 
-		m.maps[id] = mp
+		links.setReferences(refs)
+	}
+
+	if changed {
+		if err := m.Set(mapReferences, m.refs); err != nil {
+			return fmt.Errorf("error writing map reference index: %w", err)
+		}
 	}
 
-	m.handler = http.FileServer(http.Dir(sp))
+	m.dirty = make(map[uint64]struct{})
+	m.done = make(chan struct{})
+	m.stopped = make(chan struct{})
+
+	go m.flushLoop(time.Duration(max(flushInterval, 1)) * time.Second)
 
 	return nil
 }
 
+func (l *levelMap) references() []reference {
+	links := newLinks()
+
+	for key, value := range l.Data {
+		if f := links.getLinkKey(key); f != nil {
+			f.setJSONLinks(value)
+		}
+	}
+
+	for _, t := range l.tokens {
+		if t.Source > 0 {
+			links.images.setLink(t.Source)
+		}
+
+		for key, value := range t.TokenData {
+			if f := links.getLinkKey(key); f != nil {
+				f.setJSONLinks(value.Data)
+			}
+		}
+	}
+
+	return links.references()
+}
+
+// getMap returns the map with the given ID, loading it into the cache if it is
+// not already resident.
+//
+// The caller must hold m.mu, either for reading or writing.
+func (m *mapsDir) getMap(id uint64) (*levelMap, error) {
+	m.cacheMu.Lock()
+	defer m.cacheMu.Unlock()
+
+	if e, ok := m.maps[id]; ok {
+		m.lru.MoveToFront(e)
+
+		return e.Value.(cachedMap).levelMap, nil
+	}
+
+	if _, ok := m.refs[id]; !ok {
+		return nil, ErrUnknownMap
+	}
+
+	mp := new(levelMap)
+
+	if err := m.Get(strconv.FormatUint(id, 10), mp); err != nil {
+		return nil, fmt.Errorf("error reading map data (%d): %w", id, err)
+	}
+
+	if mp.legacy && m.writeMap(id, mp) == nil {
+		mp.legacy = false
+	}
+
+	m.cacheMapLocked(id, mp)
+
+	return mp, nil
+}
+
+// peekMap returns the map with the given ID, as getMap does, but without
+// adding it to, or moving it within, the cache, so that reading many maps does
+// not evict those in use.
+//
+// The returned map must not be modified.
+func (m *mapsDir) peekMap(id uint64) (*levelMap, error) {
+	m.cacheMu.Lock()
+	defer m.cacheMu.Unlock()
+
+	if e, ok := m.maps[id]; ok {
+		return e.Value.(cachedMap).levelMap, nil
+	}
+
+	if _, ok := m.refs[id]; !ok {
+		return nil, ErrUnknownMap
+	}
+
+	mp := new(levelMap)
+
+	if err := m.Get(strconv.FormatUint(id, 10), mp); err != nil {
+		return nil, fmt.Errorf("error reading map data (%d): %w", id, err)
+	}
+
+	return mp, nil
+}
+
+// cacheMap adds a newly created map to the cache, evicting the least recently
+// used maps when the cache is full.
+//
+// The caller must hold m.mu for writing.
+func (m *mapsDir) cacheMap(id uint64, mp *levelMap) {
+	m.cacheMu.Lock()
+	m.cacheMapLocked(id, mp)
+	m.cacheMu.Unlock()
+}
+
+func (m *mapsDir) cacheMapLocked(id uint64, mp *levelMap) {
+	if e, ok := m.maps[id]; ok {
+		e.Value = cachedMap{id, mp}
+
+		m.lru.MoveToFront(e)
+
+		return
+	}
+
+	m.maps[id] = m.lru.PushFront(cachedMap{id, mp})
+
+	for e := m.lru.Back(); e != nil && uint64(m.lru.Len()) > m.cacheSize; {
+		prev := e.Prev()
+
+		if id := e.Value.(cachedMap).id; !m.isDirty(id) {
+			m.lru.Remove(e)
+			delete(m.maps, id)
+		}
+
+		e = prev
+	}
+}
+
+func (m *mapsDir) isDirty(id uint64) bool {
+	_, ok := m.dirty[id]
+
+	return ok
+}
+
+// setReferences updates the persisted reference index for the given map.
+//
+// The caller must hold m.mu for writing.
+func (m *mapsDir) setReferences(id uint64, refs []reference) {
+	if old, ok := m.refs[id]; ok && slices.Equal(old, refs) {
+		return
+	}
+
+	m.refs[id] = refs
+	m.refsDirty = true
+
+	m.visible.invalidate()
+}
+
+// writeMap immediately, and atomically, writes the given map data to disk.
+func (m *mapsDir) writeMap(id uint64, data io.WriterTo) error {
+	err := writeFileAtomic(m.dir, strconv.FormatUint(id, 10), data)
+
+	m.updateUsage(id)
+
+	return err
+}
+
+func (m *mapsDir) flushLoop(interval time.Duration) {
+	t := time.NewTicker(interval)
+
+	defer close(m.stopped)
+
+	for {
+		select {
+		case <-t.C:
+			m.flush()
+		case <-m.done:
+			t.Stop()
+
+			return
+		}
+	}
+}
+
+// flush writes all maps that have been modified since the last flush, along
+// with the reference index if it has changed.
+//
+// Maps that fail to be written remain marked as dirty, and will be retried on
+// the next flush.
+func (m *mapsDir) flush() error {
+	m.flushMu.Lock()
+	defer m.flushMu.Unlock()
+
+	type mapData struct {
+		id   uint64
+		data memio.Buffer
+	}
+
+	var (
+		toWrite []mapData
+		refs    memio.Buffer
+		err     error
+	)
+
+	m.mu.Lock()
+
+	for id := range m.dirty {
+		if e, ok := m.maps[id]; ok {
+			var buf memio.Buffer
+
+			e.Value.(cachedMap).WriteTo(&buf)
+
+			toWrite = append(toWrite, mapData{id, buf})
+		}
+	}
+
+	clear(m.dirty)
+
+	if m.refsDirty {
+		m.refs.WriteTo(&refs)
+
+		m.refsDirty = false
+	}
+
+	m.mu.Unlock()
+
+	var failed []uint64
+
+	for _, md := range toWrite {
+		if errr := m.writeMap(md.id, &md.data); errr != nil {
+			failed = append(failed, md.id)
+			err = fmt.Errorf("error writing map %d: %w", md.id, errr)
+		}
+	}
+
+	if len(failed) > 0 {
+		m.mu.Lock()
+
+		for _, id := range failed {
+			m.dirty[id] = struct{}{}
+		}
+
+		m.refsDirty = m.refsDirty || refs != nil
+
+		m.mu.Unlock()
+	} else if refs != nil {
+		if errr := writeFileAtomic(m.dir, mapReferences, &refs); errr != nil {
+			m.mu.Lock()
+			m.refsDirty = true
+			m.mu.Unlock()
+
+			err = fmt.Errorf("error writing map reference index: %w", errr)
+		}
+	}
+
+	return err
+}
+
+// close stops the background flushing and writes any outstanding changes.
+func (m *mapsDir) close() error {
+	if m.done == nil {
+		return nil
+	}
+
+	close(m.done)
+	<-m.stopped
+
+	m.done = nil
+
+	return m.flush()
+}
+
 type mapDetails struct {
 	ID   uint64 `json:"id,omitempty"`
 	Name string `json:"name"`
@@ -134,14 +455,22 @@ func (m *mapsDir) newMap(nm mapDetails, id ID) (json.RawMessage, error) {
 		walls:  make(map[uint64]layerWall),
 		Data:   make(map[string]json.RawMessage),
 	}
+
+	if err := m.checkSpace("", encodedSize(mp)); err != nil {
+		m.mu.Unlock()
+
+		return nil, err
+	}
+
 	name := addItemTo(m.folders.root.Items, nm.Name, mid)
-	m.maps[mid] = mp
 
+	mp.writeJSON()
+	m.writeMap(mid, mp)
+	m.cacheMap(mid, mp)
+	m.setReferences(mid, nil)
 	m.saveFolders()
 	m.mu.Unlock()
 
-	m.Set(strconv.FormatUint(mid, 10), mp)
-
 	buf := append(appendString(append(strconv.AppendUint(append(json.RawMessage{}, "[{\"id\":"...), mid, 10), ",\"name\":"...), name), '}', ']')
 
 	m.socket.broadcastAdminChange(broadcastMapItemAdd, buf, id)
@@ -149,18 +478,75 @@ func (m *mapsDir) newMap(nm mapDetails, id ID) (json.RawMessage, error) {
 	return buf[1 : len(buf)-1], nil
 }
 
+type mapBroadcast struct {
+	cd   ConnData
+	id   int
+	data json.RawMessage
+	user userStatus
+}
+
+// broadcastMapChange queues a broadcast of a change made by a function given to
+// updateMapData, which will be sent once the change has been accepted.
+//
+// The caller must hold m.mu for writing.
+func (m *mapsDir) broadcastMapChange(cd ConnData, id int, data json.RawMessage, user userStatus) {
+	m.queued = append(m.queued, mapBroadcast{cd, id, append(json.RawMessage{}, data...), user})
+}
+
+// updateMapData applies the given function to the map with the given ID,
+// marking the map as dirty if the function returns true.
+//
+// When the maps store has size limits, a change that would exceed them is
+// reverted and an error returned; any broadcasts queued by the function are
+// only sent when the change is kept.
 func (m *mapsDir) updateMapData(id uint64, fn func(*levelMap) bool) error {
 	m.mu.Lock()
+	defer m.mu.Unlock()
 
-	mp, ok := m.maps[id]
-	if ok && fn(mp) {
-		m.Set(strconv.FormatUint(id, 10), mp)
+	mp, err := m.getMap(id)
+	if err != nil {
+		return err
 	}
 
-	m.mu.Unlock()
+	var snapshot memio.Buffer
+
+	limited := m.quota.limited()
+
+	if limited {
+		mp.WriteTo(&snapshot)
+	}
 
-	if !ok {
-		return ErrUnknownMap
+	changed := fn(mp)
+	queued := m.queued
+	m.queued = nil
+
+	if changed {
+		if limited {
+			if err := m.checkSpace(strconv.FormatUint(id, 10), encodedSize(mp)); err != nil {
+				l := new(levelMap)
+
+				l.ReadFrom(&snapshot)
+				m.cacheMap(id, l)
+
+				return err
+			}
+		}
+
+		mp.writeJSON()
+
+		m.dirty[id] = struct{}{}
+
+		m.setReferences(id, mp.references())
+
+		var cu keystore.Uint64
+
+		if m.config.Get("currentUserMap", &cu); uint64(cu) == id {
+			m.visible.invalidate()
+		}
+	}
+
+	for _, b := range queued {
+		m.socket.broadcastMapChange(b.cd, b.id, b.data, b.user)
 	}
 
 	return nil
@@ -217,24 +603,54 @@ func (m *mapsDir) updateMapsLayerToken(mid uint64, id uint64, fn func(*levelMap,
 	return err
 }
 
+// ServeHTTP serves the JSON of a map, with admins able to retrieve any map and
+// users only able to retrieve the user-visible data of the current user map.
 func (m *mapsDir) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if m.auth.IsAdmin(r) {
-		m.mu.RLock()
-		m.handler.ServeHTTP(w, r)
-		m.mu.RUnlock()
-	} else {
+	id, err := strconv.ParseUint(strings.TrimPrefix(r.URL.Path, "/"), 10, 64)
+	if err != nil {
+		http.NotFound(w, r)
+
+		return
+	}
+
+	admin := m.auth.IsAdmin(r)
+
+	if !admin {
 		var currentUserMap keystore.Uint
 
 		m.config.Get("currentUserMap", &currentUserMap)
 
-		if id, _ := strconv.ParseUint(strings.TrimPrefix(r.URL.Path, "/"), 10, 0); id == uint64(currentUserMap) {
-			m.mu.RLock()
-			m.handler.ServeHTTP(w, r)
-			m.mu.RUnlock()
-		} else {
+		if id != uint64(currentUserMap) {
 			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+
+			return
 		}
 	}
+
+	var data []byte
+
+	m.mu.RLock()
+
+	mp, err := m.getMap(id)
+	if err == nil {
+		if admin {
+			data = append(data, mp.JSON...)
+		} else {
+			data = append(data, mp.UserJSON...)
+		}
+	}
+
+	m.mu.RUnlock()
+
+	if err != nil {
+		http.NotFound(w, r)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Write(data)
 }
 
 func uniqueLayer(l map[string]struct{}, name string) string {
@@ -351,3 +767,5 @@ func (l *layer) addToken(tk *token, pos uint) uint {
 
 	return pos
 }
+
+const mapReferences = "references"
@@ -27,12 +27,13 @@ func (m *mapsDir) RPCData(cd ConnData, method string, data json.RawMessage) (int
 		m.mu.RLock()
 		defer m.mu.RUnlock()
 
-		mp, ok := m.maps[uint64(userMap)]
-		if !ok {
-			return nil, ErrUnknownMap
+		mp, err := m.getMap(uint64(userMap))
+		if err != nil {
+			return nil, err
 		}
 
 		m.Battlemap.config.Set("currentUserMap", &userMap)
+		m.visible.invalidate()
 		m.Battlemap.socket.SetCurrentUserMap(uint64(userMap), data, json.RawMessage(mp.JSON), cd.ID)
 
 		return nil, nil
@@ -46,9 +47,9 @@ func (m *mapsDir) RPCData(cd ConnData, method string, data json.RawMessage) (int
 		m.mu.RLock()
 		defer m.mu.RUnlock()
 
-		mp, ok := m.maps[mapID]
-		if !ok {
-			return nil, ErrUnknownMap
+		mp, err := m.getMap(mapID)
+		if err != nil {
+			return nil, err
 		}
 
 		return json.RawMessage(mp.JSON), nil
@@ -94,7 +95,7 @@ func (m *mapsDir) RPCData(cd ConnData, method string, data json.RawMessage) (int
 			mp.GridColour = md.GridColour
 			mp.GridStroke = md.GridStroke
 
-			m.socket.broadcastMapChange(cd, broadcastMapItemChange, data, userAny)
+			m.broadcastMapChange(cd, broadcastMapItemChange, data, userAny)
 
 			return true
 		})
@@ -120,7 +121,7 @@ func (m *mapsDir) RPCData(cd ConnData, method string, data json.RawMessage) (int
 			mp.StartX = ms[0]
 			mp.StartY = ms[1]
 
-			m.socket.broadcastMapChange(cd, broadcastMapStartChange, data, userAny)
+			m.broadcastMapChange(cd, broadcastMapStartChange, data, userAny)
 
 			return true
 		}); errr != nil {
@@ -141,7 +142,7 @@ func (m *mapsDir) RPCData(cd ConnData, method string, data json.RawMessage) (int
 		return nil, m.updateMapData(cd.CurrentMap, func(mp *levelMap) bool {
 			mp.Data[sd.Key] = sd.Data
 
-			m.socket.broadcastMapChange(cd, broadcastMapDataSet, data, userAny)
+			m.broadcastMapChange(cd, broadcastMapDataSet, data, userAny)
 
 			return true
 		})
@@ -154,7 +155,7 @@ func (m *mapsDir) RPCData(cd ConnData, method string, data json.RawMessage) (int
 
 		return nil, m.updateMapData(cd.CurrentMap, func(mp *levelMap) bool {
 			delete(mp.Data, rd)
-			m.socket.broadcastMapChange(cd, broadcastMapDataRemove, data, userAny)
+			m.broadcastMapChange(cd, broadcastMapDataRemove, data, userAny)
 
 			return true
 		})
@@ -172,7 +173,7 @@ func (m *mapsDir) RPCData(cd ConnData, method string, data json.RawMessage) (int
 
 			mp.GridDistance = md
 
-			m.socket.broadcastMapChange(cd, broadcastGridDistanceChange, data, userAny)
+			m.broadcastMapChange(cd, broadcastGridDistanceChange, data, userAny)
 
 			return true
 		})
@@ -190,7 +191,7 @@ func (m *mapsDir) RPCData(cd ConnData, method string, data json.RawMessage) (int
 
 			mp.GridDiagonal = md
 
-			m.socket.broadcastMapChange(cd, broadcastGridDiagonalChange, data, userAny)
+			m.broadcastMapChange(cd, broadcastGridDiagonalChange, data, userAny)
 
 			return true
 		})
@@ -204,7 +205,7 @@ func (m *mapsDir) RPCData(cd ConnData, method string, data json.RawMessage) (int
 		if err := m.updateMapData(cd.CurrentMap, func(mp *levelMap) bool {
 			mp.Light = c
 
-			m.socket.broadcastMapChange(cd, broadcastMapLightChange, data, userAny)
+			m.broadcastMapChange(cd, broadcastMapLightChange, data, userAny)
 
 			return true
 		}); err != nil {
@@ -243,7 +244,7 @@ func (m *mapsDir) RPCData(cd ConnData, method string, data json.RawMessage) (int
 		if err := m.updateMapData(cd.CurrentMap, func(mp *levelMap) bool {
 			mp.Mask = append(mp.Mask, mask)
 
-			m.socket.broadcastMapChange(cd, broadcastMaskAdd, data, userAny)
+			m.broadcastMapChange(cd, broadcastMaskAdd, data, userAny)
 
 			return true
 		}); err != nil {
@@ -270,7 +271,7 @@ func (m *mapsDir) RPCData(cd ConnData, method string, data json.RawMessage) (int
 
 			mp.Mask = append(mp.Mask[:toRemove], mp.Mask[toRemove+1:]...)
 
-			m.socket.broadcastMapChange(cd, broadcastMaskRemove, data, userAny)
+			m.broadcastMapChange(cd, broadcastMaskRemove, data, userAny)
 
 			return true
 		}); err != nil {
@@ -315,7 +316,7 @@ func (m *mapsDir) RPCData(cd ConnData, method string, data json.RawMessage) (int
 			mp.MaskOpaque = set.BaseOpaque
 			mp.Mask = set.Masks
 
-			m.socket.broadcastMapChange(cd, broadcastMaskSet, data, userAny)
+			m.broadcastMapChange(cd, broadcastMaskSet, data, userAny)
 
 			return true
 		}); err != nil {
@@ -342,9 +343,9 @@ func (m *mapsDir) RPCData(cd ConnData, method string, data json.RawMessage) (int
 				wallAdd.Wall.ID = mp.lastWallID
 				data, _ := json.Marshal(wallAdd)
 
-				m.socket.broadcastMapChange(cd, broadcastWallAdd, json.RawMessage(data), userAny)
+				m.broadcastMapChange(cd, broadcastWallAdd, json.RawMessage(data), userAny)
 			} else {
-				m.socket.broadcastMapChange(cd, broadcastWallAdd, data, userAny)
+				m.broadcastMapChange(cd, broadcastWallAdd, data, userAny)
 			}
 
 			l.Walls = append(l.Walls, wallAdd.Wall)
@@ -378,7 +379,7 @@ func (m *mapsDir) RPCData(cd ConnData, method string, data json.RawMessage) (int
 					l := w.layer
 					l.Walls = append(l.Walls[:pos], l.Walls[pos+1:]...)
 
-					m.socket.broadcastMapChange(cd, broadcastWallRemove, data, userAny)
+					m.broadcastMapChange(cd, broadcastWallRemove, data, userAny)
 
 					return true
 				}
@@ -418,7 +419,7 @@ func (m *mapsDir) RPCData(cd ConnData, method string, data json.RawMessage) (int
 			wall.wall.Colour = w.Colour
 			wall.wall.Scattering = w.Scattering
 
-			m.socket.broadcastMapChange(cd, broadcastWallModify, data, userAny)
+			m.broadcastMapChange(cd, broadcastWallModify, data, userAny)
 
 			return true
 		}); err != nil {
@@ -461,7 +462,7 @@ func (m *mapsDir) RPCData(cd ConnData, method string, data json.RawMessage) (int
 			l.Walls = append(l.Walls, lw.wall)
 			mp.walls[ip.ID] = layerWall{l, lw.wall}
 
-			m.socket.broadcastMapChange(cd, broadcastWallMoveLayer, data, userAny)
+			m.broadcastMapChange(cd, broadcastWallMoveLayer, data, userAny)
 
 			return true
 		}); err != nil {
@@ -489,7 +490,7 @@ func (m *mapsDir) RPCData(cd ConnData, method string, data json.RawMessage) (int
 			mp.Layers = append(mp.Layers, &layer{Name: name})
 			mp.layers[name] = struct{}{}
 
-			m.socket.broadcastMapChange(cd, broadcastLayerAdd, data, userAny)
+			m.broadcastMapChange(cd, broadcastLayerAdd, data, userAny)
 
 			return true
 		})
@@ -515,7 +516,7 @@ func (m *mapsDir) RPCData(cd ConnData, method string, data json.RawMessage) (int
 				Layers: []*layer{},
 			})
 
-			m.socket.broadcastMapChange(cd, broadcastLayerFolderAdd, data, userAny)
+			m.broadcastMapChange(cd, broadcastLayerFolderAdd, data, userAny)
 
 			return true
 		})
@@ -545,7 +546,7 @@ func (m *mapsDir) RPCData(cd ConnData, method string, data json.RawMessage) (int
 				data = append(appendString(append(appendString(append(data[:0], "{\"path\":"...), rename.Path), ",\"name\":"...), rename.Name), '}')
 			}
 
-			m.socket.broadcastMapChange(cd, broadcastLayerRename, data, userAny)
+			m.broadcastMapChange(cd, broadcastLayerRename, data, userAny)
 
 			return true
 		})
@@ -584,7 +585,7 @@ func (m *mapsDir) RPCData(cd ConnData, method string, data json.RawMessage) (int
 
 			op.removeLayer(l.Name)
 			np.addLayer(l, moveLayer.Position)
-			m.socket.broadcastMapChange(cd, broadcastLayerMove, data, userAny)
+			m.broadcastMapChange(cd, broadcastLayerMove, data, userAny)
 
 			return true
 		}); e != nil {
@@ -610,7 +611,7 @@ func (m *mapsDir) RPCData(cd ConnData, method string, data json.RawMessage) (int
 
 			l.Hidden = false
 
-			m.socket.broadcastMapChange(cd, broadcastLayerShow, data, userAny)
+			m.broadcastMapChange(cd, broadcastLayerShow, data, userAny)
 
 			return true
 		})
@@ -632,7 +633,7 @@ func (m *mapsDir) RPCData(cd ConnData, method string, data json.RawMessage) (int
 
 			l.Hidden = true
 
-			m.socket.broadcastMapChange(cd, broadcastLayerHide, data, userAny)
+			m.broadcastMapChange(cd, broadcastLayerHide, data, userAny)
 
 			return true
 		})
@@ -654,7 +655,7 @@ func (m *mapsDir) RPCData(cd ConnData, method string, data json.RawMessage) (int
 
 			l.Locked = true
 
-			m.socket.broadcastMapChange(cd, broadcastLayerLock, data, userAny)
+			m.broadcastMapChange(cd, broadcastLayerLock, data, userAny)
 
 			return true
 		})
@@ -676,7 +677,7 @@ func (m *mapsDir) RPCData(cd ConnData, method string, data json.RawMessage) (int
 
 			l.Locked = false
 
-			m.socket.broadcastMapChange(cd, broadcastLayerUnlock, data, userAny)
+			m.broadcastMapChange(cd, broadcastLayerUnlock, data, userAny)
 
 			return true
 		})
@@ -695,7 +696,7 @@ func (m *mapsDir) RPCData(cd ConnData, method string, data json.RawMessage) (int
 		err := m.updateMapLayer(cd.CurrentMap, parent, anyLayer, func(mp *levelMap, l *layer) bool {
 			l.removeLayer(name)
 			delete(mp.layers, name)
-			m.socket.broadcastMapChange(cd, broadcastLayerRemove, data, userAny)
+			m.broadcastMapChange(cd, broadcastLayerRemove, data, userAny)
 
 			return true
 		})
@@ -716,6 +717,8 @@ func (m *mapsDir) RPCData(cd ConnData, method string, data json.RawMessage) (int
 
 		if err := newToken.Token.validate(false); err != nil {
 			return nil, err
+		} else if err := m.checkSprite(newToken.Token.Source, newToken.Token.sprite); err != nil {
+			return nil, err
 		}
 
 		if err := m.updateMapLayer(cd.CurrentMap, newToken.Path, tokenLayer, func(mp *levelMap, l *layer) bool {
@@ -730,8 +733,8 @@ func (m *mapsDir) RPCData(cd ConnData, method string, data json.RawMessage) (int
 
 			mp.tokens[newToken.Token.ID] = layerToken{l, newToken.Token}
 
-			m.socket.broadcastMapChange(cd, broadcastTokenAdd, data, userAdmin)
-			m.socket.broadcastMapChange(cd, broadcastTokenAdd, append(strconv.AppendUint(append(newToken.Token.appendTo(append(appendString(append(data[:0], "{\"path\":"...), newToken.Path), ",\"token\":"...), true), ",\"pos\":"...), uint64(newToken.Pos), 10), '}'), userNotAdmin)
+			m.broadcastMapChange(cd, broadcastTokenAdd, data, userAdmin)
+			m.broadcastMapChange(cd, broadcastTokenAdd, append(strconv.AppendUint(append(newToken.Token.appendTo(append(appendString(append(data[:0], "{\"path\":"...), newToken.Path), ",\"token\":"...), true), ",\"pos\":"...), uint64(newToken.Pos), 10), '}'), userNotAdmin)
 
 			return true
 		}); err != nil {
@@ -749,7 +752,7 @@ func (m *mapsDir) RPCData(cd ConnData, method string, data json.RawMessage) (int
 		return nil, m.updateMapsLayerToken(cd.CurrentMap, tokenID, func(mp *levelMap, l *layer, tk *token) bool {
 			delete(mp.tokens, tokenID)
 			l.removeToken(tokenID)
-			m.socket.broadcastMapChange(cd, broadcastTokenRemove, data, userAny)
+			m.broadcastMapChange(cd, broadcastTokenRemove, data, userAny)
 
 			return true
 		})
@@ -766,11 +769,13 @@ func (m *mapsDir) RPCData(cd ConnData, method string, data json.RawMessage) (int
 			if !checkTokenLighting(setToken, tk) {
 				err = ErrInvalidLighting
 
+				return false
+			} else if err = m.checkTokenSprite(setToken, tk); err != nil {
 				return false
 			}
 
-			m.socket.broadcastMapChange(cd, broadcastTokenSet, data, userAdmin)
-			m.socket.broadcastMapChange(cd, broadcastTokenSet, updateToken(setToken, tk, data[:0]), userNotAdmin)
+			m.broadcastMapChange(cd, broadcastTokenSet, data, userAdmin)
+			m.broadcastMapChange(cd, broadcastTokenSet, updateToken(setToken, tk, data[:0]), userNotAdmin)
 
 			return true
 		}); errr != nil {
@@ -793,6 +798,8 @@ func (m *mapsDir) RPCData(cd ConnData, method string, data json.RawMessage) (int
 					if !checkTokenLighting(st, tk.token) {
 						err = ErrInvalidLighting
 
+						return false
+					} else if err = m.checkTokenSprite(st, tk.token); err != nil {
 						return false
 					}
 				} else {
@@ -802,7 +809,7 @@ func (m *mapsDir) RPCData(cd ConnData, method string, data json.RawMessage) (int
 				}
 			}
 
-			m.socket.broadcastMapChange(cd, broadcastTokenSetMulti, data, userAdmin)
+			m.broadcastMapChange(cd, broadcastTokenSetMulti, data, userAdmin)
 
 			data = append(data[:0], '[')
 
@@ -816,7 +823,7 @@ func (m *mapsDir) RPCData(cd ConnData, method string, data json.RawMessage) (int
 
 			data = append(data, ']')
 
-			m.socket.broadcastMapChange(cd, broadcastTokenSetMulti, data, userNotAdmin)
+			m.broadcastMapChange(cd, broadcastTokenSetMulti, data, userNotAdmin)
 
 			return true
 		}); errr != nil {
@@ -852,7 +859,7 @@ func (m *mapsDir) RPCData(cd ConnData, method string, data json.RawMessage) (int
 				mp.tokens[tk.ID] = layerToken{ml, tk}
 			}
 
-			m.socket.broadcastMapChange(cd, broadcastTokenMoveLayerPos, data, userAny)
+			m.broadcastMapChange(cd, broadcastTokenMoveLayerPos, data, userAny)
 
 			return true
 		}); errr != nil {
@@ -888,43 +895,19 @@ func (m *mapsDir) RPCData(cd ConnData, method string, data json.RawMessage) (int
 				w.Y2 += layerShift.DY
 			}
 
-			m.socket.broadcastMapChange(cd, broadcastLayerShift, data, userAny)
+			m.broadcastMapChange(cd, broadcastLayerShift, data, userAny)
 
 			return true
 		})
 	case "remove":
-		var (
-			mapPath string
-			cu      keystore.Uint64
-		)
+		var mapPath string
 
 		if err := json.Unmarshal(data, &mapPath); err != nil {
 			return nil, err
 		}
 
-		m.config.Get("currentUserMap", &cu)
-
-		_, _, id := m.getFolderItem(mapPath)
-		if id == uint64(cu) {
-			return nil, ErrCurrentlySelected
-		}
-
-		inUse := false
-
-		m.socket.mu.RLock()
-
-		for c := range m.socket.conns {
-			if c.CurrentMap == id {
-				inUse = true
-
-				break
-			}
-		}
-
-		m.socket.mu.RUnlock()
-
-		if inUse {
-			return nil, ErrCurrentlyInUse
+		if err := m.checkRemove(mapPath); err != nil {
+			return nil, err
 		}
 	case "rename":
 		var (
@@ -944,30 +927,26 @@ func (m *mapsDir) RPCData(cd ConnData, method string, data json.RawMessage) (int
 			return nil, ErrCurrentlySelected
 		}
 	case "removeFolder":
-		var (
-			mapPath string
-			cu      keystore.Uint64
-		)
+		var mapPath string
 
 		if err := json.Unmarshal(data, &mapPath); err != nil {
 			return nil, err
 		}
 
-		m.config.Get("currentUserMap", &cu)
-
-		if f := m.getFolder(mapPath); f != nil {
-			if walkFolders(f, func(items map[string]uint64) bool {
-				for _, id := range items {
-					if id == uint64(cu) || id == cd.CurrentMap {
-						return true
-					}
-				}
-
-				return false
-			}) {
-				return nil, ErrContainsCurrentlySelected
-			}
+		if err := m.checkRemoveFolder(cd, mapPath); err != nil {
+			return nil, err
 		}
+	case "bulk":
+		return m.bulkChecked(cd, data, func(op bulkOp) error {
+			switch op.Op {
+			case "remove":
+				return m.checkRemove(op.Path)
+			case "removeFolder":
+				return m.checkRemoveFolder(cd, op.Path)
+			}
+
+			return nil
+		})
 	case "renameFolder":
 		var (
 			mapPath struct {
@@ -1016,21 +995,27 @@ func (m *mapsDir) RPCData(cd ConnData, method string, data json.RawMessage) (int
 				return false
 			}
 
+			if errr = m.checkSpace("", encodedSize(mp)); errr != nil {
+				return false
+			}
+
 			m.lastID++
 
 			mid := m.lastID
-			j := mp.JSON
+			var buf memio.Buffer
 
-			m.Set(strconv.FormatUint(mid, 10), &j)
+			mp.WriteTo(&buf)
+			m.writeMap(mid, mp)
 
-			j = mp.JSON
 			l := new(levelMap)
-			l.JSON = make(memio.Buffer, 0, len(j))
+			l.JSON = make(memio.Buffer, 0, len(mp.JSON))
 
-			l.ReadFrom(&j)
+			l.ReadFrom(&buf)
 
 			newName := addItemTo(p.Items, name, mid)
-			m.maps[mid] = l
+
+			m.cacheMap(mid, l)
+			m.setReferences(mid, l.references())
 
 			m.saveFolders()
 
@@ -1056,6 +1041,64 @@ func (m *mapsDir) RPCData(cd ConnData, method string, data json.RawMessage) (int
 	return m.folders.RPCData(cd, method, data)
 }
 
+// checkRemove determines whether the map at the given path can be removed,
+// which it cannot be while it is the current user map or is in use.
+func (m *mapsDir) checkRemove(mapPath string) error {
+	var cu keystore.Uint64
+
+	m.config.Get("currentUserMap", &cu)
+
+	_, _, id := m.getFolderItem(mapPath)
+	if id == uint64(cu) {
+		return ErrCurrentlySelected
+	}
+
+	inUse := false
+
+	m.socket.mu.RLock()
+
+	for c := range m.socket.conns {
+		if c.CurrentMap == id {
+			inUse = true
+
+			break
+		}
+	}
+
+	m.socket.mu.RUnlock()
+
+	if inUse {
+		return ErrCurrentlyInUse
+	}
+
+	return nil
+}
+
+// checkRemoveFolder determines whether the folder at the given path can be
+// removed, which it cannot be while it contains the current user map or the
+// current map of the admin.
+func (m *mapsDir) checkRemoveFolder(cd ConnData, mapPath string) error {
+	var cu keystore.Uint64
+
+	m.config.Get("currentUserMap", &cu)
+
+	if f := m.getFolder(mapPath); f != nil {
+		if walkFolders(f, func(items map[string]uint64) bool {
+			for _, id := range items {
+				if id == uint64(cu) || id == cd.CurrentMap {
+					return true
+				}
+			}
+
+			return false
+		}) {
+			return ErrContainsCurrentlySelected
+		}
+	}
+
+	return nil
+}
+
 type setToken struct {
 	ID              uint64                  `json:"id"`
 	X               *int64                  `json:"x"`
@@ -1074,6 +1117,11 @@ type setToken struct {
 	RemoveTokenData []string                `json:"removeTokenData"`
 	Flip            *bool                   `json:"flip"`
 	Flop            *bool                   `json:"flop"`
+	FrameWidth      *uint64                 `json:"frameWidth"`
+	FrameHeight     *uint64                 `json:"frameHeight"`
+	FrameCount      *uint64                 `json:"frameCount"`
+	FrameRate       *uint64                 `json:"frameRate"`
+	LoopMode        *loopMode               `json:"loopMode"`
 
 	IsEllipse   *bool   `json:"isEllipse"`
 	Fill        *colour `json:"fill"`
@@ -1123,6 +1171,70 @@ func checkTokenLighting(setToken setToken, tk *token) bool {
 	return true
 }
 
+// checkTokenSprite checks that the sprite of an image token, after the changes
+// have been applied, is valid for its source image.
+func (m *mapsDir) checkTokenSprite(setToken setToken, tk *token) error {
+	if tk.TokenType != tokenImage {
+		return nil
+	}
+
+	s := tk.sprite
+	src := tk.Source
+
+	if setToken.Source != nil {
+		src = *setToken.Source
+	}
+
+	if setToken.FrameWidth != nil {
+		s.FrameWidth = *setToken.FrameWidth
+	}
+
+	if setToken.FrameHeight != nil {
+		s.FrameHeight = *setToken.FrameHeight
+	}
+
+	if setToken.FrameCount != nil {
+		s.FrameCount = *setToken.FrameCount
+	}
+
+	if setToken.FrameRate != nil {
+		s.FrameRate = *setToken.FrameRate
+	}
+
+	if setToken.LoopMode != nil {
+		s.LoopMode = *setToken.LoopMode
+	}
+
+	if !s.valid() {
+		return ErrInvalidSprite
+	}
+
+	return m.checkSprite(src, s)
+}
+
+// checkSprite checks that the given image can be divided evenly into the frames
+// of the sprite.
+//
+// Images whose dimensions cannot be determined are not checked.
+func (m *mapsDir) checkSprite(src uint64, s sprite) error {
+	if s.FrameCount == 0 {
+		return nil
+	} else if !s.valid() {
+		return ErrInvalidSprite
+	}
+
+	meta, err := m.images.getMeta(src)
+	if err != nil || meta.Width == 0 || meta.Height == 0 {
+		return nil
+	}
+
+	if !s.fits(uint64(meta.Width), uint64(meta.Height)) {
+		return ErrInvalidSprite
+	}
+
+	return nil
+}
+
 func updateToken(setToken setToken, tk *token, data json.RawMessage) json.RawMessage {
 	data = strconv.AppendUint(append(data[:0], "{\"id\":"...), setToken.ID, 10)
 
@@ -1197,6 +1309,31 @@ func updateToken(setToken setToken, tk *token, data json.RawMessage) json.RawMes
 			tk.Flop = *setToken.Flop
 			data = strconv.AppendBool(append(data, ",\"flip\":"...), tk.Flop)
 		}
+
+		if setToken.FrameWidth != nil && *setToken.FrameWidth != tk.FrameWidth {
+			tk.FrameWidth = *setToken.FrameWidth
+			data = strconv.AppendUint(append(data, ",\"frameWidth\":"...), tk.FrameWidth, 10)
+		}
+
+		if setToken.FrameHeight != nil && *setToken.FrameHeight != tk.FrameHeight {
+			tk.FrameHeight = *setToken.FrameHeight
+			data = strconv.AppendUint(append(data, ",\"frameHeight\":"...), tk.FrameHeight, 10)
+		}
+
+		if setToken.FrameCount != nil && *setToken.FrameCount != tk.FrameCount {
+			tk.FrameCount = *setToken.FrameCount
+			data = strconv.AppendUint(append(data, ",\"frameCount\":"...), tk.FrameCount, 10)
+		}
+
+		if setToken.FrameRate != nil && *setToken.FrameRate != tk.FrameRate {
+			tk.FrameRate = *setToken.FrameRate
+			data = strconv.AppendUint(append(data, ",\"frameRate\":"...), tk.FrameRate, 10)
+		}
+
+		if setToken.LoopMode != nil && *setToken.LoopMode != tk.LoopMode {
+			tk.LoopMode = *setToken.LoopMode
+			data = appendNum(append(data, ",\"loopMode\":"...), uint8(tk.LoopMode))
+		}
 	case tokenDrawing:
 		if setToken.Points != nil {
 			tk.Points = setToken.Points
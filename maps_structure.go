@@ -1,10 +1,12 @@
 package battlemap
 
 import (
+	"bytes"
 	"encoding/json"
 	"io"
 	"strconv"
 
+	"vimagination.zapto.org/byteio"
 	"vimagination.zapto.org/memio"
 	"vimagination.zapto.org/rwcount"
 )
@@ -29,23 +31,53 @@ type levelMap struct {
 	tokens                  map[uint64]layerToken
 	walls                   map[uint64]layerWall
 	lastTokenID, lastWallID uint64
+	legacy, renumber        bool
+	duplicates              []layerToken
 	JSON, UserJSON          memio.Buffer `json:"-"`
 }
 
+const (
+	mapMagic        = "BMAP"
+	mapVersion      = 2
+	maxBinaryLength = 1 << 24
+)
+
 func (l *levelMap) ReadFrom(r io.Reader) (int64, error) {
 	l.tokens = make(map[uint64]layerToken)
 	l.walls = make(map[uint64]layerWall)
 	sr := rwcount.Reader{Reader: r}
-	err := json.NewDecoder(&sr).Decode(l)
+	var magic [len(mapMagic)]byte
+	n, err := io.ReadFull(&sr, magic[:])
 	if sr.Err != nil {
 		return sr.Count, sr.Err
-	} else if err != nil {
-		return sr.Count, err
+	} else if err == nil && string(magic[:]) == mapMagic {
+		lr := byteio.StickyLittleEndianReader{Reader: &sr}
+		l.readBinary(&lr)
+		if sr.Err != nil {
+			return sr.Count, sr.Err
+		} else if lr.Err != nil {
+			return sr.Count, lr.Err
+		}
+		l.legacy = false
+	} else {
+		err = json.NewDecoder(io.MultiReader(bytes.NewReader(magic[:n]), &sr)).Decode(l)
+		if sr.Err != nil {
+			return sr.Count, sr.Err
+		} else if err != nil {
+			return sr.Count, err
+		}
+		l.legacy = true
 	}
 	l.layers = make(map[string]struct{})
 	if err = l.validate(); err != nil {
 		return sr.Count, err
 	}
+	for _, d := range l.duplicates {
+		l.lastTokenID++
+		d.ID = l.lastTokenID
+		l.tokens[d.ID] = d
+	}
+	l.duplicates = nil
 	if _, ok := l.layers["Grid"]; !ok {
 		l.Layers = append(l.Layers, &layer{Name: "Grid"})
 		l.layers["Grid"] = struct{}{}
@@ -102,10 +134,91 @@ func (l *levelMap) writeJSON() {
 	l.JSON = append(l.layer.appendTo(l.JSON, false, false), '}')
 }
 
+// WriteTo writes the map in the binary storage format.
 func (l *levelMap) WriteTo(w io.Writer) (int64, error) {
-	l.writeJSON()
-	n, err := w.Write(l.JSON)
-	return int64(n), err
+	lw := byteio.StickyLittleEndianWriter{Writer: w}
+	lw.Write([]byte(mapMagic))
+	lw.WriteUint8(mapVersion)
+	l.writeBinary(&lw)
+	return lw.Count, lw.Err
+}
+
+func (l *levelMap) writeBinary(lw *byteio.StickyLittleEndianWriter) {
+	lw.WriteUintX(l.Width)
+	lw.WriteUintX(l.Height)
+	lw.WriteUintX(l.StartX)
+	lw.WriteUintX(l.StartY)
+	lw.WriteUint8(l.GridType)
+	lw.WriteUintX(l.GridSize)
+	lw.WriteUintX(l.GridStroke)
+	l.GridColour.writeBinary(lw)
+	lw.WriteUintX(l.GridDistance)
+	lw.WriteBool(l.GridDiagonal)
+	l.Light.writeBinary(lw)
+	lw.WriteBool(l.MaskOpaque)
+	lw.WriteUintX(uint64(len(l.Mask)))
+	for _, m := range l.Mask {
+		lw.WriteUintX(uint64(len(m)))
+		for _, i := range m {
+			lw.WriteUintX(i)
+		}
+	}
+	lw.WriteUintX(uint64(len(l.Data)))
+	for k, v := range l.Data {
+		lw.WriteStringX(k)
+		lw.WriteBytesX(v)
+	}
+	l.layer.writeBinary(lw)
+}
+
+func (l *levelMap) readBinary(lr *byteio.StickyLittleEndianReader) {
+	v := lr.ReadUint8()
+	if v == 0 || v > mapVersion {
+		if lr.Err == nil {
+			lr.Err = ErrUnsupportedMapVersion
+		}
+		return
+	}
+	l.Width = lr.ReadUintX()
+	l.Height = lr.ReadUintX()
+	l.StartX = lr.ReadUintX()
+	l.StartY = lr.ReadUintX()
+	l.GridType = lr.ReadUint8()
+	l.GridSize = lr.ReadUintX()
+	l.GridStroke = lr.ReadUintX()
+	l.GridColour.readBinary(lr)
+	l.GridDistance = lr.ReadUintX()
+	l.GridDiagonal = lr.ReadBool()
+	l.Light.readBinary(lr)
+	l.MaskOpaque = lr.ReadBool()
+	l.Mask = make([][]uint64, readLength(lr))
+	for n := range l.Mask {
+		m := make([]uint64, readLength(lr))
+		for o := range m {
+			m[o] = lr.ReadUintX()
+		}
+		l.Mask[n] = m
+	}
+	dl := readLength(lr)
+	l.Data = make(map[string]json.RawMessage, dl)
+	for ; dl > 0 && lr.Err == nil; dl-- {
+		k := lr.ReadStringX()
+		l.Data[k] = lr.ReadBytesX()
+	}
+	l.layer.readBinary(lr, v)
+}
+
+// readLength reads a length prefix, limiting it so that corrupt data cannot
+// cause an excessive allocation.
+func readLength(lr *byteio.StickyLittleEndianReader) uint64 {
+	l := lr.ReadUintX()
+	if lr.Err != nil {
+		return 0
+	} else if l > maxBinaryLength {
+		lr.Err = ErrInvalidData
+		return 0
+	}
+	return l
 }
 
 func (l *levelMap) validate() error {
@@ -146,7 +259,11 @@ func (l *layer) validate(lm *levelMap, first bool) error {
 			return err
 		}
 		if _, ok := lm.tokens[token.ID]; ok {
-			return ErrDuplicateTokenID
+			if !lm.renumber {
+				return ErrDuplicateTokenID
+			}
+			lm.duplicates = append(lm.duplicates, layerToken{l, token})
+			continue
 		}
 		if token.ID > lm.lastTokenID {
 			lm.lastTokenID = token.ID
@@ -199,6 +316,67 @@ func (l *layer) appendTo(p []byte, full, user bool) []byte {
 	return append(p, ']')
 }
 
+const (
+	layerKindTokens uint8 = iota
+	layerKindFolder
+	layerKindOther
+)
+
+func (l *layer) writeBinary(lw *byteio.StickyLittleEndianWriter) {
+	lw.WriteStringX(l.Name)
+	lw.WriteBool(l.Hidden)
+	lw.WriteBool(l.Locked)
+	if l.Layers != nil {
+		lw.WriteUint8(layerKindFolder)
+		lw.WriteUintX(uint64(len(l.Layers)))
+		for _, m := range l.Layers {
+			m.writeBinary(lw)
+		}
+	} else if l.Tokens != nil {
+		lw.WriteUint8(layerKindTokens)
+		lw.WriteUintX(uint64(len(l.Tokens)))
+		for _, t := range l.Tokens {
+			t.writeBinary(lw)
+		}
+		lw.WriteUintX(uint64(len(l.Walls)))
+		for _, w := range l.Walls {
+			w.writeBinary(lw)
+		}
+	} else {
+		lw.WriteUint8(layerKindOther)
+	}
+}
+
+func (l *layer) readBinary(lr *byteio.StickyLittleEndianReader, version uint8) {
+	l.Name = lr.ReadStringX()
+	l.Hidden = lr.ReadBool()
+	l.Locked = lr.ReadBool()
+	switch lr.ReadUint8() {
+	case layerKindFolder:
+		l.Layers = make([]*layer, readLength(lr))
+		for n := range l.Layers {
+			l.Layers[n] = new(layer)
+			l.Layers[n].readBinary(lr, version)
+		}
+	case layerKindTokens:
+		l.Tokens = make([]*token, readLength(lr))
+		for n := range l.Tokens {
+			l.Tokens[n] = new(token)
+			l.Tokens[n].readBinary(lr, version)
+		}
+		l.Walls = make([]*wall, readLength(lr))
+		for n := range l.Walls {
+			l.Walls[n] = new(wall)
+			l.Walls[n].readBinary(lr)
+		}
+	case layerKindOther:
+	default:
+		if lr.Err == nil {
+			lr.Err = ErrInvalidLayer
+		}
+	}
+}
+
 type layerToken struct {
 	*layer
 	*token
@@ -243,6 +421,7 @@ type token struct {
 	ID     uint64 `json:"id"`
 	Source uint64 `json:"src"`
 	coords
+	sprite
 	Width         uint64                  `json:"width"`
 	Height        uint64                  `json:"height"`
 	PatternWidth  uint64                  `json:"patternWidth"`
@@ -270,6 +449,50 @@ type coords struct {
 	Y int64 `json:"y"`
 }
 
+// sprite describes the animation of an image token, the source of which is a
+// sprite sheet of equally sized frames, read left to right, top to bottom.
+type sprite struct {
+	FrameWidth  uint64   `json:"frameWidth"`
+	FrameHeight uint64   `json:"frameHeight"`
+	FrameCount  uint64   `json:"frameCount"`
+	FrameRate   uint64   `json:"frameRate"`
+	LoopMode    loopMode `json:"loopMode"`
+}
+
+type loopMode uint8
+
+const (
+	loopRepeat loopMode = iota
+	loopOnce
+	loopPingPong
+)
+
+// valid determines whether the sprite fields are either all unset, or describe
+// an animation.
+func (s sprite) valid() bool {
+	if s.FrameCount == 0 {
+		return s == sprite{}
+	}
+	return s.FrameWidth > 0 && s.FrameHeight > 0 && s.FrameRate > 0 && s.LoopMode <= loopPingPong
+}
+
+// fits determines whether a sprite sheet with the given dimensions can be
+// divided into all of the frames of the sprite.
+func (s sprite) fits(width, height uint64) bool {
+	if s.FrameCount == 0 {
+		return true
+	}
+	return width%s.FrameWidth == 0 && height%s.FrameHeight == 0 && (width/s.FrameWidth)*(height/s.FrameHeight) >= s.FrameCount
+}
+
+func (s sprite) appendTo(p []byte) []byte {
+	p = strconv.AppendUint(append(p, ",\"frameWidth\":"...), s.FrameWidth, 10)
+	p = strconv.AppendUint(append(p, ",\"frameHeight\":"...), s.FrameHeight, 10)
+	p = strconv.AppendUint(append(p, ",\"frameCount\":"...), s.FrameCount, 10)
+	p = strconv.AppendUint(append(p, ",\"frameRate\":"...), s.FrameRate, 10)
+	return appendNum(append(p, ",\"loopMode\":"...), uint8(s.LoopMode))
+}
+
 type tokenType uint8
 
 const (
@@ -305,6 +528,7 @@ func (t *token) appendTo(p []byte, user bool) []byte {
 		p = strconv.AppendBool(append(p, ",\"flop\":"...), t.Flop)
 		p = strconv.AppendUint(append(p, ",\"patternWidth\":"...), t.PatternWidth, 10)
 		p = strconv.AppendUint(append(p, ",\"patternHeight\":"...), t.PatternHeight, 10)
+		p = t.sprite.appendTo(p)
 	case tokenDrawing:
 		p = append(p, ",\"points\":["...)
 		for n, coords := range t.Points {
@@ -367,6 +591,127 @@ func (t *token) appendTo(p []byte, user bool) []byte {
 	return append(p, '}')
 }
 
+func (t *token) writeBinary(lw *byteio.StickyLittleEndianWriter) {
+	lw.WriteUintX(t.ID)
+	lw.WriteUintX(t.Source)
+	lw.WriteIntX(t.X)
+	lw.WriteIntX(t.Y)
+	lw.WriteUintX(t.Width)
+	lw.WriteUintX(t.Height)
+	lw.WriteUintX(t.PatternWidth)
+	lw.WriteUintX(t.PatternHeight)
+	lw.WriteUintX(uint64(len(t.TokenData)))
+	for key, data := range t.TokenData {
+		lw.WriteStringX(key)
+		lw.WriteBool(data.User)
+		lw.WriteBytesX(data.Data)
+	}
+	lw.WriteUint8(t.Rotation)
+	lw.WriteBool(t.Flip)
+	lw.WriteBool(t.Flop)
+	lw.WriteBool(t.Snap)
+	lw.WriteUintX(uint64(len(t.LightColours)))
+	for _, cs := range t.LightColours {
+		lw.WriteUintX(uint64(len(cs)))
+		for _, c := range cs {
+			c.writeBinary(lw)
+		}
+	}
+	lw.WriteUintX(uint64(len(t.LightStages)))
+	for _, l := range t.LightStages {
+		lw.WriteUintX(l)
+	}
+	lw.WriteUintX(uint64(len(t.LightTimings)))
+	for _, l := range t.LightTimings {
+		lw.WriteUintX(l)
+	}
+	lw.WriteUint8(uint8(t.TokenType))
+	lw.WriteBool(t.IsEllipse)
+	lw.WriteUint8(t.StrokeWidth)
+	t.Fill.writeBinary(lw)
+	lw.WriteUintX(uint64(len(t.Fills)))
+	for _, f := range t.Fills {
+		lw.WriteUint8(f.Pos)
+		f.Colour.writeBinary(lw)
+	}
+	lw.WriteUint8(uint8(t.FillType))
+	t.Stroke.writeBinary(lw)
+	lw.WriteUintX(uint64(len(t.Points)))
+	for _, p := range t.Points {
+		lw.WriteIntX(p.X)
+		lw.WriteIntX(p.Y)
+	}
+	lw.WriteUintX(t.FrameWidth)
+	lw.WriteUintX(t.FrameHeight)
+	lw.WriteUintX(t.FrameCount)
+	lw.WriteUintX(t.FrameRate)
+	lw.WriteUint8(uint8(t.LoopMode))
+}
+
+func (t *token) readBinary(lr *byteio.StickyLittleEndianReader, version uint8) {
+	t.ID = lr.ReadUintX()
+	t.Source = lr.ReadUintX()
+	t.X = lr.ReadIntX()
+	t.Y = lr.ReadIntX()
+	t.Width = lr.ReadUintX()
+	t.Height = lr.ReadUintX()
+	t.PatternWidth = lr.ReadUintX()
+	t.PatternHeight = lr.ReadUintX()
+	tl := readLength(lr)
+	t.TokenData = make(map[string]keystoreData, tl)
+	for ; tl > 0 && lr.Err == nil; tl-- {
+		key := lr.ReadStringX()
+		t.TokenData[key] = keystoreData{
+			User: lr.ReadBool(),
+			Data: lr.ReadBytesX(),
+		}
+	}
+	t.Rotation = lr.ReadUint8()
+	t.Flip = lr.ReadBool()
+	t.Flop = lr.ReadBool()
+	t.Snap = lr.ReadBool()
+	t.LightColours = make(lightColours, readLength(lr))
+	for n := range t.LightColours {
+		cs := make([]colour, readLength(lr))
+		for m := range cs {
+			cs[m].readBinary(lr)
+		}
+		t.LightColours[n] = cs
+	}
+	t.LightStages = make(lightData, readLength(lr))
+	for n := range t.LightStages {
+		t.LightStages[n] = lr.ReadUintX()
+	}
+	t.LightTimings = make(lightData, readLength(lr))
+	for n := range t.LightTimings {
+		t.LightTimings[n] = lr.ReadUintX()
+	}
+	t.TokenType = tokenType(lr.ReadUint8())
+	t.IsEllipse = lr.ReadBool()
+	t.StrokeWidth = lr.ReadUint8()
+	t.Fill.readBinary(lr)
+	t.Fills = make([]fill, readLength(lr))
+	for n := range t.Fills {
+		t.Fills[n].Pos = lr.ReadUint8()
+		t.Fills[n].Colour.readBinary(lr)
+	}
+	t.FillType = fillType(lr.ReadUint8())
+	t.Stroke.readBinary(lr)
+	t.Points = make([]coords, readLength(lr))
+	for n := range t.Points {
+		t.Points[n].X = lr.ReadIntX()
+		t.Points[n].Y = lr.ReadIntX()
+	}
+	if version < 2 {
+		return
+	}
+	t.FrameWidth = lr.ReadUintX()
+	t.FrameHeight = lr.ReadUintX()
+	t.FrameCount = lr.ReadUintX()
+	t.FrameRate = lr.ReadUintX()
+	t.LoopMode = loopMode(lr.ReadUint8())
+}
+
 func (t *token) validate(checkID bool) error {
 	if checkID && t.ID == 0 {
 		return ErrInvalidTokenID
@@ -381,7 +726,7 @@ func (t *token) validate(checkID bool) error {
 	}
 	switch t.TokenType {
 	case tokenImage:
-		if t.FillType != 0 || t.Source == 0 || t.IsEllipse || !t.Fill.empty() || !t.Stroke.empty() || t.StrokeWidth > 0 || len(t.Points) > 0 || (t.PatternWidth > 0) != (t.PatternHeight > 0) {
+		if t.FillType != 0 || t.Source == 0 || t.IsEllipse || !t.Fill.empty() || !t.Stroke.empty() || t.StrokeWidth > 0 || len(t.Points) > 0 || (t.PatternWidth > 0) != (t.PatternHeight > 0) || !t.sprite.valid() {
 			return ErrInvalidToken
 		}
 	case tokenDrawing:
@@ -390,7 +735,7 @@ func (t *token) validate(checkID bool) error {
 		}
 		fallthrough
 	case tokenShape:
-		if t.Source != 0 || t.Flip || t.Flop || t.PatternWidth > 0 || t.PatternHeight > 0 {
+		if t.Source != 0 || t.Flip || t.Flop || t.PatternWidth > 0 || t.PatternHeight > 0 || t.sprite != (sprite{}) {
 			return ErrInvalidToken
 		}
 	default:
@@ -414,6 +759,24 @@ type wall struct {
 	Scattering uint8  `json:"scattering"`
 }
 
+func (w *wall) writeBinary(lw *byteio.StickyLittleEndianWriter) {
+	lw.WriteIntX(w.X1)
+	lw.WriteIntX(w.Y1)
+	lw.WriteIntX(w.X2)
+	lw.WriteIntX(w.Y2)
+	w.Colour.writeBinary(lw)
+	lw.WriteUint8(w.Scattering)
+}
+
+func (w *wall) readBinary(lr *byteio.StickyLittleEndianReader) {
+	w.X1 = lr.ReadIntX()
+	w.Y1 = lr.ReadIntX()
+	w.X2 = lr.ReadIntX()
+	w.Y2 = lr.ReadIntX()
+	w.Colour.readBinary(lr)
+	w.Scattering = lr.ReadUint8()
+}
+
 func (w wall) appendTo(p []byte) []byte {
 	p = strconv.AppendUint(append(p, "{\"id\":"...), w.ID, 10)
 	p = strconv.AppendInt(append(p, ",\"x1\":"...), w.X1, 10)
@@ -440,6 +803,20 @@ func (c colour) appendTo(p []byte) []byte {
 	return append(p, '}')
 }
 
+func (c colour) writeBinary(lw *byteio.StickyLittleEndianWriter) {
+	lw.WriteUint8(c.R)
+	lw.WriteUint8(c.G)
+	lw.WriteUint8(c.B)
+	lw.WriteUint8(c.A)
+}
+
+func (c *colour) readBinary(lr *byteio.StickyLittleEndianReader) {
+	c.R = lr.ReadUint8()
+	c.G = lr.ReadUint8()
+	c.B = lr.ReadUint8()
+	c.A = lr.ReadUint8()
+}
+
 func (c colour) empty() bool {
 	return c.R == 0 && c.G == 0 && c.B == 0 && c.A == 0
 }
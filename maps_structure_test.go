@@ -0,0 +1,55 @@
+package battlemap
+
+import (
+	"strings"
+	"testing"
+
+	"vimagination.zapto.org/memio"
+)
+
+const testMapJSON = `{"width":100,"height":200,"startX":1,"startY":2,"gridType":1,"gridSize":10,"gridStroke":1,"gridColour":{"r":1,"g":2,"b":3,"a":4},"gridDistance":5,"gridDiagonal":true,"lightColour":{"r":5,"g":6,"b":7,"a":8},"baseOpaque":true,"masks":[[0,1,2,3,4]],"data":{"store-image-test":1},"children":[{"name":"Layer","hidden":false,"locked":true,"tokens":[{"id":1,"src":2,"x":-3,"y":4,"width":5,"height":6,"patternWidth":1,"patternHeight":1,"frameWidth":5,"frameHeight":6,"frameCount":4,"frameRate":12,"loopMode":2,"tokenData":{"key":{"user":true,"data":"value"}},"lightColours":[[{"r":1,"g":1,"b":1,"a":1}]],"lightStages":[10],"lightTimings":[20]},{"id":2,"tokenType":2,"x":0,"y":0,"width":1,"height":1,"points":[{"x":1,"y":2},{"x":3,"y":4}],"fillType":1,"fills":[{"pos":1,"colour":{"r":1,"g":2,"b":3,"a":4}}],"strokeWidth":2,"stroke":{"r":9,"g":9,"b":9,"a":9}}],"walls":[{"x1":1,"y1":2,"x2":3,"y2":4,"colour":{"r":1,"g":2,"b":3,"a":4},"scattering":5}]},{"name":"Folder","hidden":true,"locked":false,"children":[]},{"name":"Light"},{"name":"Grid"}]}`
+
+func TestMapBinaryFormat(t *testing.T) {
+	var (
+		legacy, binary levelMap
+		buf            memio.Buffer
+	)
+
+	if _, err := legacy.ReadFrom(strings.NewReader(testMapJSON)); err != nil {
+		t.Fatalf("unexpected error reading JSON map: %s", err)
+	} else if !legacy.legacy {
+		t.Fatal("expecting JSON map to be marked as legacy")
+	} else if _, err := legacy.WriteTo(&buf); err != nil {
+		t.Fatalf("unexpected error writing binary map: %s", err)
+	} else if !strings.HasPrefix(string(buf), mapMagic) {
+		t.Fatal("expecting binary map to start with magic header")
+	} else if _, err := binary.ReadFrom(&buf); err != nil {
+		t.Fatalf("unexpected error reading binary map: %s", err)
+	} else if binary.legacy {
+		t.Fatal("expecting binary map not to be marked as legacy")
+	} else if string(binary.JSON) != string(legacy.JSON) {
+		t.Errorf("expecting binary map JSON to match legacy JSON:\n%s\n%s", binary.JSON, legacy.JSON)
+	} else if string(binary.UserJSON) != string(legacy.UserJSON) {
+		t.Errorf("expecting binary map user JSON to match legacy user JSON:\n%s\n%s", binary.UserJSON, legacy.UserJSON)
+	}
+}
+
+func TestSpriteFits(t *testing.T) {
+	for n, test := range [...]struct {
+		sprite        sprite
+		width, height uint64
+		fits          bool
+	}{
+		{sprite{}, 7, 3, true},
+		{sprite{FrameWidth: 10, FrameHeight: 10, FrameCount: 4, FrameRate: 1}, 40, 10, true},
+		{sprite{FrameWidth: 10, FrameHeight: 10, FrameCount: 4, FrameRate: 1}, 20, 20, true},
+		{sprite{FrameWidth: 10, FrameHeight: 10, FrameCount: 3, FrameRate: 1}, 20, 20, true},
+		{sprite{FrameWidth: 10, FrameHeight: 10, FrameCount: 5, FrameRate: 1}, 20, 20, false},
+		{sprite{FrameWidth: 10, FrameHeight: 10, FrameCount: 2, FrameRate: 1}, 25, 10, false},
+		{sprite{FrameWidth: 10, FrameHeight: 10, FrameCount: 2, FrameRate: 1}, 20, 15, false},
+	} {
+		if fits := test.sprite.fits(test.width, test.height); fits != test.fits {
+			t.Errorf("test %d: expecting fits to be %v, got %v", n+1, test.fits, fits)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package battlemap
 
 import (
+	"cmp"
 	"compress/gzip"
 	"encoding/json"
 	"fmt"
@@ -84,6 +85,7 @@ type musicPacksDir struct {
 	packs  map[uint64]*musicPack
 	names  map[string]struct{}
 	lastID uint64
+	trash  trash
 }
 
 func (m *musicPacksDir) Init(b *Battlemap, links links) error {
@@ -150,18 +152,76 @@ func (m *musicPacksDir) Init(b *Battlemap, links links) error {
 		links.music.setLink(id)
 	}
 
+	if err := m.trash.Init(&b.config, "music", mp); err != nil {
+		return err
+	}
+
+	for id := range m.trash.items {
+		if id > m.lastID {
+			m.lastID = id
+		}
+	}
+
 	return nil
 }
 
+// cleanup moves all packs not referenced by the link manager into the trash;
+// removed packs, which have no name, are trashed under their ID.
 func (m *musicPacksDir) cleanup(l linkManager) {
-	for id := range m.packs {
+	for id, p := range m.packs {
 		if _, ok := l[id]; !ok {
-			m.fileStore.Remove(strconv.FormatUint(id, 10))
+			m.trash.add(id, cmp.Or(p.Name, strconv.FormatUint(id, 10)))
 			delete(m.packs, id)
 		}
 	}
 }
 
+func (m *musicPacksDir) restore(cd ConnData, data json.RawMessage) (json.RawMessage, error) {
+	var id uint64
+
+	if err := json.Unmarshal(data, &id); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.packs[id]; ok {
+		return nil, ErrDuplicateKey
+	}
+
+	name, err := m.trash.restore(id)
+	if err != nil {
+		return nil, err
+	}
+
+	idStr := strconv.FormatUint(id, 10)
+	pack := new(musicPack)
+
+	if err := m.fileStore.Get(idStr, pack); err != nil {
+		return nil, fmt.Errorf("error reading music pack %d: %w", id, err)
+	}
+
+	pack.Name = uniqueName(cmp.Or(name, idStr), func(name string) bool {
+		_, ok := m.names[name]
+
+		return !ok
+	})
+	pack.PlayTime = 0
+	m.packs[id] = pack
+	m.names[pack.Name] = struct{}{}
+
+	if err := m.fileStore.Set(idStr, pack); err != nil {
+		return nil, err
+	}
+
+	data = append(appendString(append(strconv.AppendUint(append(json.RawMessage{}, "{\"id\":"...), id, 10), ",\"name\":"...), pack.Name), '}')
+
+	m.socket.broadcastMapChange(cd, broadcastMusicPackAdd, data, userAny)
+
+	return data, nil
+}
+
 func (m *musicPacksDir) getPack(id uint64, fn func(*musicPack) bool) error {
 	var err error
 
@@ -170,6 +230,8 @@ func (m *musicPacksDir) getPack(id uint64, fn func(*musicPack) bool) error {
 	if p, ok := m.packs[id]; ok {
 		if fn(p) {
 			err = m.fileStore.Set(strconv.FormatUint(id, 10), p)
+
+			m.visible.invalidate()
 		}
 	} else {
 		err = ErrUnknownMusicPack
@@ -201,6 +263,18 @@ func (m *musicPacksDir) RPCData(cd ConnData, method string, data json.RawMessage
 	cd.CurrentMap = 0
 
 	switch method {
+	case "trash":
+		return m.trash.list(), nil
+	case "restore":
+		return m.restore(cd, data)
+	case "purge":
+		var ids []uint64
+
+		if err := json.Unmarshal(data, &ids); err != nil {
+			return nil, err
+		}
+
+		return nil, m.trash.purge(ids)
 	case "list":
 		buf := memio.Buffer("[")
 		first := true
@@ -316,11 +390,13 @@ func (m *musicPacksDir) RPCData(cd ConnData, method string, data json.RawMessage
 			return nil, ErrUnknownMusicPack
 		}
 
+		delete(m.names, mp.Name)
+
 		mp.Name = ""
 
 		m.fileStore.Set(strconv.FormatUint(id, 10), mp)
 		delete(m.packs, id)
-		delete(m.names, mp.Name)
+		m.visible.invalidate()
 		m.mu.Unlock()
 		m.socket.broadcastMapChange(cd, broadcastMusicPackRemove, data, userAny)
 
@@ -313,6 +313,7 @@ func (p *pluginsDir) RPCData(cd ConnData, method string, data json.RawMessage) (
 		}
 
 		p.updateJSON()
+		p.visible.invalidate()
 		p.mu.Unlock()
 	case "enable", "disable":
 		var filename string
@@ -340,6 +341,7 @@ func (p *pluginsDir) RPCData(cd ConnData, method string, data json.RawMessage) (
 
 		p.socket.broadcastMapChange(cd, broadcastPluginChange, json.RawMessage{'0'}, userAny)
 		p.updateJSON()
+		p.visible.invalidate()
 		p.mu.Unlock()
 	default:
 		return nil, ErrUnknownMethod
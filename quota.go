@@ -0,0 +1,196 @@
+package battlemap
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+
+	"vimagination.zapto.org/keystore"
+	"vimagination.zapto.org/rwcount"
+)
+
+// storeQuota holds the size limits of a store; a limit of zero means that the
+// size is unlimited.
+type storeQuota struct {
+	maxFileSize, total uint64
+}
+
+// storeSpace keeps a running total of the size of the files in a store.
+type storeSpace struct {
+	mu    sync.Mutex
+	sizes map[uint64]uint64
+	used  uint64
+}
+
+// initQuota reads the size limits for the store from the config, using the
+// <name>MaxFileSize and <name>Quota keys, and then totals the size of the
+// files already in the store.
+func (f *folders) initQuota(name string) error {
+	var maxFileSize, total keystore.Uint64
+
+	if err := f.config.Get(name+"MaxFileSize", &maxFileSize); err != nil {
+		return fmt.Errorf("error getting maximum file size: %w", err)
+	}
+
+	if err := f.config.Get(name+"Quota", &total); err != nil {
+		return fmt.Errorf("error getting storage quota: %w", err)
+	}
+
+	f.quota = storeQuota{
+		maxFileSize: uint64(maxFileSize),
+		total:       uint64(total),
+	}
+	f.space.sizes = make(map[uint64]uint64)
+
+	var ids []uint64
+
+	for _, key := range f.Keys() {
+		if id, err := strconv.ParseUint(key, 10, 64); err == nil {
+			ids = append(ids, id)
+		}
+	}
+
+	f.updateUsage(ids...)
+
+	return nil
+}
+
+// updateUsage updates the running total of the size of the store with the
+// current size of the files with the given IDs, which should be called after
+// those files are written or removed.
+func (f *folders) updateUsage(ids ...uint64) {
+	f.space.mu.Lock()
+	defer f.space.mu.Unlock()
+
+	if f.space.sizes == nil {
+		return
+	}
+
+	for _, id := range ids {
+		f.space.used -= f.space.sizes[id]
+
+		if fi, err := f.Stat(strconv.FormatUint(id, 10)); err == nil {
+			f.space.sizes[id] = uint64(fi.Size())
+			f.space.used += uint64(fi.Size())
+		} else {
+			delete(f.space.sizes, id)
+		}
+	}
+}
+
+// usedSpace returns the total size, and number, of the files in the store.
+func (f *folders) usedSpace() (size, files uint64) {
+	f.space.mu.Lock()
+	defer f.space.mu.Unlock()
+
+	return f.space.used, uint64(len(f.space.sizes))
+}
+
+// limited returns true if the store has any size limits.
+func (q storeQuota) limited() bool {
+	return q != storeQuota{}
+}
+
+// checkSpace determines whether a file of the given size can be stored with
+// the given key, replacing any existing file; an empty key is used for a new
+// file.
+func (f *folders) checkSpace(key string, size uint64) error {
+	if f.quota.maxFileSize != 0 && size > f.quota.maxFileSize {
+		return ErrFileTooLarge
+	}
+
+	if f.quota.total != 0 {
+		f.space.mu.Lock()
+
+		used := f.space.used
+
+		if id, err := strconv.ParseUint(key, 10, 64); err == nil {
+			used -= f.space.sizes[id]
+		}
+
+		f.space.mu.Unlock()
+
+		if used+size > f.quota.total {
+			return ErrQuotaExceeded
+		}
+	}
+
+	return nil
+}
+
+// limitReader wraps the given reader, so that reading more data than can be
+// stored as a new file results in a rejectionError.
+func (f *folders) limitReader(r io.Reader) *quotaReader {
+	q := &quotaReader{Reader: r, remaining: -1}
+
+	if f.quota.maxFileSize != 0 {
+		q.remaining = int64(f.quota.maxFileSize)
+		q.exceeded = rejectionError(ErrFileTooLarge.Error())
+	}
+
+	if f.quota.total != 0 {
+		used, _ := f.usedSpace()
+		remaining := int64(0)
+
+		if used < f.quota.total {
+			remaining = int64(f.quota.total - used)
+		}
+
+		if q.remaining == -1 || remaining < q.remaining {
+			q.remaining = remaining
+			q.exceeded = rejectionError(ErrQuotaExceeded.Error())
+		}
+	}
+
+	return q
+}
+
+// quotaJSON returns the storage used by the store, along with its limits.
+func (f *folders) quotaJSON() json.RawMessage {
+	used, files := f.usedSpace()
+
+	data := strconv.AppendUint(append(json.RawMessage{}, "{\"used\":"...), used, 10)
+	data = strconv.AppendUint(append(data, ",\"files\":"...), files, 10)
+	data = strconv.AppendUint(append(data, ",\"maxFileSize\":"...), f.quota.maxFileSize, 10)
+	data = strconv.AppendUint(append(data, ",\"quota\":"...), f.quota.total, 10)
+
+	return append(data, '}')
+}
+
+// quotaReader is a reader that fails once more than the remaining number of
+// bytes have been read; a negative remaining value means there is no limit.
+type quotaReader struct {
+	io.Reader
+	remaining int64
+	exceeded  error
+	err       error
+}
+
+func (q *quotaReader) Read(p []byte) (int, error) {
+	if q.err != nil {
+		return 0, q.err
+	}
+
+	n, err := q.Reader.Read(p)
+
+	if q.remaining >= 0 {
+		if q.remaining -= int64(n); q.remaining < 0 {
+			q.err = q.exceeded
+
+			return n, q.err
+		}
+	}
+
+	return n, err
+}
+
+// encodedSize returns the number of bytes written by the given WriterTo.
+func encodedSize(data io.WriterTo) uint64 {
+	w := rwcount.Writer{Writer: io.Discard}
+
+	data.WriteTo(&w)
+
+	return uint64(w.Count)
+}
@@ -0,0 +1,77 @@
+package battlemap
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"vimagination.zapto.org/keystore"
+)
+
+func TestQuota(t *testing.T) {
+	dir := t.TempDir()
+
+	store, err := keystore.NewFileStore(dir, dir, keystore.NoMangle)
+	if err != nil {
+		t.Fatalf("unexpected error creating store: %s", err)
+	}
+
+	store.Set("1", keystore.String(strings.Repeat("a", 40)))
+	store.Set("2", keystore.String(strings.Repeat("b", 30)))
+	store.Set("meta", keystore.String(strings.Repeat("c", 100)))
+
+	f := folders{
+		FileStore: store,
+		quota:     storeQuota{maxFileSize: 50, total: 100},
+		space:     storeSpace{sizes: make(map[uint64]uint64)},
+	}
+
+	f.updateUsage(1, 2)
+
+	for n, test := range [...]struct {
+		Write  map[string]int
+		Key    string
+		Size   uint64
+		Used   uint64
+		Err    error
+		Upload error
+	}{
+		{nil, "", 30, 70, nil, nil},
+		{nil, "", 31, 70, ErrQuotaExceeded, rejectionError(ErrQuotaExceeded.Error())},
+		{nil, "", 51, 70, ErrFileTooLarge, rejectionError(ErrQuotaExceeded.Error())},
+		{nil, "1", 50, 70, nil, nil},
+		{nil, "2", 40, 70, nil, nil},
+		{map[string]int{"3": 20}, "", 10, 90, nil, nil},
+		{nil, "", 11, 90, ErrQuotaExceeded, rejectionError(ErrQuotaExceeded.Error())},
+		{nil, "2", 41, 90, ErrQuotaExceeded, nil},
+		{nil, "3", 30, 90, nil, nil},
+		{map[string]int{"1": 0}, "", 41, 50, nil, nil},
+		{map[string]int{"2": -1}, "", 50, 20, nil, nil},
+		{map[string]int{"3": -1}, "", 51, 0, ErrFileTooLarge, rejectionError(ErrFileTooLarge.Error())},
+	} {
+		for key, size := range test.Write {
+			if size < 0 {
+				store.Remove(key)
+			} else {
+				store.Set(key, keystore.String(strings.Repeat("d", size)))
+			}
+
+			id := uint64(key[0] - '0')
+
+			f.updateUsage(id)
+		}
+
+		if used, _ := f.usedSpace(); used != test.Used {
+			t.Errorf("test %d: expecting %d bytes used, got %d", n+1, test.Used, used)
+		} else if err := f.checkSpace(test.Key, test.Size); err != test.Err {
+			t.Errorf("test %d: expecting error %v, got %v", n+1, test.Err, err)
+		} else if test.Key == "" {
+			q := f.limitReader(strings.NewReader(strings.Repeat("e", int(test.Size))))
+
+			if _, err := io.Copy(io.Discard, q); !errors.Is(err, test.Upload) {
+				t.Errorf("test %d: expecting upload error %v, got %v", n+1, test.Upload, err)
+			}
+		}
+	}
+}
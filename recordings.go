@@ -0,0 +1,431 @@
+package battlemap
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/websocket"
+	"vimagination.zapto.org/byteio"
+	"vimagination.zapto.org/keystore"
+)
+
+const (
+	recordingMagic   = "BMREC"
+	recordingVersion = 1
+)
+
+type recordingHeader struct {
+	Name           string
+	Start          time.Time
+	Map            uint64
+	JSON, UserJSON json.RawMessage
+}
+
+func (r *recordingHeader) WriteToX(lw *byteio.StickyLittleEndianWriter) {
+	lw.Write([]byte(recordingMagic))
+	lw.WriteUint8(recordingVersion)
+	lw.WriteStringX(r.Name)
+	lw.WriteInt64(r.Start.UnixNano())
+	lw.WriteUint64(r.Map)
+	lw.WriteBytesX(r.JSON)
+	lw.WriteBytesX(r.UserJSON)
+}
+
+func (r *recordingHeader) ReadFromX(lr *byteio.StickyLittleEndianReader, full bool) error {
+	var magic [len(recordingMagic)]byte
+
+	io.ReadFull(lr, magic[:])
+
+	if lr.Err != nil {
+		return lr.Err
+	} else if string(magic[:]) != recordingMagic || lr.ReadUint8() != recordingVersion {
+		return ErrInvalidRecording
+	}
+
+	r.Name = lr.ReadStringX()
+	r.Start = time.Unix(0, lr.ReadInt64())
+
+	if full {
+		r.Map = lr.ReadUint64()
+		r.JSON = lr.ReadBytesX()
+		r.UserJSON = lr.ReadBytesX()
+	}
+
+	return lr.Err
+}
+
+type recordingEntry struct {
+	Time time.Duration
+	Map  uint64
+	ID   int
+	User userStatus
+	Data json.RawMessage
+}
+
+func (r *recordingEntry) WriteToX(lw *byteio.StickyLittleEndianWriter) {
+	lw.WriteInt64(int64(r.Time))
+	lw.WriteUint64(r.Map)
+	lw.WriteInt16(int16(r.ID))
+	lw.WriteUint8(uint8(r.User))
+	lw.WriteBytesX(r.Data)
+}
+
+func (r *recordingEntry) ReadFromX(lr *byteio.StickyLittleEndianReader) {
+	r.Time = time.Duration(lr.ReadInt64())
+	r.Map = lr.ReadUint64()
+	r.ID = int(lr.ReadInt16())
+	r.User = userStatus(lr.ReadUint8())
+	r.Data = lr.ReadBytesX()
+}
+
+// replayable determines whether the entry would have been sent to a player
+// viewing the given map.
+func (r *recordingEntry) replayable(currentMap uint64) bool {
+	return r.User != userAdmin && (r.Map == 0 || r.Map == currentMap)
+}
+
+type recording struct {
+	file  *os.File
+	buf   *bufio.Writer
+	w     byteio.StickyLittleEndianWriter
+	start time.Time
+}
+
+func (r *recording) close() error {
+	r.buf.Flush()
+
+	return r.file.Close()
+}
+
+type recordingsDir struct {
+	*Battlemap
+	*keystore.FileStore
+	dir string
+
+	mu      sync.Mutex
+	lastID  uint64
+	current *recording
+}
+
+func (r *recordingsDir) Init(b *Battlemap, _ links) error {
+	var location keystore.String
+
+	if err := b.config.Get("RecordingsDir", &location); err != nil {
+		return fmt.Errorf("error retrieving recordings location: %w", err)
+	}
+
+	r.dir = filepath.Join(b.config.BaseDir, string(location))
+
+	var err error
+
+	if r.FileStore, err = keystore.NewFileStore(r.dir, r.dir, keystore.NoMangle); err != nil {
+		return fmt.Errorf("error creating recordings keystore: %w", err)
+	}
+
+	for _, key := range r.Keys() {
+		if id, err := strconv.ParseUint(key, 10, 64); err == nil && id > r.lastID {
+			r.lastID = id
+		}
+	}
+
+	r.Battlemap = b
+
+	return nil
+}
+
+func (r *recordingsDir) record(mapID uint64, id int, data json.RawMessage, user userStatus) {
+	r.mu.Lock()
+
+	if rec := r.current; rec != nil {
+		e := recordingEntry{
+			Time: time.Since(rec.start),
+			Map:  mapID,
+			ID:   id,
+			User: user,
+			Data: data,
+		}
+
+		e.WriteToX(&rec.w)
+
+		if rec.w.Err != nil || rec.buf.Flush() != nil {
+			rec.close()
+
+			r.current = nil
+		}
+	}
+
+	r.mu.Unlock()
+}
+
+func (r *recordingsDir) RPCData(cd ConnData, method string, data json.RawMessage) (interface{}, error) {
+	if method == "list" {
+		return r.list(), nil
+	} else if cd.IsAdmin() {
+		switch method {
+		case "start":
+			var name string
+
+			if err := json.Unmarshal(data, &name); err != nil {
+				return nil, err
+			}
+
+			return r.start(name)
+		case "stop":
+			return nil, r.stop()
+		case "remove":
+			var id uint64
+
+			if err := json.Unmarshal(data, &id); err != nil {
+				return nil, err
+			}
+
+			return nil, r.remove(id)
+		}
+	}
+
+	return nil, ErrUnknownMethod
+}
+
+func (r *recordingsDir) list() json.RawMessage {
+	buf := json.RawMessage{'['}
+	first := true
+
+	for _, key := range r.Keys() {
+		id, err := strconv.ParseUint(key, 10, 64)
+		if err != nil {
+			continue
+		}
+
+		var h recordingHeader
+
+		if err := r.Get(key, readerFromFunc(func(rd io.Reader) {
+			h.ReadFromX(&byteio.StickyLittleEndianReader{Reader: rd}, false)
+		})); err != nil || h.Start.IsZero() {
+			continue
+		}
+
+		if first {
+			first = false
+		} else {
+			buf = append(buf, ',')
+		}
+
+		buf = strconv.AppendUint(append(buf, "{\"id\":"...), id, 10)
+		buf = appendString(append(buf, ",\"name\":"...), h.Name)
+		buf = append(strconv.AppendInt(append(buf, ",\"start\":"...), h.Start.Unix(), 10), '}')
+	}
+
+	return append(buf, ']')
+}
+
+func (r *recordingsDir) start(name string) (uint64, error) {
+	var cu keystore.Uint64
+
+	r.config.Get("currentUserMap", &cu)
+
+	h := recordingHeader{
+		Name:  name,
+		Start: time.Now(),
+		Map:   uint64(cu),
+	}
+
+	r.maps.mu.RLock()
+
+	if mp, err := r.maps.getMap(h.Map); err == nil {
+		h.JSON = append(h.JSON, mp.JSON...)
+		h.UserJSON = append(h.UserJSON, mp.UserJSON...)
+	}
+
+	r.maps.mu.RUnlock()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.current != nil {
+		return 0, ErrAlreadyRecording
+	}
+
+	r.lastID++
+	id := r.lastID
+
+	f, err := os.OpenFile(filepath.Join(r.dir, strconv.FormatUint(id, 10)), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return 0, fmt.Errorf("error creating recording: %w", err)
+	}
+
+	rec := &recording{
+		file:  f,
+		buf:   bufio.NewWriter(f),
+		start: h.Start,
+	}
+	rec.w.Writer = rec.buf
+
+	h.WriteToX(&rec.w)
+
+	if rec.w.Err == nil {
+		rec.w.Err = rec.buf.Flush()
+	}
+
+	if rec.w.Err != nil {
+		rec.close()
+
+		return 0, fmt.Errorf("error writing recording header: %w", rec.w.Err)
+	}
+
+	r.current = rec
+
+	return id, nil
+}
+
+func (r *recordingsDir) stop() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.current == nil {
+		return ErrNotRecording
+	}
+
+	err := r.current.close()
+	r.current = nil
+
+	return err
+}
+
+func (r *recordingsDir) remove(id uint64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.current != nil && id == r.lastID {
+		return ErrCurrentlyRecording
+	}
+
+	key := strconv.FormatUint(id, 10)
+
+	if !r.Exists(key) {
+		return ErrUnknownRecording
+	}
+
+	return r.Remove(key)
+}
+
+// ServeHTTP upgrades requests from authorised users to a websocket over which a
+// recording is replayed.
+func (r *recordingsDir) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if !r.auth.IsAdmin(req) && !r.auth.IsUser(req) {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+
+		return
+	}
+
+	websocket.Handler(r.ServeConn).ServeHTTP(w, req)
+}
+
+// ServeConn replays a recording, given by the 'id' query parameter, to a
+// read-only websocket client. Only those broadcasts that would have been sent
+// to a player viewing the user map are replayed.
+//
+// The initial playback speed is set with the 'speed' query parameter, and can
+// be changed during playback by sending a number over the websocket.
+func (r *recordingsDir) ServeConn(wconn *websocket.Conn) {
+	defer wconn.Close()
+
+	q := wconn.Request().URL.Query()
+
+	id, err := strconv.ParseUint(q.Get("id"), 10, 64)
+	if err != nil {
+		return
+	}
+
+	f, err := os.Open(filepath.Join(r.dir, strconv.FormatUint(id, 10)))
+	if err != nil {
+		return
+	}
+
+	defer f.Close()
+
+	var (
+		h     recordingHeader
+		e     recordingEntry
+		last  time.Duration
+		speed atomic.Uint64
+	)
+
+	lr := byteio.StickyLittleEndianReader{Reader: bufio.NewReader(f)}
+
+	if err := h.ReadFromX(&lr, true); err != nil {
+		return
+	}
+
+	speed.Store(math.Float64bits(1))
+
+	if s, err := strconv.ParseFloat(q.Get("speed"), 64); err == nil && s > 0 {
+		speed.Store(math.Float64bits(s))
+	}
+
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		for {
+			var s float64
+
+			if err := websocket.JSON.Receive(wconn, &s); err != nil {
+				return
+			}
+
+			if s > 0 {
+				speed.Store(math.Float64bits(s))
+			}
+		}
+	}()
+
+	currentMap := h.Map
+
+	if len(h.UserJSON) > 0 {
+		if _, err := wconn.Write(buildBroadcast(broadcastCurrentUserMapData, h.UserJSON)); err != nil {
+			return
+		}
+	}
+
+	timer := time.NewTimer(0)
+
+	for {
+		e.ReadFromX(&lr)
+
+		if lr.Err != nil {
+			return
+		}
+
+		if e.ID == broadcastCurrentUserMapData {
+			currentMap = e.Map
+		}
+
+		if !e.replayable(currentMap) {
+			continue
+		}
+
+		timer.Reset(time.Duration(float64(e.Time-last) / math.Float64frombits(speed.Load())))
+
+		last = e.Time
+
+		select {
+		case <-done:
+			return
+		case <-timer.C:
+		}
+
+		if _, err := wconn.Write(buildBroadcast(e.ID, e.Data)); err != nil {
+			return
+		}
+	}
+}
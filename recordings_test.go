@@ -0,0 +1,105 @@
+package battlemap
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"vimagination.zapto.org/byteio"
+	"vimagination.zapto.org/memio"
+)
+
+func TestRecordingRoundTrip(t *testing.T) {
+	var buf memio.Buffer
+
+	h := recordingHeader{
+		Name:     "Session 1",
+		Start:    time.Unix(0, 1234567890),
+		Map:      3,
+		JSON:     json.RawMessage(`{"admin":true}`),
+		UserJSON: json.RawMessage(`{"admin":false}`),
+	}
+	entries := []recordingEntry{
+		{Time: time.Second, Map: 3, ID: broadcastTokenAdd, User: userAny, Data: json.RawMessage(`{"a":1}`)},
+		{Time: 2 * time.Second, Map: 0, ID: -1, User: userAdmin, Data: json.RawMessage(`null`)},
+		{Time: 3 * time.Second, Map: 4, ID: broadcastCurrentUserMapData, User: userNotAdmin, Data: json.RawMessage(`[]`)},
+	}
+
+	lw := byteio.StickyLittleEndianWriter{Writer: &buf}
+
+	h.WriteToX(&lw)
+
+	for n := range entries {
+		entries[n].WriteToX(&lw)
+	}
+
+	lr := byteio.StickyLittleEndianReader{Reader: &buf}
+
+	var rh recordingHeader
+
+	if err := rh.ReadFromX(&lr, true); err != nil {
+		t.Fatalf("unexpected error reading header: %s", err)
+	} else if !rh.Start.Equal(h.Start) {
+		t.Errorf("expecting start time %s, got %s", h.Start, rh.Start)
+	} else if rh.Start = h.Start; !reflect.DeepEqual(rh, h) {
+		t.Errorf("expecting header %v, got %v", h, rh)
+	}
+
+	for n, e := range entries {
+		var re recordingEntry
+
+		if re.ReadFromX(&lr); lr.Err != nil {
+			t.Fatalf("entry %d: unexpected error reading entry: %s", n+1, lr.Err)
+		} else if !reflect.DeepEqual(re, e) {
+			t.Errorf("entry %d: expecting %v, got %v", n+1, e, re)
+		}
+	}
+
+	if err := new(recordingHeader).ReadFromX(&byteio.StickyLittleEndianReader{Reader: &memio.Buffer{'B', 'M', 'X', 'X', 'X', 1}}, true); err != ErrInvalidRecording {
+		t.Errorf("expecting error %v, got %v", ErrInvalidRecording, err)
+	}
+}
+
+func TestRecordingReplayable(t *testing.T) {
+	for n, test := range [...]struct {
+		Map        uint64
+		User       userStatus
+		CurrentMap uint64
+		Replayable bool
+	}{
+		{0, userAny, 1, true},
+		{1, userAny, 1, true},
+		{2, userAny, 1, false},
+		{1, userNotAdmin, 1, true},
+		{0, userNotAdmin, 1, true},
+		{2, userNotAdmin, 1, false},
+		{1, userAdmin, 1, false},
+		{0, userAdmin, 1, false},
+	} {
+		e := recordingEntry{Map: test.Map, User: test.User}
+
+		if r := e.replayable(test.CurrentMap); r != test.Replayable {
+			t.Errorf("test %d: expecting replayable to be %v, got %v", n+1, test.Replayable, r)
+		}
+	}
+}
+
+type testNoAuth struct {
+	testAuth
+}
+
+func (testNoAuth) IsUser(*http.Request) bool { return false }
+
+func TestRecordingReplayAuth(t *testing.T) {
+	r := recordingsDir{Battlemap: &Battlemap{auth: testNoAuth{}}}
+	w := httptest.NewRecorder()
+
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/replay?id=1", nil))
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expecting status %d, got %d", http.StatusUnauthorized, w.Code)
+	}
+}
@@ -50,6 +50,7 @@ func (s *socket) ServeConn(wconn *websocket.Conn) {
 		ConnData: ConnData{
 			CurrentMap: uint64(cu),
 			ID:         id,
+			Player:     s.player(wconn.Request()),
 			userState:  s.authConn(wconn),
 		},
 	}
@@ -82,6 +83,7 @@ func SocketIDFromRequest(r *http.Request) ID {
 type ConnData struct {
 	CurrentMap uint64
 	ID         ID
+	Player     string
 	userState
 }
 
@@ -89,6 +91,7 @@ func (c *conn) HandleRPC(method string, data json.RawMessage) (interface{}, erro
 	cd := ConnData{
 		CurrentMap: atomic.LoadUint64(&c.CurrentMap),
 		ID:         ID(atomic.LoadUint64((*uint64)(&c.ID))),
+		Player:     c.Player,
 		userState:  c.userState,
 	}
 
@@ -101,13 +104,15 @@ func (c *conn) HandleRPC(method string, data json.RawMessage) (interface{}, erro
 			})
 		} else if cd.CurrentMap > 0 {
 			c.maps.mu.RLock()
-			mapData := c.maps.maps[uint64(cd.CurrentMap)]
+			mapData, err := c.maps.getMap(cd.CurrentMap)
 			c.maps.mu.RUnlock()
 
-			c.rpc.Send(jsonrpc.Response{
-				ID:     broadcastCurrentUserMapData,
-				Result: json.RawMessage(mapData.UserJSON),
-			})
+			if err == nil {
+				c.rpc.Send(jsonrpc.Response{
+					ID:     broadcastCurrentUserMapData,
+					Result: json.RawMessage(mapData.UserJSON),
+				})
+			}
 		}
 		return nil, nil
 	case "conn.currentTime":
@@ -181,7 +186,7 @@ func (c *conn) HandleRPC(method string, data json.RawMessage) (interface{}, erro
 				return c.audio.RPCData(cd, submethod, data)
 			}
 		case "characters":
-			if cd.IsAdmin() || submethod == "get" {
+			if cd.IsAdmin() || submethod == "get" || submethod == "modify" || submethod == "owned" {
 				return c.chars.RPCData(cd, submethod, data)
 			}
 		case "music":
@@ -202,6 +207,10 @@ func (c *conn) HandleRPC(method string, data json.RawMessage) (interface{}, erro
 			if cd.IsAdmin() || submethod == "list" {
 				return c.plugins.RPCData(cd, submethod, data)
 			}
+		case "recordings":
+			return c.recordings.RPCData(cd, submethod, data)
+		case "files":
+			return c.files.RPCData(cd, submethod, data)
 		}
 	}
 
@@ -2,6 +2,7 @@ package battlemap
 
 import (
 	"encoding/json"
+	"strconv"
 	"sync/atomic"
 )
 
@@ -105,6 +106,36 @@ const (
 	broadcastSignalPosition
 	broadcastSignalMovePosition
 	broadcastAny
+
+	broadcastImageFolderBulk
+	broadcastAudioFolderBulk
+	broadcastCharacterFolderBulk
+	broadcastMapFolderBulk
+
+	broadcastImageFolderOrder
+	broadcastAudioFolderOrder
+	broadcastCharacterFolderOrder
+	broadcastMapFolderOrder
+
+	broadcastFileItemAdd
+	broadcastFileItemMove
+	broadcastFileItemRemove
+	broadcastFileItemCopy
+	broadcastFileFolderAdd
+	broadcastFileFolderMove
+	broadcastFileFolderRemove
+	broadcastFileFolderBulk
+	broadcastFileFolderOrder
+	broadcastFileShare
+	broadcastFileShared
+	broadcastFileUnshared
+
+	broadcastCharacterSchemaSet
+	broadcastCharacterSchemaRemove
+
+	broadcastCharacterOwners
+	broadcastCharacterOwned
+	broadcastCharacterUnowned
 )
 
 func (s *socket) KickAdmins(except ID) {
@@ -127,6 +158,12 @@ func (c *conn) kickAdmin() {
 const broadcastStart = "{\"id\": -0,\"result\":"
 
 func buildBroadcast(id int, data json.RawMessage) []byte {
+	if id < -99 {
+		dat := strconv.AppendInt(append(make([]byte, 0, len(broadcastStart)+len(data)+2), "{\"id\":"...), int64(id), 10)
+
+		return append(append(append(dat, ",\"result\":"...), data...), '}')
+	}
+
 	l := len(broadcastStart) + len(data) + 1
 	dat := make([]byte, l)
 
@@ -147,6 +184,8 @@ func buildBroadcast(id int, data json.RawMessage) []byte {
 }
 
 func (s *socket) SetCurrentUserMap(currentUserMap uint64, data, mData json.RawMessage, except ID) {
+	s.recordings.record(0, broadcastCurrentUserMap, data, userAdmin)
+	s.recordings.record(currentUserMap, broadcastCurrentUserMapData, mData, userNotAdmin)
 	s.mu.RLock()
 
 	var dat, mdat json.RawMessage
@@ -185,6 +224,7 @@ const (
 )
 
 func (s *socket) broadcastMapChange(cd ConnData, id int, data json.RawMessage, user userStatus) {
+	s.recordings.record(cd.CurrentMap, id, data, user)
 	s.mu.RLock()
 
 	var dat json.RawMessage
@@ -205,6 +245,7 @@ func (s *socket) broadcastMapChange(cd ConnData, id int, data json.RawMessage, u
 }
 
 func (s *socket) broadcastAdminChange(id int, data json.RawMessage, except ID) {
+	s.recordings.record(0, id, data, userAdmin)
 	s.mu.RLock()
 
 	var dat json.RawMessage
@@ -222,6 +263,27 @@ func (s *socket) broadcastAdminChange(id int, data json.RawMessage, except ID) {
 	s.mu.RUnlock()
 }
 
+// broadcastPlayerChange sends a broadcast to each non-admin connection for
+// which the given function returns a non-zero broadcast ID.
+//
+// As these broadcasts are specific to individual players, they are not
+// recorded.
+func (s *socket) broadcastPlayerChange(fn func(ConnData) (int, json.RawMessage)) {
+	s.mu.RLock()
+
+	for c := range s.conns {
+		if c.IsAdmin() {
+			continue
+		}
+
+		if id, data := fn(c.ConnData); id != 0 {
+			go c.rpc.SendData(buildBroadcast(id, data))
+		}
+	}
+
+	s.mu.RUnlock()
+}
+
 type idName struct {
 	ID   uint64 `json:"id"`
 	Name string `json:"name"`
@@ -0,0 +1,344 @@
+package battlemap
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"vimagination.zapto.org/byteio"
+)
+
+type itemTags map[uint64][]string
+
+func (t itemTags) ReadFrom(r io.Reader) (int64, error) {
+	lr := byteio.StickyLittleEndianReader{Reader: r}
+
+	for l := lr.ReadUintX(); l > 0 && lr.Err == nil; l-- {
+		id := lr.ReadUintX()
+
+		var tags []string
+
+		for m := readLength(&lr); m > 0 && lr.Err == nil; m-- {
+			tags = append(tags, lr.ReadStringX())
+		}
+
+		t[id] = tags
+	}
+
+	return lr.Count, lr.Err
+}
+
+func (t itemTags) WriteTo(w io.Writer) (int64, error) {
+	lw := byteio.StickyLittleEndianWriter{Writer: w}
+
+	lw.WriteUintX(uint64(len(t)))
+
+	for id, tags := range t {
+		lw.WriteUintX(id)
+		lw.WriteUintX(uint64(len(tags)))
+
+		for _, tag := range tags {
+			lw.WriteStringX(tag)
+		}
+	}
+
+	return lw.Count, lw.Err
+}
+
+// initTags loads the item tags for the store.
+func (f *folders) initTags() error {
+	f.tags = make(itemTags)
+
+	if f.Exists(folderTags) {
+		if err := f.Get(folderTags, f.tags); err != nil {
+			return fmt.Errorf("error reading item tags: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// pruneTags removes the tags of items that are no longer in the store, or its
+// trash.
+func (f *folders) pruneTags() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	changed := false
+
+	for id := range f.tags {
+		idStr := strconv.FormatUint(id, 10)
+
+		if f.Exists(idStr) || f.trash != nil && f.trash.Exists(idStr) {
+			continue
+		}
+
+		delete(f.tags, id)
+
+		changed = true
+	}
+
+	if changed {
+		f.Set(folderTags, f.tags)
+	}
+}
+
+func normaliseTag(tag string) string {
+	return strings.Join(strings.Fields(strings.ToLower(tag)), " ")
+}
+
+// hasItem determines whether an item with the given ID is in any folder.
+//
+// The caller must hold f.mu.
+func (f *folders) hasItem(id uint64) bool {
+	return walkFolders(f.root, func(items map[string]uint64) bool {
+		for _, iid := range items {
+			if iid == id {
+				return true
+			}
+		}
+
+		return false
+	})
+}
+
+func (f *folders) setTags(data json.RawMessage, add bool) (json.RawMessage, error) {
+	var toTag struct {
+		ID   uint64   `json:"id"`
+		Tags []string `json:"tags"`
+	}
+
+	if err := json.Unmarshal(data, &toTag); err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !f.hasItem(toTag.ID) {
+		return nil, ErrItemNotFound
+	}
+
+	tags := f.tags[toTag.ID]
+
+	for _, tag := range toTag.Tags {
+		tag = normaliseTag(tag)
+
+		if tag == "" {
+			continue
+		}
+
+		if add && !slices.Contains(tags, tag) {
+			tags = append(tags, tag)
+		} else if !add {
+			tags = slices.DeleteFunc(tags, func(t string) bool { return t == tag })
+		}
+	}
+
+	slices.Sort(tags)
+
+	if len(tags) == 0 {
+		delete(f.tags, toTag.ID)
+	} else {
+		f.tags[toTag.ID] = tags
+	}
+
+	if err := f.Set(folderTags, f.tags); err != nil {
+		return nil, err
+	}
+
+	return appendKeys(nil, tags), nil
+}
+
+func (f *folders) getTags(data json.RawMessage) (json.RawMessage, error) {
+	var id uint64
+
+	if err := json.Unmarshal(data, &id); err != nil {
+		return nil, err
+	}
+
+	f.mu.RLock()
+	tags := slices.Clone(f.tags[id])
+	f.mu.RUnlock()
+
+	return appendKeys(nil, tags), nil
+}
+
+const maxSearchResults = 100
+
+type searchResult struct {
+	id    uint64
+	path  string
+	score int
+}
+
+// search returns the items whose names, folder paths, or tags match all of
+// the words of the given query, ordered by how well they match.
+//
+// Words in the query match words that are equal, that they are a prefix of,
+// or that are only a small number of edits away from them.
+func (f *folders) search(data json.RawMessage) (json.RawMessage, error) {
+	var query string
+
+	if err := json.Unmarshal(data, &query); err != nil {
+		return nil, err
+	}
+
+	terms := searchWords(query)
+	if len(terms) == 0 {
+		return json.RawMessage("[]"), nil
+	}
+
+	var results []searchResult
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	walkPaths(f.root, "/", func(path, name string, id uint64) {
+		nameWords := searchWords(name)
+		pathWords := searchWords(path)
+
+		var tagWords []string
+
+		for _, tag := range f.tags[id] {
+			tagWords = append(tagWords, searchWords(tag)...)
+		}
+
+		score := 0
+
+		for _, term := range terms {
+			s := max(matchWords(term, nameWords), matchWords(term, tagWords), matchWords(term, pathWords)/2)
+			if s == 0 {
+				return
+			}
+
+			score += s
+		}
+
+		results = append(results, searchResult{id, path + name, score})
+	})
+
+	slices.SortFunc(results, func(a, b searchResult) int {
+		if a.score != b.score {
+			return b.score - a.score
+		}
+
+		return strings.Compare(a.path, b.path)
+	})
+
+	if len(results) > maxSearchResults {
+		results = results[:maxSearchResults]
+	}
+
+	buf := json.RawMessage{'['}
+
+	for n, r := range results {
+		if n > 0 {
+			buf = append(buf, ',')
+		}
+
+		buf = strconv.AppendUint(append(buf, "{\"id\":"...), r.id, 10)
+		buf = appendKeys(append(appendString(append(buf, ",\"path\":"...), r.path), ",\"tags\":"...), slices.Clone(f.tags[r.id]))
+		buf = append(buf, '}')
+	}
+
+	return append(buf, ']'), nil
+}
+
+// walkPaths calls the given function for every item in the folder tree, with
+// the path of the folder containing the item.
+func walkPaths(f *folder, path string, fn func(path, name string, id uint64)) {
+	for name, id := range f.Items {
+		fn(path, name, id)
+	}
+
+	for name, g := range f.Folders {
+		walkPaths(g, path+name+"/", fn)
+	}
+}
+
+// searchWords splits the given string into lowercase words, treating any
+// character that is not a letter or digit as a separator.
+func searchWords(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// matchWords returns how well the given term matches the best of the given
+// words: 4 for an exact match, 3 for a prefix match, 2 for a fuzzy match, and
+// 0 for no match.
+func matchWords(term string, words []string) int {
+	best := 0
+
+	for _, word := range words {
+		switch {
+		case word == term:
+			return 4
+		case strings.HasPrefix(word, term):
+			best = max(best, 3)
+		case best < 2 && fuzzyMatch(term, word):
+			best = 2
+		}
+	}
+
+	return best
+}
+
+// fuzzyMatch determines whether the term is within a small edit distance of
+// the word, or of a prefix of the word; the allowed distance increases with
+// the length of the term.
+func fuzzyMatch(term, word string) bool {
+	a, b := []rune(term), []rune(word)
+
+	var allowed int
+
+	switch {
+	case len(a) < 4:
+		return false
+	case len(a) < 8:
+		allowed = 1
+	default:
+		allowed = 2
+	}
+
+	if len(b) > len(a)+allowed {
+		b = b[:len(a)+allowed]
+	}
+
+	prev, curr := make([]int, len(b)+1), make([]int, len(b)+1)
+
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		rowMin := i
+
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+			rowMin = min(rowMin, curr[j])
+		}
+
+		if rowMin > allowed {
+			return false
+		}
+
+		prev, curr = curr, prev
+	}
+
+	return slices.Min(prev) <= allowed
+}
+
+const folderTags = "tags"
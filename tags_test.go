@@ -0,0 +1,69 @@
+package battlemap
+
+import (
+	"reflect"
+	"testing"
+
+	"vimagination.zapto.org/byteio"
+	"vimagination.zapto.org/memio"
+)
+
+func TestMatchWords(t *testing.T) {
+	for n, test := range [...]struct {
+		Term  string
+		Words []string
+		Score int
+	}{
+		{"goblin", []string{"goblin", "archer"}, 4},
+		{"gob", []string{"goblin", "archer"}, 3},
+		{"goblni", []string{"goblin", "archer"}, 2},
+		{"gbolin", []string{"goblin", "archer"}, 0},
+		{"archr", []string{"goblin", "archer"}, 2},
+		{"arch", []string{"goblin", "archer"}, 3},
+		{"orc", []string{"goblin", "archer"}, 0},
+		{"achers", []string{"archers"}, 2},
+		{"skeleton", []string{"skeptical"}, 0},
+		{"skelton", []string{"skeletons"}, 2},
+		{"dragonbourn", []string{"dragonborn"}, 2},
+	} {
+		if score := matchWords(test.Term, test.Words); score != test.Score {
+			t.Errorf("test %d: expecting score %d for %q, got %d", n+1, test.Score, test.Term, score)
+		}
+	}
+}
+
+func TestItemTagsReadFrom(t *testing.T) {
+	for n, test := range [...]struct {
+		Write func(*byteio.StickyLittleEndianWriter)
+		Tags  itemTags
+		Err   error
+	}{
+		{
+			Write: func(lw *byteio.StickyLittleEndianWriter) {
+				itemTags{1: {"goblin", "archer"}, 2: {"orc"}}.WriteTo(lw.Writer)
+			},
+			Tags: itemTags{1: {"goblin", "archer"}, 2: {"orc"}},
+		},
+		{
+			Write: func(lw *byteio.StickyLittleEndianWriter) {
+				lw.WriteUintX(1)
+				lw.WriteUintX(1)
+				lw.WriteUintX(1 << 40)
+			},
+			Tags: itemTags{},
+			Err:  ErrInvalidData,
+		},
+	} {
+		var buf memio.Buffer
+
+		test.Write(&byteio.StickyLittleEndianWriter{Writer: &buf})
+
+		tags := make(itemTags)
+
+		if _, err := tags.ReadFrom(&buf); err != test.Err {
+			t.Errorf("test %d: expecting error %v, got %v", n+1, test.Err, err)
+		} else if test.Err == nil && !reflect.DeepEqual(tags, test.Tags) {
+			t.Errorf("test %d: expecting tags %v, got %v", n+1, test.Tags, tags)
+		}
+	}
+}
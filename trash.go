@@ -0,0 +1,240 @@
+package battlemap
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"vimagination.zapto.org/byteio"
+	"vimagination.zapto.org/keystore"
+)
+
+type trashItem struct {
+	Name    string
+	Deleted time.Time
+}
+
+type trashIndex map[uint64]trashItem
+
+func (t trashIndex) ReadFrom(r io.Reader) (int64, error) {
+	lr := byteio.StickyLittleEndianReader{Reader: r}
+
+	for l := lr.ReadUintX(); l > 0 && lr.Err == nil; l-- {
+		id := lr.ReadUintX()
+		t[id] = trashItem{
+			Name:    lr.ReadStringX(),
+			Deleted: time.Unix(lr.ReadInt64(), 0),
+		}
+	}
+
+	return lr.Count, lr.Err
+}
+
+func (t trashIndex) WriteTo(w io.Writer) (int64, error) {
+	lw := byteio.StickyLittleEndianWriter{Writer: w}
+
+	lw.WriteUintX(uint64(len(t)))
+
+	for id, item := range t {
+		lw.WriteUintX(id)
+		lw.WriteStringX(item.Name)
+		lw.WriteInt64(item.Deleted.Unix())
+	}
+
+	return lw.Count, lw.Err
+}
+
+// trash holds files removed from a store, so that they can be restored until
+// they are purged, either manually or after the retention period has passed.
+type trash struct {
+	*keystore.FileStore
+	from, dir string
+	retention time.Duration
+
+	mu    sync.Mutex
+	items trashIndex
+
+	// purged, when set, is called with the ID of each file permanently
+	// removed from the trash, with t.mu held.
+	purged func(id uint64)
+}
+
+func (t *trash) Init(c *config, name, from string) error {
+	var (
+		location  keystore.String
+		retention keystore.Uint64
+	)
+
+	if err := c.Get("TrashDir", &location); err != nil {
+		return fmt.Errorf("error getting trash directory: %w", err)
+	}
+
+	if err := c.Get("TrashRetention", &retention); err != nil {
+		return fmt.Errorf("error getting trash retention: %w", err)
+	}
+
+	t.from = from
+	t.dir = filepath.Join(c.BaseDir, string(location), name)
+	t.retention = time.Duration(retention) * time.Second
+	t.items = make(trashIndex)
+
+	var err error
+
+	if t.FileStore, err = keystore.NewFileStore(t.dir, t.dir, keystore.NoMangle); err != nil {
+		return fmt.Errorf("error creating trash store: %w", err)
+	}
+
+	if err := t.Get(trashMetadata, t.items); err != nil && !os.IsNotExist(err) && err != keystore.ErrUnknownKey {
+		return fmt.Errorf("error reading trash index: %w", err)
+	}
+
+	t.purgeExpired()
+
+	return nil
+}
+
+func (t *trash) save() error {
+	return t.Set(trashMetadata, t.items)
+}
+
+// add moves the file with the given ID from the store into the trash.
+func (t *trash) add(id uint64, name string) error {
+	key := strconv.FormatUint(id, 10)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if err := os.Rename(filepath.Join(t.from, key), filepath.Join(t.dir, key)); err != nil {
+		return err
+	}
+
+	t.items[id] = trashItem{
+		Name:    name,
+		Deleted: time.Now(),
+	}
+
+	t.purgeExpiredLocked()
+
+	return t.save()
+}
+
+// restore moves the file with the given ID from the trash back into the store,
+// returning the name it had when it was deleted.
+func (t *trash) restore(id uint64) (string, error) {
+	key := strconv.FormatUint(id, 10)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	item, ok := t.items[id]
+	if !ok {
+		return "", ErrItemNotFound
+	}
+
+	to := filepath.Join(t.from, key)
+
+	if _, err := os.Stat(to); err == nil {
+		return "", ErrDuplicateKey
+	}
+
+	if err := os.Rename(filepath.Join(t.dir, key), to); err != nil {
+		return "", err
+	}
+
+	delete(t.items, id)
+
+	if item.Name == "" {
+		item.Name = key
+	}
+
+	return item.Name, t.save()
+}
+
+// purge permanently removes the files with the given IDs from the trash. If no
+// IDs are given, the trash is emptied.
+func (t *trash) purge(ids []uint64) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(ids) == 0 {
+		for id := range t.items {
+			ids = append(ids, id)
+		}
+	}
+
+	for _, id := range ids {
+		if _, ok := t.items[id]; ok {
+			t.removeLocked(id)
+		}
+	}
+
+	return t.save()
+}
+
+func (t *trash) purgeExpired() {
+	t.mu.Lock()
+
+	if t.purgeExpiredLocked() {
+		t.save()
+	}
+
+	t.mu.Unlock()
+}
+
+func (t *trash) purgeExpiredLocked() bool {
+	if t.retention == 0 {
+		return false
+	}
+
+	before := time.Now().Add(-t.retention)
+	changed := false
+
+	for id, item := range t.items {
+		if item.Deleted.Before(before) {
+			t.removeLocked(id)
+
+			changed = true
+		}
+	}
+
+	return changed
+}
+
+// removeLocked permanently removes a file from the trash.
+//
+// The caller must hold t.mu.
+func (t *trash) removeLocked(id uint64) {
+	t.Remove(strconv.FormatUint(id, 10))
+	delete(t.items, id)
+
+	if t.purged != nil {
+		t.purged(id)
+	}
+}
+
+func (t *trash) list() json.RawMessage {
+	buf := json.RawMessage{'['}
+
+	t.mu.Lock()
+
+	for id, item := range t.items {
+		if len(buf) > 1 {
+			buf = append(buf, ',')
+		}
+
+		buf = strconv.AppendUint(append(buf, "{\"id\":"...), id, 10)
+		buf = appendString(append(buf, ",\"name\":"...), item.Name)
+		buf = append(strconv.AppendInt(append(buf, ",\"deleted\":"...), item.Deleted.Unix(), 10), '}')
+	}
+
+	t.mu.Unlock()
+
+	return append(buf, ']')
+}
+
+const trashMetadata = "trash"
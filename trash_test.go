@@ -0,0 +1,90 @@
+package battlemap
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestTrash(t *testing.T) {
+	var (
+		c    config
+		tr   trash
+		dir  = t.TempDir()
+		from = filepath.Join(dir, "store")
+	)
+
+	if err := c.Init(dir); err != nil {
+		t.Fatalf("unexpected error initialising config: %s", err)
+	} else if err := os.MkdirAll(from, 0o700); err != nil {
+		t.Fatalf("unexpected error creating store: %s", err)
+	}
+
+	for _, id := range [...]uint64{1, 2} {
+		if err := os.WriteFile(filepath.Join(from, strconv.FormatUint(id, 10)), nil, 0o600); err != nil {
+			t.Fatalf("unexpected error creating file: %s", err)
+		}
+	}
+
+	if err := tr.Init(&c, "test", from); err != nil {
+		t.Fatalf("unexpected error initialising trash: %s", err)
+	}
+
+	for n, test := range [...]struct {
+		Op      string
+		ID      uint64
+		Name    string
+		Err     error
+		InStore bool
+		InTrash bool
+	}{
+		{"add", 1, "a", nil, false, true},
+		{"add", 2, "", nil, false, true},
+		{"add", 3, "", os.ErrNotExist, false, false},
+		{"reload", 1, "", nil, false, true},
+		{"restore", 1, "a", nil, true, false},
+		{"restore", 1, "", ErrItemNotFound, true, false},
+		{"restore", 2, "2", nil, true, false},
+		{"add", 1, "b", nil, false, true},
+		{"create", 1, "", nil, true, true},
+		{"restore", 1, "", ErrDuplicateKey, true, true},
+		{"purge", 1, "", nil, true, false},
+		{"restore", 1, "", ErrItemNotFound, true, false},
+	} {
+		key := strconv.FormatUint(test.ID, 10)
+
+		var (
+			name string
+			err  error
+		)
+
+		switch test.Op {
+		case "add":
+			err = tr.add(test.ID, test.Name)
+		case "restore":
+			name, err = tr.restore(test.ID)
+		case "purge":
+			err = tr.purge([]uint64{test.ID})
+		case "create":
+			err = os.WriteFile(filepath.Join(from, key), nil, 0o600)
+		case "reload":
+			tr = trash{}
+			err = tr.Init(&c, "test", from)
+		}
+
+		_, statErr := os.Stat(filepath.Join(from, key))
+		_, inTrash := tr.items[test.ID]
+
+		if !errors.Is(err, test.Err) {
+			t.Errorf("test %d: expecting error %v, got %v", n+1, test.Err, err)
+		} else if test.Op == "restore" && name != test.Name {
+			t.Errorf("test %d: expecting name %q, got %q", n+1, test.Name, name)
+		} else if (statErr == nil) != test.InStore {
+			t.Errorf("test %d: expecting file in store to be %v", n+1, test.InStore)
+		} else if inTrash != test.InTrash {
+			t.Errorf("test %d: expecting file in trash to be %v", n+1, test.InTrash)
+		}
+	}
+}
@@ -0,0 +1,292 @@
+package battlemap
+
+import (
+	"encoding/json"
+	"slices"
+	"strconv"
+)
+
+// referencesID determines whether the given keyed data references the ID of
+// the given type.
+func referencesID(key string, data json.RawMessage, t linkType, id uint64) bool {
+	if getLinkType(key) != t {
+		return false
+	}
+
+	lm := make(linkManager)
+
+	lm.setJSONLinks(data)
+
+	_, ok := lm[id]
+
+	return ok
+}
+
+// itemPaths records the path of every item in the folder tree.
+func itemPaths(f *folder, path string, paths map[uint64]string) {
+	for name, id := range f.Items {
+		paths[id] = path + name
+	}
+
+	for name, g := range f.Folders {
+		itemPaths(g, path+name+"/", paths)
+	}
+}
+
+func appendKeys(buf json.RawMessage, keys []string) json.RawMessage {
+	slices.Sort(keys)
+
+	buf = append(buf, '[')
+
+	for n, key := range keys {
+		if n > 0 {
+			buf = append(buf, ',')
+		}
+
+		buf = appendString(buf, key)
+	}
+
+	return append(buf, ']')
+}
+
+// usage returns a JSON object detailing every map, token, character, music
+// pack, and plugin setting that references the given ID.
+func (b *Battlemap) usage(t linkType, id uint64) json.RawMessage {
+	buf := append(json.RawMessage{}, "{\"maps\":["...)
+	buf = b.maps.appendUsage(buf, t, id)
+	buf = append(buf, "],\"characters\":["...)
+	buf = b.chars.appendUsage(buf, t, id)
+	buf = append(buf, "],\"musicPacks\":["...)
+
+	if t == linkAudio {
+		buf = b.musicPacks.appendUsage(buf, id)
+	}
+
+	buf = append(buf, "],\"plugins\":["...)
+	buf = b.plugins.appendUsage(buf, t, id)
+
+	return append(buf, ']', '}')
+}
+
+func (m *mapsDir) appendUsage(buf json.RawMessage, t linkType, id uint64) json.RawMessage {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var mids []uint64
+
+	paths := make(map[uint64]string)
+
+	itemPaths(m.root, "/", paths)
+
+	for mid, refs := range m.refs {
+		if _, ok := paths[mid]; ok && slices.Contains(refs, reference{Type: t, ID: id}) {
+			mids = append(mids, mid)
+		}
+	}
+
+	slices.Sort(mids)
+
+	first := true
+
+	for _, mid := range mids {
+		mp, err := m.peekMap(mid)
+		if err != nil {
+			continue
+		}
+
+		var keys []string
+
+		for key, val := range mp.Data {
+			if referencesID(key, val, t, id) {
+				keys = append(keys, key)
+			}
+		}
+
+		var tids []uint64
+
+		for tid, tk := range mp.tokens {
+			if t == linkImage && tk.Source == id {
+				tids = append(tids, tid)
+
+				continue
+			}
+
+			for key, val := range tk.TokenData {
+				if referencesID(key, val.Data, t, id) {
+					tids = append(tids, tid)
+
+					break
+				}
+			}
+		}
+
+		slices.Sort(tids)
+
+		if first {
+			first = false
+		} else {
+			buf = append(buf, ',')
+		}
+
+		buf = strconv.AppendUint(append(buf, "{\"id\":"...), mid, 10)
+		buf = appendString(append(buf, ",\"path\":"...), paths[mid])
+		buf = appendKeys(append(buf, ",\"keys\":"...), keys)
+		buf = append(buf, ",\"tokens\":["...)
+
+		for n, tid := range tids {
+			if n > 0 {
+				buf = append(buf, ',')
+			}
+
+			tk := mp.tokens[tid]
+
+			var tkeys []string
+
+			for key, val := range tk.TokenData {
+				if referencesID(key, val.Data, t, id) {
+					tkeys = append(tkeys, key)
+				}
+			}
+
+			buf = strconv.AppendUint(append(buf, "{\"id\":"...), tid, 10)
+			buf = appendString(append(buf, ",\"layer\":"...), tk.layer.Name)
+			buf = strconv.AppendBool(append(buf, ",\"source\":"...), t == linkImage && tk.Source == id)
+			buf = append(appendKeys(append(buf, ",\"keys\":"...), tkeys), '}')
+		}
+
+		buf = append(buf, ']', '}')
+	}
+
+	return buf
+}
+
+func (c *charactersDir) appendUsage(buf json.RawMessage, t linkType, id uint64) json.RawMessage {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var cids []uint64
+
+	for strID, cd := range c.data {
+		cid, err := strconv.ParseUint(strID, 10, 64)
+		if err != nil {
+			continue
+		}
+
+		for key, val := range cd {
+			if referencesID(key, val.Data, t, id) {
+				cids = append(cids, cid)
+
+				break
+			}
+		}
+	}
+
+	slices.Sort(cids)
+
+	for n, cid := range cids {
+		if n > 0 {
+			buf = append(buf, ',')
+		}
+
+		var keys []string
+
+		for key, val := range c.data[strconv.FormatUint(cid, 10)] {
+			if referencesID(key, val.Data, t, id) {
+				keys = append(keys, key)
+			}
+		}
+
+		buf = strconv.AppendUint(append(buf, "{\"id\":"...), cid, 10)
+		buf = append(appendKeys(append(buf, ",\"keys\":"...), keys), '}')
+	}
+
+	return buf
+}
+
+func (m *musicPacksDir) appendUsage(buf json.RawMessage, id uint64) json.RawMessage {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var pids []uint64
+
+	for pid, p := range m.packs {
+		for _, track := range p.Tracks {
+			if track.ID == id {
+				pids = append(pids, pid)
+
+				break
+			}
+		}
+	}
+
+	slices.Sort(pids)
+
+	for n, pid := range pids {
+		if n > 0 {
+			buf = append(buf, ',')
+		}
+
+		p := m.packs[pid]
+
+		buf = strconv.AppendUint(append(buf, "{\"id\":"...), pid, 10)
+		buf = appendString(append(buf, ",\"name\":"...), p.Name)
+		buf = append(buf, ",\"tracks\":["...)
+		first := true
+
+		for t, track := range p.Tracks {
+			if track.ID != id {
+				continue
+			}
+
+			if first {
+				first = false
+			} else {
+				buf = append(buf, ',')
+			}
+
+			buf = strconv.AppendInt(buf, int64(t), 10)
+		}
+
+		buf = append(buf, ']', '}')
+	}
+
+	return buf
+}
+
+func (p *pluginsDir) appendUsage(buf json.RawMessage, t linkType, id uint64) json.RawMessage {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	var names []string
+
+	for name, pl := range p.plugins {
+		for key, val := range pl.Data {
+			if referencesID(key, val.Data, t, id) {
+				names = append(names, name)
+
+				break
+			}
+		}
+	}
+
+	slices.Sort(names)
+
+	for n, name := range names {
+		if n > 0 {
+			buf = append(buf, ',')
+		}
+
+		var keys []string
+
+		for key, val := range p.plugins[name].Data {
+			if referencesID(key, val.Data, t, id) {
+				keys = append(keys, key)
+			}
+		}
+
+		buf = appendString(append(buf, "{\"id\":"...), name)
+		buf = append(appendKeys(append(buf, ",\"keys\":"...), keys), '}')
+	}
+
+	return buf
+}
@@ -0,0 +1,111 @@
+package battlemap
+
+import (
+	"container/list"
+	"encoding/json"
+	"testing"
+
+	"vimagination.zapto.org/keystore"
+)
+
+func TestUsage(t *testing.T) {
+	var b Battlemap
+
+	dir := t.TempDir()
+
+	store, err := keystore.NewFileStore(dir, dir, keystore.NoMangle)
+	if err != nil {
+		t.Fatalf("unexpected error creating store: %s", err)
+	}
+
+	for id, mp := range map[string]*levelMap{
+		"1": {
+			Data: map[string]json.RawMessage{"store-image-bg": json.RawMessage("5")},
+			layer: layer{
+				Layers: []*layer{
+					{Name: "Layer", Tokens: []*token{{ID: 1, Source: 5, Width: 1, Height: 1}, {ID: 2, Source: 6, Width: 1, Height: 1}}},
+				},
+			},
+		},
+		"2": {
+			layer: layer{
+				Layers: []*layer{
+					{Name: "Tokens", Tokens: []*token{{ID: 3, Source: 6, Width: 1, Height: 1, TokenData: map[string]keystoreData{"store-image-alt": {Data: json.RawMessage("5")}}}}},
+				},
+			},
+		},
+		"3": {
+			layer: layer{
+				Layers: []*layer{
+					{Name: "Layer", Tokens: []*token{{ID: 1, Source: 5, Width: 1, Height: 1}}},
+				},
+			},
+		},
+	} {
+		if err := store.Set(id, mp); err != nil {
+			t.Fatalf("unexpected error writing map: %s", err)
+		}
+	}
+
+	b.maps.FileStore = store
+	b.maps.root = newFolder()
+	b.maps.root.Items["first"] = 1
+	b.maps.root.Folders["dir"] = newFolder()
+	b.maps.root.Folders["dir"].Items["second"] = 2
+	b.maps.maps = make(map[uint64]*list.Element)
+	b.maps.cacheSize = 1
+	b.maps.refs = referenceIndex{
+		1: {{Type: linkImage, ID: 5}, {Type: linkImage, ID: 6}},
+		2: {{Type: linkImage, ID: 5}, {Type: linkImage, ID: 6}},
+		3: {{Type: linkImage, ID: 5}},
+	}
+
+	if _, err := b.maps.getMap(1); err != nil {
+		t.Fatalf("unexpected error reading map: %s", err)
+	}
+
+	b.chars.data = map[string]characterData{
+		"7": {
+			"store-image-portrait": {User: true, Data: json.RawMessage("5")},
+			"store-audio-theme":    {Data: json.RawMessage("5")},
+		},
+		"8": {"store-image-portrait": {Data: json.RawMessage("6")}},
+	}
+	b.musicPacks.packs = map[uint64]*musicPack{
+		1: {Name: "Battle", Tracks: []musicTrack{{ID: 5}, {ID: 9}, {ID: 5}}},
+		2: {Name: "Tavern", Tracks: []musicTrack{{ID: 9}}},
+	}
+	b.plugins.plugins = map[string]*plugin{
+		"notes": {Data: map[string]keystoreData{"store-image-icon": {Data: json.RawMessage("[5,6]")}}},
+		"other": {Data: map[string]keystoreData{"store-image-icon": {Data: json.RawMessage("6")}}},
+	}
+
+	for n, test := range [...]struct {
+		Type  linkType
+		ID    uint64
+		Usage string
+	}{
+		{
+			linkImage, 5,
+			`{"maps":[{"id":1,"path":"\/first","keys":["store-image-bg"],"tokens":[{"id":1,"layer":"Layer","source":true,"keys":[]}]},{"id":2,"path":"\/dir\/second","keys":[],"tokens":[{"id":3,"layer":"Tokens","source":false,"keys":["store-image-alt"]}]}],"characters":[{"id":7,"keys":["store-image-portrait"]}],"musicPacks":[],"plugins":[{"id":"notes","keys":["store-image-icon"]}]}`,
+		},
+		{
+			linkAudio, 5,
+			`{"maps":[],"characters":[{"id":7,"keys":["store-audio-theme"]}],"musicPacks":[{"id":1,"name":"Battle","tracks":[0,2]}],"plugins":[]}`,
+		},
+		{
+			linkImage, 10,
+			`{"maps":[],"characters":[],"musicPacks":[],"plugins":[]}`,
+		},
+	} {
+		if usage := string(b.usage(test.Type, test.ID)); usage != test.Usage {
+			t.Errorf("test %d: expecting usage %s, got %s", n+1, test.Usage, usage)
+		}
+	}
+
+	if _, ok := b.maps.maps[2]; ok {
+		t.Error("expecting map 2 not to have been cached")
+	} else if _, ok := b.maps.maps[1]; !ok {
+		t.Error("expecting map 1 to remain cached")
+	}
+}
@@ -1,8 +1,13 @@
 package battlemap
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
 	"io"
 	"net/http"
+	"os"
+	"path/filepath"
 	"strconv"
 	"unsafe"
 )
@@ -15,23 +20,29 @@ const (
 	fileTypeAudio
 	fileTypeCharacter
 	fileTypeMap
+	fileTypeFile
 )
 
 type getFileType struct {
-	Buffer [512]byte
+	Buffer [sniffLength]byte
 	Type   fileType
+	Mime   string
 }
 
 func (g *getFileType) ReadFrom(r io.Reader) (int64, error) {
 	n, err := io.ReadFull(r, g.Buffer[:])
-	if err != nil {
+	if err == io.ErrUnexpectedEOF || err == io.EOF {
+		err = nil
+	} else if err != nil {
 		return int64(n), err
 	}
 
-	switch http.DetectContentType(g.Buffer[:n]) {
-	case "image/gif", "image/png", "image/jpeg", "image/webp", "video/apng":
+	g.Mime = sniffType(g.Buffer[:n])
+
+	switch g.Mime {
+	case "image/gif", "image/png", "image/jpeg", "image/webp", "video/apng", "image/avif", "image/svg+xml", "video/mp4", "video/webm":
 		g.Type = fileTypeImage
-	case "application/ogg", "audio/mpeg":
+	case "application/ogg", "audio/mpeg", "audio/wave", "audio/flac", "audio/webm":
 		g.Type = fileTypeAudio
 	case "application/x-gzip":
 		g.Type = fileTypeCharacter
@@ -44,6 +55,87 @@ func (g *getFileType) ReadFrom(r io.Reader) (int64, error) {
 	return int64(n), nil
 }
 
+// sniffLength is the number of bytes read from the start of a file in order to
+// determine its type.
+const sniffLength = 4096
+
+var (
+	mp4Brands   = [...]string{"isom", "iso2", "iso4", "iso5", "iso6", "mp41", "mp42", "avc1", "M4V ", "dash", "mmp4"}
+	webmVideo   = [...]string{"V_VP8", "V_VP9", "V_AV1"}
+	webmAudio   = [...]string{"A_OPUS", "A_VORBIS"}
+	svgPrefixes = [...]string{"<?xml", "<svg", "<!--", "<!DOCTYPE svg"}
+)
+
+// sniffType determines the MIME type of a file from its magic number, handling
+// those types not recognised by http.DetectContentType.
+func sniffType(buf []byte) string {
+	switch {
+	case len(buf) >= 12 && string(buf[4:8]) == "ftyp":
+		return sniffFtyp(buf)
+	case bytes.HasPrefix(buf, []byte("fLaC")):
+		return "audio/flac"
+	case bytes.HasPrefix(buf, []byte("\x1a\x45\xdf\xa3")):
+		return sniffEBML(buf)
+	}
+
+	text := bytes.TrimLeft(bytes.TrimPrefix(buf, []byte("\xef\xbb\xbf")), " \t\r\n")
+
+	for _, prefix := range svgPrefixes {
+		if bytes.HasPrefix(text, []byte(prefix)) && bytes.Contains(text, []byte("<svg")) {
+			return "image/svg+xml"
+		}
+	}
+
+	return http.DetectContentType(buf)
+}
+
+func sniffFtyp(buf []byte) string {
+	size := int(binary.BigEndian.Uint32(buf))
+	if size > len(buf) || size < 16 {
+		size = min(len(buf), 16)
+	}
+
+	brands := []string{string(buf[8:12])}
+
+	for n := 16; n+4 <= size; n += 4 {
+		brands = append(brands, string(buf[n:n+4]))
+	}
+
+	for _, brand := range brands {
+		if brand == "avif" || brand == "avis" {
+			return "image/avif"
+		}
+	}
+
+	for _, brand := range mp4Brands {
+		if brand == brands[0] {
+			return "video/mp4"
+		}
+	}
+
+	return "application/octet-stream"
+}
+
+func sniffEBML(buf []byte) string {
+	if !bytes.Contains(buf, []byte("webm")) {
+		return "application/octet-stream"
+	}
+
+	for _, codec := range webmVideo {
+		if bytes.Contains(buf, []byte(codec)) {
+			return "video/webm"
+		}
+	}
+
+	for _, codec := range webmAudio {
+		if bytes.Contains(buf, []byte(codec)) {
+			return "audio/webm"
+		}
+	}
+
+	return "video/webm"
+}
+
 func isRoot(path string) bool {
 	return path == "/" || path == ""
 }
@@ -69,3 +161,35 @@ func uniqueName(name string, checker func(string) bool) string {
 		}
 	}
 }
+
+// writeFileAtomic writes the data to a temporary file in the given directory,
+// syncs it to disk, and then renames it over the named file, so that a crash
+// will never leave a partially written file in place.
+func writeFileAtomic(dir, name string, data io.WriterTo) error {
+	f, err := os.CreateTemp(dir, "."+name+".*.tmp")
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(f)
+
+	if _, err = data.WriteTo(bw); err == nil {
+		if err = bw.Flush(); err == nil {
+			err = f.Sync()
+		}
+	}
+
+	if errr := f.Close(); err == nil {
+		err = errr
+	}
+
+	if err == nil {
+		err = os.Rename(f.Name(), filepath.Join(dir, name))
+	}
+
+	if err != nil {
+		os.Remove(f.Name())
+	}
+
+	return err
+}
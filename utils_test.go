@@ -0,0 +1,24 @@
+package battlemap
+
+import "testing"
+
+func TestSniffType(t *testing.T) {
+	for n, test := range [...]struct {
+		Data, Mime string
+	}{
+		{"\x00\x00\x00\x1cftypavif\x00\x00\x00\x00avifmif1miaf", "image/avif"},
+		{"\x00\x00\x00\x18ftypmif1\x00\x00\x00\x00mif1avif", "image/avif"},
+		{"\x00\x00\x00\x18ftypisom\x00\x00\x02\x00isomiso2", "video/mp4"},
+		{"\x1a\x45\xdf\xa3\x9f\x42\x82\x84webm\x86\x86A_OPUS", "audio/webm"},
+		{"\x1a\x45\xdf\xa3\x9f\x42\x82\x84webm\x86\x85V_VP9", "video/webm"},
+		{"fLaC\x00\x00\x00\x22", "audio/flac"},
+		{"RIFF\x00\x00\x00\x00WAVEfmt ", "audio/wave"},
+		{"\xef\xbb\xbf <?xml version=\"1.0\"?>\n<svg xmlns=\"http://www.w3.org/2000/svg\"></svg>", "image/svg+xml"},
+		{"<svg></svg>", "image/svg+xml"},
+		{"{\"width\":100}", "text/plain; charset=utf-8"},
+	} {
+		if mime := sniffType([]byte(test.Data)); mime != test.Mime {
+			t.Errorf("test %d: expecting type %q, got %q", n+1, test.Mime, mime)
+		}
+	}
+}
@@ -0,0 +1,283 @@
+package battlemap
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"vimagination.zapto.org/keystore"
+	"vimagination.zapto.org/memio"
+)
+
+type imageVariant struct {
+	Name string
+	Size int
+}
+
+// imageVariants lists the downscaled sizes that can be requested for an image,
+// with the Size being the maximum of the width and height.
+var imageVariants = [...]imageVariant{
+	{"thumb", 256},
+	{"medium", 1024},
+}
+
+const (
+	// maxVariantPixels limits the size of the images that will be decoded in
+	// order to generate a variant; larger images are served unscaled.
+	maxVariantPixels = 1 << 24
+
+	// maxVariantJobs limits the number of variants generated at once.
+	maxVariantJobs = 2
+)
+
+// variants stores downscaled copies of the images in an assetsDir, which are
+// generated when first requested.
+type variants struct {
+	*keystore.FileStore
+	dir  string
+	jobs chan struct{}
+
+	mu      sync.Mutex
+	pending map[string]chan struct{}
+}
+
+func (v *variants) Init(c *config) error {
+	var location keystore.String
+
+	if err := c.Get("ImageVariantsDir", &location); err != nil {
+		return fmt.Errorf("error getting image variants directory: %w", err)
+	}
+
+	v.dir = filepath.Join(c.BaseDir, string(location))
+	v.jobs = make(chan struct{}, maxVariantJobs)
+	v.pending = make(map[string]chan struct{})
+
+	var err error
+
+	if v.FileStore, err = keystore.NewFileStore(v.dir, v.dir, keystore.NoMangle); err != nil {
+		return fmt.Errorf("error creating image variants store: %w", err)
+	}
+
+	return nil
+}
+
+// prune removes all variants whose original image is no longer in the store.
+func (v *variants) prune(originals *keystore.FileStore) {
+	for _, key := range v.Keys() {
+		if id, _, ok := strings.Cut(key, "-"); !ok || !originals.Exists(id) {
+			v.Remove(key)
+		}
+	}
+}
+
+// lock waits until no other request holds the lock for the given variant, and
+// then takes it, returning the function that releases it.
+func (v *variants) lock(key string) func() {
+	for {
+		v.mu.Lock()
+
+		ch, ok := v.pending[key]
+		if !ok {
+			ch = make(chan struct{})
+			v.pending[key] = ch
+
+			v.mu.Unlock()
+
+			return func() {
+				v.mu.Lock()
+				delete(v.pending, key)
+				v.mu.Unlock()
+				close(ch)
+			}
+		}
+
+		v.mu.Unlock()
+
+		<-ch
+	}
+}
+
+// serveVariant writes the named variant of the image to the response, generating it
+// if it doesn't exist or is older than the original. If the image is already
+// smaller than the variant, or cannot be scaled, the original is served.
+func (a *assetsDir) serveVariant(w http.ResponseWriter, r *http.Request, name string) {
+	var size int
+
+	for _, v := range imageVariants {
+		if v.Name == name {
+			size = v.Size
+
+			break
+		}
+	}
+
+	id, err := strconv.ParseUint(r.URL.Path, 10, 64)
+	if err != nil || size == 0 {
+		http.NotFound(w, r)
+
+		return
+	}
+
+	idStr := strconv.FormatUint(id, 10)
+
+	fi, err := a.Stat(idStr)
+	if err != nil {
+		http.NotFound(w, r)
+
+		return
+	}
+
+	key := idStr + "-" + name
+
+	unlock := a.variants.lock(key)
+
+	vi, err := a.variants.Stat(key)
+	if err != nil || vi.ModTime().Before(fi.ModTime()) {
+		a.variants.jobs <- struct{}{}
+		err = a.makeVariant(idStr, key, size)
+		<-a.variants.jobs
+	}
+
+	unlock()
+
+	if err != nil {
+		a.handler.ServeHTTP(w, r)
+
+		return
+	}
+
+	f, err := os.Open(filepath.Join(a.variants.dir, key))
+	if err != nil {
+		a.handler.ServeHTTP(w, r)
+
+		return
+	}
+
+	defer f.Close()
+
+	if vi, err = f.Stat(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	w.Header().Del(contentType)
+	http.ServeContent(w, r, key, vi.ModTime(), f)
+}
+
+func (a *assetsDir) makeVariant(idStr, key string, size int) error {
+	var (
+		img    image.Image
+		format string
+		err    error
+	)
+
+	if errr := a.Get(idStr, readerFromFunc(func(r io.Reader) {
+		var (
+			header memio.Buffer
+			c      image.Config
+		)
+
+		if c, _, err = image.DecodeConfig(io.TeeReader(r, &header)); err != nil {
+			return
+		} else if c.Width <= size && c.Height <= size || c.Width*c.Height > maxVariantPixels {
+			err = ErrNoVariant
+
+			return
+		}
+
+		img, format, err = image.Decode(io.MultiReader(&header, r))
+	})); errr != nil {
+		return errr
+	} else if err != nil {
+		return err
+	}
+
+	var buf memio.Buffer
+
+	scaled := downscale(img, size)
+
+	if format == "jpeg" {
+		err = jpeg.Encode(&buf, scaled, &jpeg.Options{Quality: 85})
+	} else {
+		err = png.Encode(&buf, scaled)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(a.variants.dir, key, &buf)
+}
+
+// downscale resizes the image, maintaining its aspect ratio, so that neither
+// dimension exceeds the given size, averaging the source pixels that cover
+// each destination pixel.
+func downscale(src image.Image, size int) image.Image {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dw, dh := size, size
+
+	if w > h {
+		dh = max(h*size/w, 1)
+	} else {
+		dw = max(w*size/h, 1)
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+	s, fast := src.(image.RGBA64Image)
+
+	for y := 0; y < dh; y++ {
+		sy0 := b.Min.Y + y*h/dh
+		sy1 := max(b.Min.Y+(y+1)*h/dh, sy0+1)
+
+		for x := 0; x < dw; x++ {
+			sx0 := b.Min.X + x*w/dw
+			sx1 := max(b.Min.X+(x+1)*w/dw, sx0+1)
+
+			var r, g, bl, a, n uint64
+
+			for sy := sy0; sy < sy1; sy++ {
+				for sx := sx0; sx < sx1; sx++ {
+					var c color.RGBA64
+
+					if fast {
+						c = s.RGBA64At(sx, sy)
+					} else {
+						cr, cg, cb, ca := src.At(sx, sy).RGBA()
+						c = color.RGBA64{uint16(cr), uint16(cg), uint16(cb), uint16(ca)}
+					}
+
+					r += uint64(c.R)
+					g += uint64(c.G)
+					bl += uint64(c.B)
+					a += uint64(c.A)
+					n++
+				}
+			}
+
+			if a == 0 {
+				continue
+			}
+
+			dst.SetNRGBA(x, y, color.NRGBA{
+				R: uint8(r * 0xff / a),
+				G: uint8(g * 0xff / a),
+				B: uint8(bl * 0xff / a),
+				A: uint8(a / n >> 8),
+			})
+		}
+	}
+
+	return dst
+}
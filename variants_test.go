@@ -0,0 +1,29 @@
+package battlemap
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestDownscale(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 400, 100))
+
+	for x := 0; x < 400; x++ {
+		for y := 0; y < 100; y++ {
+			if x%2 == 0 {
+				src.SetNRGBA(x, y, color.NRGBA{R: 255, A: 255})
+			} else {
+				src.SetNRGBA(x, y, color.NRGBA{B: 255, A: 255})
+			}
+		}
+	}
+
+	dst := downscale(src, 200)
+
+	if b := dst.Bounds(); b.Dx() != 200 || b.Dy() != 50 {
+		t.Fatalf("expecting 200x50 image, got %dx%d", b.Dx(), b.Dy())
+	} else if c := dst.(*image.NRGBA).NRGBAAt(10, 10); c.R < 126 || c.R > 128 || c.B < 126 || c.B > 128 || c.A != 255 {
+		t.Errorf("expecting averaged colour, got %v", c)
+	}
+}
@@ -0,0 +1,157 @@
+package battlemap
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"vimagination.zapto.org/keystore"
+)
+
+// visibleAssets is a lazily built index of the image and audio assets that
+// non-admin users can currently access.
+//
+// An asset is visible if it is referenced by a visible layer, or the user data,
+// of the current user map, by the user data of an enabled plugin, by the user
+// data of a character referenced by either of those, or by a music pack that is
+// playing or referenced by any of them.
+type visibleAssets struct {
+	generation atomic.Uint64
+
+	mu        sync.Mutex
+	built     bool
+	builtFrom uint64
+	images    linkManager
+	audio     linkManager
+}
+
+// invalidate marks the index as stale, so that it is rebuilt on its next use.
+//
+// This method does not acquire any lock, and so can be called while holding
+// the locks of any module.
+func (v *visibleAssets) invalidate() {
+	v.generation.Add(1)
+}
+
+// assetVisible determines whether non-admin users can access the asset with
+// the given type and ID.
+func (b *Battlemap) assetVisible(t linkType, id uint64) bool {
+	v := &b.visible
+	gen := v.generation.Load()
+
+	v.mu.Lock()
+
+	if !v.built || v.builtFrom != gen {
+		v.mu.Unlock()
+
+		l := b.visibleLinks()
+
+		v.mu.Lock()
+
+		v.images, v.audio = l.images, l.audio
+		v.builtFrom = gen
+		v.built = true
+	}
+
+	lm := v.images
+
+	if t == linkAudio {
+		lm = v.audio
+	}
+
+	_, ok := lm[id]
+
+	v.mu.Unlock()
+
+	return ok
+}
+
+func (b *Battlemap) visibleLinks() links {
+	var cu keystore.Uint64
+
+	l := newLinks()
+
+	b.config.Get("currentUserMap", &cu)
+	b.maps.mu.RLock()
+
+	if mp, err := b.maps.getMap(uint64(cu)); err == nil {
+		l.setUserMapLinks(mp)
+	}
+
+	b.maps.mu.RUnlock()
+	b.plugins.mu.RLock()
+
+	for _, p := range b.plugins.plugins {
+		if !p.Enabled {
+			continue
+		}
+
+		for key, val := range p.Data {
+			if f := l.getLinkKey(key); val.User && f != nil {
+				f.setJSONLinks(val.Data)
+			}
+		}
+	}
+
+	b.plugins.mu.RUnlock()
+	b.chars.mu.RLock()
+
+	for id := range l.chars {
+		for key, val := range b.chars.data[strconv.FormatUint(id, 10)] {
+			if f := l.getLinkKey(key); val.User && f != nil && getLinkType(key) != linkChar {
+				f.setJSONLinks(val.Data)
+			}
+		}
+	}
+
+	b.chars.mu.RUnlock()
+	b.musicPacks.mu.RLock()
+
+	for id, p := range b.musicPacks.packs {
+		if _, ok := l.music[id]; !ok && p.PlayTime == 0 {
+			continue
+		}
+
+		for _, t := range p.Tracks {
+			l.audio.setLink(t.ID)
+		}
+	}
+
+	b.musicPacks.mu.RUnlock()
+
+	return l
+}
+
+// setUserMapLinks adds the links from the parts of the map that are sent to
+// users, which excludes hidden layers and token data that is not user-visible.
+func (l *links) setUserMapLinks(mp *levelMap) {
+	for key, value := range mp.Data {
+		if f := l.getLinkKey(key); f != nil {
+			f.setJSONLinks(value)
+		}
+	}
+
+	l.setUserLayerLinks(&mp.layer)
+}
+
+func (l *links) setUserLayerLinks(ly *layer) {
+	if ly.Hidden {
+		return
+	}
+
+	for _, c := range ly.Layers {
+		l.setUserLayerLinks(c)
+	}
+
+	for _, t := range ly.Tokens {
+		if t.Source > 0 {
+			l.images.setLink(t.Source)
+		}
+
+		for key, val := range t.TokenData {
+			if f := l.getLinkKey(key); val.User && f != nil {
+				f.setJSONLinks(val.Data)
+			}
+		}
+	}
+}
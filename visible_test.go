@@ -0,0 +1,132 @@
+package battlemap
+
+import (
+	"container/list"
+	"encoding/json"
+	"testing"
+
+	"vimagination.zapto.org/keystore"
+)
+
+func TestAssetVisible(t *testing.T) {
+	var b Battlemap
+
+	if err := b.config.Init(t.TempDir()); err != nil {
+		t.Fatalf("unexpected error initialising config: %s", err)
+	}
+
+	b.config.Set("currentUserMap", keystore.Uint64(1))
+
+	b.maps.maps = make(map[uint64]*list.Element)
+	b.maps.cacheSize = 10
+
+	b.maps.cacheMapLocked(1, &levelMap{
+		Data: map[string]json.RawMessage{
+			"store-image-bg":   json.RawMessage("10"),
+			"store-audio-bg":   json.RawMessage("30"),
+			"store-music-pack": json.RawMessage("3"),
+		},
+		layer: layer{
+			Layers: []*layer{
+				{
+					Name: "Visible",
+					Tokens: []*token{
+						{
+							Source: 1,
+							TokenData: map[string]keystoreData{
+								"store-image-user":     {User: true, Data: json.RawMessage("2")},
+								"store-image-admin":    {Data: json.RawMessage("3")},
+								"store-character-user": {User: true, Data: json.RawMessage("7")},
+							},
+						},
+					},
+				},
+				{
+					Name:   "Hidden",
+					Hidden: true,
+					Tokens: []*token{
+						{
+							Source: 4,
+							TokenData: map[string]keystoreData{
+								"store-image-user": {User: true, Data: json.RawMessage("5")},
+							},
+						},
+					},
+					Layers: []*layer{
+						{Name: "Child", Tokens: []*token{{Source: 6}}},
+					},
+				},
+			},
+		},
+	})
+
+	b.chars.data = map[string]characterData{
+		"7": {
+			"store-image-portrait": {User: true, Data: json.RawMessage("8")},
+			"store-image-secret":   {Data: json.RawMessage("9")},
+		},
+		"14": {
+			"store-image-portrait": {User: true, Data: json.RawMessage("15")},
+		},
+	}
+	b.plugins.plugins = map[string]*plugin{
+		"enabled": {
+			Enabled: true,
+			Data: map[string]keystoreData{
+				"store-image-user":  {User: true, Data: json.RawMessage("11")},
+				"store-image-admin": {Data: json.RawMessage("12")},
+			},
+		},
+		"disabled": {
+			Data: map[string]keystoreData{
+				"store-image-user": {User: true, Data: json.RawMessage("13")},
+			},
+		},
+	}
+	b.musicPacks.packs = map[uint64]*musicPack{
+		1: {Name: "Playing", PlayTime: 1, Tracks: []musicTrack{{ID: 20}}},
+		2: {Name: "Stopped", Tracks: []musicTrack{{ID: 21}}},
+		3: {Name: "Referenced", Tracks: []musicTrack{{ID: 22}}},
+	}
+
+	for n, test := range [...]struct {
+		Type    linkType
+		ID      uint64
+		Visible bool
+	}{
+		{linkImage, 10, true},
+		{linkImage, 1, true},
+		{linkImage, 2, true},
+		{linkImage, 3, false},
+		{linkImage, 4, false},
+		{linkImage, 5, false},
+		{linkImage, 6, false},
+		{linkImage, 8, true},
+		{linkImage, 9, false},
+		{linkImage, 15, false},
+		{linkImage, 11, true},
+		{linkImage, 12, false},
+		{linkImage, 13, false},
+		{linkAudio, 30, true},
+		{linkAudio, 20, true},
+		{linkAudio, 21, false},
+		{linkAudio, 22, true},
+		{linkAudio, 10, false},
+	} {
+		if visible := b.assetVisible(test.Type, test.ID); visible != test.Visible {
+			t.Errorf("test %d: expecting asset %d to have visibility %v", n+1, test.ID, test.Visible)
+		}
+	}
+
+	b.plugins.plugins["disabled"].Enabled = true
+
+	if b.assetVisible(linkImage, 13) {
+		t.Error("expecting stale index to be used before invalidation")
+	}
+
+	b.visible.invalidate()
+
+	if !b.assetVisible(linkImage, 13) {
+		t.Error("expecting asset from newly enabled plugin to be visible after invalidation")
+	}
+}